@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/codanael/etcd-secret-reader/pkg/decrypt"
+	"github.com/codanael/etcd-secret-reader/pkg/etcdreader"
+	"github.com/codanael/etcd-secret-reader/pkg/output"
+	"github.com/spf13/cobra"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+)
+
+// newListCommand lists secrets, objects of a resource type or every key
+// without decrypting anything
+func newListCommand(opts *globalOptions) *cobra.Command {
+	var resourceName string
+	var allKeys bool
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List secrets, or other objects, without decrypting them",
+		Example: `  etcd-secret-reader list --snapshot=snapshot.db
+  etcd-secret-reader list --snapshot=snapshot.db --resource=deployments.apps
+  etcd-secret-reader list --snapshot=snapshot.db --all-keys
+  etcd-secret-reader list --snapshot=snapshot.db -o table`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if allKeys && resourceName != "" {
+				return fmt.Errorf("use either --all-keys or --resource, not both")
+			}
+
+			if opts.output != "text" && !output.IsStructured(opts.output) {
+				return fmt.Errorf("unsupported output format %q (expected text, json, ndjson or table)", opts.output)
+			}
+
+			reader, err := opts.openReader()
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			// Records describe each key and its encryption key, still without decrypting
+			records := recordWriter(opts.output)
+
+			switch {
+			case allKeys && records != nil:
+				return writeKeyRecords(records, reader, []string{""}, opts.revision)
+			case allKeys:
+				return printAllKeys(reader, opts.revision)
+			case resourceName != "":
+				registry, err := opts.registry(reader)
+				if err != nil {
+					return err
+				}
+				info, err := registry.Lookup(resourceName)
+				if err != nil {
+					return err
+				}
+				if records != nil {
+					return writeKeyRecords(records, reader, info.Prefixes(), opts.revision)
+				}
+				keys, err := listResourceKeys(reader, info, opts.revision)
+				if err != nil {
+					return fmt.Errorf("listing %s: %w", info.GroupResource(), err)
+				}
+				fmt.Printf("%s in snapshot%s (%d found):\n", info.GroupResource(), revisionSuffix(opts.revision), len(keys))
+				for _, k := range keys {
+					fmt.Printf("  %s\n", safePrintKey(k))
+				}
+				return nil
+			case records != nil:
+				return writeKeyRecords(records, reader, secretPrefixes, opts.revision)
+			default:
+				secrets, err := listSecrets(reader, opts.revision)
+				if err != nil {
+					return fmt.Errorf("listing secrets: %w", err)
+				}
+				fmt.Printf("Secrets in snapshot%s (%d found):\n", revisionSuffix(opts.revision), len(secrets))
+				if len(secrets) == 0 {
+					fmt.Println("  (no secrets found)")
+					fmt.Println("\nTip: Use list --all-keys to see all keys in the snapshot and verify the correct prefix.")
+				}
+				for _, s := range secrets {
+					fmt.Printf("  %s\n", s)
+				}
+				return nil
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&resourceName, "resource", "", "List objects of this resource type instead of secrets, e.g. configmaps or deployments.apps")
+	cmd.Flags().BoolVar(&allKeys, "all-keys", false, "List every key in the snapshot (for debugging)")
+	cmd.RegisterFlagCompletionFunc("resource", completeResources)
+	return cmd
+}
+
+// printAllKeys prints every key in the snapshot, highlighting secrets
+func printAllKeys(reader *etcdreader.Reader, revision int64) error {
+	keys, err := listAllKeys(reader, revision)
+	if err != nil {
+		return fmt.Errorf("listing all keys: %w", err)
+	}
+	fmt.Printf("All keys in snapshot%s (%d total):\n", revisionSuffix(revision), len(keys))
+
+	// Count how many are secrets
+	secretCount := 0
+	for _, k := range keys {
+		if strings.HasPrefix(k, "/registry/secrets/") {
+			secretCount++
+		}
+	}
+
+	if secretCount > 0 {
+		fmt.Printf("  (%d keys match /registry/secrets/ prefix)\n\n", secretCount)
+	} else {
+		fmt.Println("  (no keys match /registry/secrets/ prefix)")
+		fmt.Println()
+	}
+
+	for _, k := range keys {
+		safeKey := safePrintKey(k)
+		// Highlight secrets
+		if strings.HasPrefix(k, "/registry/secrets/") {
+			fmt.Printf("  [SECRET] %s\n", safeKey)
+		} else {
+			fmt.Printf("  %s\n", safeKey)
+		}
+	}
+	return nil
+}
+
+// newGetCommand decrypts and prints one secret or object
+func newGetCommand(opts *globalOptions) *cobra.Command {
+	var namespace, resourceName, outputDir string
+
+	cmd := &cobra.Command{
+		Use:   "get NAME",
+		Short: "Decrypt and print one secret, or one object of another resource type",
+		Example: `  etcd-secret-reader get db-password -n prod --snapshot=snapshot.db --key=<base64>
+  etcd-secret-reader get db-password -n prod --snapshot=snapshot.db --key=<base64> -o yaml
+  etcd-secret-reader get coredns -n kube-system --snapshot=snapshot.db --resource=configmaps`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+
+			reader, err := opts.openReader()
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			if resourceName != "" {
+				return runResources(opts, reader, resourceName, namespace, name)
+			}
+
+			if namespace == "" {
+				return fmt.Errorf("--namespace is required for secrets")
+			}
+			decryptor, err := opts.secretDecryptor()
+			if err != nil {
+				return err
+			}
+			defer closeDecryptor(decryptor)
+
+			out, err := newSecretOutput(opts.output, outputDir, opts.redaction)
+			if err != nil {
+				return err
+			}
+
+			// Try both standard Kubernetes and OpenShift secret paths
+			var kv *mvccpb.KeyValue
+			var etcdKey string
+			for _, prefix := range secretPrefixes {
+				etcdKey = prefix + namespace + "/" + name
+				kv, err = getKeyValue(reader, etcdKey, opts.revision)
+				if err == nil {
+					break
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("reading secret: %w", err)
+			}
+
+			decryptedData, err := decryptor.DecryptValue(etcdKey, kv.Value)
+			if err != nil {
+				return fmt.Errorf("decrypting secret: %w", err)
+			}
+			if err := out.print(kv, namespace, name, decryptedData); err != nil {
+				return fmt.Errorf("parsing secret: %w", err)
+			}
+			return out.flush()
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Namespace of the secret or object")
+	cmd.Flags().StringVar(&resourceName, "resource", "", "Read an object of this resource type instead of a secret, e.g. configmaps or deployments.apps")
+	cmd.Flags().StringVar(&outputDir, "output-dir", "", "Write the secret manifest to <dir>/<namespace>/<name>.<yaml|json> instead of stdout")
+	cmd.RegisterFlagCompletionFunc("resource", completeResources)
+	cmd.MarkFlagDirname("output-dir")
+	return cmd
+}
+
+// newDumpCommand decrypts and prints every secret or object of a namespace,
+// or of the whole snapshot with --all
+func newDumpCommand(opts *globalOptions) *cobra.Command {
+	var namespace, resourceName, outputDir string
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Decrypt and print every secret, or object, of a namespace or of the whole snapshot",
+		Long: `Decrypt and print every secret, or object of the type given by --resource,
+in the namespace given by --namespace.
+
+Decrypting the whole snapshot prints every credential of the cluster, so it
+has to be asked for explicitly with --all.`,
+		Example: `  etcd-secret-reader dump -n prod --snapshot=snapshot.db --key=<base64>
+  etcd-secret-reader dump --all --snapshot=snapshot.db --key=<base64> -o yaml --output-dir=./backup --reveal
+  etcd-secret-reader dump --all --snapshot=snapshot.db --resource=configmaps`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if namespace == "" && !all {
+				return fmt.Errorf("--namespace or --all is required")
+			}
+			if namespace != "" && all {
+				return fmt.Errorf("use either --namespace or --all, not both")
+			}
+
+			reader, err := opts.openReader()
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			if resourceName != "" {
+				return runResources(opts, reader, resourceName, namespace, "")
+			}
+
+			decryptor, err := opts.secretDecryptor()
+			if err != nil {
+				return err
+			}
+			defer closeDecryptor(decryptor)
+
+			out, err := newSecretOutput(opts.output, outputDir, opts.redaction)
+			if err != nil {
+				return err
+			}
+
+			if err := dumpSecrets(reader, decryptor, out, namespace, opts.revision); err != nil {
+				return err
+			}
+			return out.flush()
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Only dump secrets or objects in this namespace")
+	cmd.Flags().BoolVar(&all, "all", false, "Dump every namespace of the snapshot")
+	cmd.Flags().StringVar(&resourceName, "resource", "", "Dump objects of this resource type instead of secrets, e.g. configmaps or deployments.apps")
+	cmd.Flags().StringVar(&outputDir, "output-dir", "", "Write secret manifests to <dir>/<namespace>/<name>.<yaml|json> instead of stdout")
+	cmd.RegisterFlagCompletionFunc("resource", completeResources)
+	cmd.MarkFlagDirname("output-dir")
+	return cmd
+}
+
+// dumpSecrets decrypts and prints every secret in namespace, or every secret
+// when namespace is empty; secrets that cannot be read are reported through out
+func dumpSecrets(reader *etcdreader.Reader, decryptor decrypt.ValueDecryptor, out *secretOutput, namespace string, revision int64) error {
+	secrets, err := listSecrets(reader, revision)
+	if err != nil {
+		return fmt.Errorf("listing secrets: %w", err)
+	}
+
+	for _, secretPath := range secrets {
+		ns, name := parseSecretPath(secretPath)
+		if namespace != "" && ns != namespace {
+			continue
+		}
+
+		kv, err := getKeyValue(reader, secretPath, revision)
+		if err != nil {
+			out.fail(secretPath, nil, "could not read", err)
+			continue
+		}
+
+		decryptedData, err := decryptor.DecryptValue(secretPath, kv.Value)
+		if err != nil {
+			out.fail(secretPath, kv, "could not decrypt", err)
+			continue
+		}
+
+		if err := out.print(kv, ns, name, decryptedData); err != nil {
+			out.fail(secretPath, kv, "could not parse", err)
+		}
+		if out.separate() {
+			fmt.Println()
+		}
+	}
+	return nil
+}
+
+// runResources prints objects of a resource type; most resources are stored
+// unencrypted, so keys are optional
+func runResources(opts *globalOptions, reader *etcdreader.Reader, resourceName, namespace, name string) error {
+	// Custom resources are only known from the CRDs stored in the snapshot
+	registry, err := opts.registry(reader)
+	if err != nil {
+		return err
+	}
+	info, err := registry.Lookup(resourceName)
+	if err != nil {
+		return err
+	}
+
+	decryptor, err := opts.resourceDecryptor(info.GroupResource())
+	if err != nil {
+		return err
+	}
+	defer closeDecryptor(decryptor)
+
+	if err := showResources(reader, decryptor, registry, info, namespace, name, opts.revision, opts.output, opts.redaction); err != nil {
+		return fmt.Errorf("reading %s: %w", info.GroupResource(), err)
+	}
+	return nil
+}
+
+// newHistoryCommand decrypts every stored version of one secret
+func newHistoryCommand(opts *globalOptions) *cobra.Command {
+	var namespace string
+
+	cmd := &cobra.Command{
+		Use:     "history NAME",
+		Short:   "Decrypt and print every stored version of a secret",
+		Example: `  etcd-secret-reader history db-password -n prod --snapshot=snapshot.db --key=<base64>`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if namespace == "" {
+				return fmt.Errorf("--namespace is required")
+			}
+
+			reader, err := opts.openReader()
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			decryptor, err := opts.secretDecryptor()
+			if err != nil {
+				return err
+			}
+			defer closeDecryptor(decryptor)
+
+			if err := showHistory(reader, decryptor, opts.redaction, namespace, args[0], opts.output); err != nil {
+				return fmt.Errorf("reading secret history: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Namespace of the secret")
+	return cmd
+}
+
+// newInfoCommand prints the snapshot metadata
+func newInfoCommand(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:     "info",
+		Short:   "Show snapshot metadata (consistent index, term, revisions)",
+		Example: `  etcd-secret-reader info --snapshot=snapshot.db -o json`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reader, err := opts.openReader()
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			if err := showInfo(reader, opts.output); err != nil {
+				return fmt.Errorf("reading snapshot metadata: %w", err)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/codanael/etcd-secret-reader/pkg/output"
+	"github.com/codanael/etcd-secret-reader/pkg/redact"
+	"github.com/codanael/etcd-secret-reader/pkg/snapdiff"
+	"github.com/spf13/cobra"
+)
+
+// changeSymbols prefixes changes in text output, as in a unified diff
+var changeSymbols = map[snapdiff.ChangeType]string{
+	snapdiff.Added:    "+",
+	snapdiff.Removed:  "-",
+	snapdiff.Modified: "~",
+}
+
+// newDiffCommand compares two snapshots
+func newDiffCommand(opts *globalOptions) *cobra.Command {
+	var namespace string
+
+	cmd := &cobra.Command{
+		Use:   "diff OLD NEW",
+		Short: "Show the keys added, removed and modified between two snapshots",
+		Long: `Compare the latest revision of two snapshots and list the keys added, removed
+and modified, grouped by resource type and namespace.
+
+For Secrets and ConfigMaps present in both, values are decrypted with --key or
+--encryption-config and the data keys that changed are listed with the length
+and fingerprint of their values, or the values themselves with --reveal.`,
+		Example: `  etcd-secret-reader diff yesterday.db today.db --encryption-config=encryption-config.yaml
+  etcd-secret-reader diff yesterday.db today.db -n prod --reveal-key=password`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.revision != 0 {
+				return fmt.Errorf("--revision is not supported by diff, the latest revisions are compared")
+			}
+			if opts.snapshot != "" || opts.dataDir != "" {
+				return fmt.Errorf("--snapshot and --data-dir are not supported by diff, pass both snapshots as arguments")
+			}
+			if opts.output != "text" && !output.IsStructured(opts.output) {
+				return fmt.Errorf("unsupported output format %q (expected text, json, ndjson or table)", opts.output)
+			}
+
+			if args[0] == "-" && args[1] == "-" {
+				return fmt.Errorf("only one snapshot can be read from stdin")
+			}
+
+			oldReader, err := opts.openSnapshot(args[0])
+			if err != nil {
+				return fmt.Errorf("opening %s: %w", args[0], err)
+			}
+			defer oldReader.Close()
+			newReader, err := opts.openSnapshot(args[1])
+			if err != nil {
+				return fmt.Errorf("opening %s: %w", args[1], err)
+			}
+			defer newReader.Close()
+
+			changes, err := snapdiff.Compare(oldReader, newReader, snapdiff.Options{
+				Namespace: namespace,
+				Decryptor: opts.resourceDecryptor,
+			})
+			if err != nil {
+				return err
+			}
+
+			if opts.output == "text" {
+				// Text masks hidden values, which needs them
+				printChanges(changes, opts.redaction)
+				return nil
+			}
+
+			// Records carry no masks, hidden values are left out
+			records := snapdiff.Hide(changes, opts.redaction.Reveals)
+			switch opts.output {
+			case output.FormatJSON:
+				if records == nil {
+					records = []snapdiff.Change{}
+				}
+				return output.WriteJSON(os.Stdout, records)
+			case output.FormatNDJSON:
+				enc := json.NewEncoder(os.Stdout)
+				for _, c := range records {
+					if err := enc.Encode(c); err != nil {
+						return err
+					}
+				}
+				return nil
+			default:
+				return printChangeTable(records)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Only compare the objects of this namespace")
+	return cmd
+}
+
+// printChanges prints changes under a header per resource and namespace,
+// followed by a count of each kind of change; data values are masked unless
+// policy reveals them
+func printChanges(changes []snapdiff.Change, policy *redact.Policy) {
+	counts := map[snapdiff.ChangeType]int{}
+	group := "\x00"
+	for _, c := range changes {
+		counts[c.Type]++
+
+		header := "other keys"
+		name := safePrintKey(c.Key)
+		if c.Resource != "" {
+			header = c.Resource
+			if c.Namespace != "" {
+				header += " in " + safePrintKey(c.Namespace)
+			}
+			name = safePrintKey(c.Name)
+		}
+		if header != group {
+			if group != "\x00" {
+				fmt.Println()
+			}
+			fmt.Printf("%s:\n", header)
+			group = header
+		}
+
+		switch c.Type {
+		case snapdiff.Modified:
+			fmt.Printf("  %s %s (revision %d -> %d)\n", changeSymbols[c.Type], name, c.OldRevision, c.NewRevision)
+		case snapdiff.Added:
+			fmt.Printf("  %s %s (revision %d)\n", changeSymbols[c.Type], name, c.NewRevision)
+		case snapdiff.Removed:
+			fmt.Printf("  %s %s (revision %d)\n", changeSymbols[c.Type], name, c.OldRevision)
+		}
+
+		for _, f := range c.Fields {
+			field := safePrintKey(f.Field)
+			oldValue, newValue := diffValue(policy, f.Field, f.Old), diffValue(policy, f.Field, f.New)
+			switch f.Type {
+			case snapdiff.Added:
+				fmt.Printf("      %s %s: %s\n", changeSymbols[f.Type], field, newValue)
+			case snapdiff.Removed:
+				fmt.Printf("      %s %s: %s\n", changeSymbols[f.Type], field, oldValue)
+			default:
+				fmt.Printf("      %s %s: %s -> %s\n", changeSymbols[f.Type], field, oldValue, newValue)
+			}
+		}
+		if c.Error != "" {
+			fmt.Printf("      (data not compared: %s)\n", c.Error)
+		}
+	}
+
+	if len(changes) > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("%d added, %d removed, %d modified\n", counts[snapdiff.Added], counts[snapdiff.Removed], counts[snapdiff.Modified])
+}
+
+// printChangeTable prints one row per change, with the data keys that
+// changed but not their values
+func printChangeTable(changes []snapdiff.Change) error {
+	t := output.NewTable(os.Stdout, "RESOURCE", "NAMESPACE", "NAME", "CHANGE", "OLD REVISION", "NEW REVISION", "FIELDS")
+	for _, c := range changes {
+		name := c.Name
+		if name == "" {
+			name = c.Key
+		}
+		oldRevision, newRevision := "", ""
+		if c.OldRevision != 0 {
+			oldRevision = strconv.FormatInt(c.OldRevision, 10)
+		}
+		if c.NewRevision != 0 {
+			newRevision = strconv.FormatInt(c.NewRevision, 10)
+		}
+
+		var fields []string
+		for _, f := range c.Fields {
+			fields = append(fields, changeSymbols[f.Type]+safePrintKey(f.Field))
+		}
+		if c.Error != "" {
+			fields = append(fields, "(data not compared)")
+		}
+
+		t.Row(c.Resource, c.Namespace, safePrintKey(name), string(c.Type), oldRevision, newRevision, strings.Join(fields, ","))
+	}
+	return t.Flush()
+}
+
+// diffValue quotes a revealed value, so changes in whitespace show, or
+// returns its mask
+func diffValue(policy *redact.Policy, field string, value []byte) string {
+	if policy.Reveals(field) {
+		return strconv.Quote(string(value))
+	}
+	return redact.Mask(value)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/codanael/etcd-secret-reader/pkg/kubeserver"
+	"github.com/spf13/cobra"
+)
+
+// newKubeServeCommand serves the snapshot over a read-only subset of the
+// Kubernetes REST API
+func newKubeServeCommand(opts *globalOptions) *cobra.Command {
+	var listen string
+
+	cmd := &cobra.Command{
+		Use:   "kube-serve",
+		Short: "Serve the snapshot read-only over the Kubernetes API so kubectl can browse it",
+		Long: `Serve discovery, and get and list of the core and apps resources, from the
+snapshot over the Kubernetes REST API, so kubectl and other clients can browse
+a backup without restoring a control plane.
+
+Values are decrypted on the fly with --key or --encryption-config; objects
+that cannot be decrypted are skipped with a warning. Secret values are
+replaced by their length and fingerprint unless revealed with --reveal or
+--reveal-key. Writes and watches are rejected. The listener has no TLS or
+authentication: keep it on localhost.`,
+		Example: `  etcd-secret-reader kube-serve --snapshot=snapshot.db --encryption-config=encryption-config.yaml
+  kubectl --server=http://127.0.0.1:8080 get secrets -A`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			reader, err := opts.openReader()
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			server, err := kubeserver.NewServer(reader, kubeserver.Options{
+				Revision:  opts.revision,
+				Decryptor: opts.resourceDecryptor,
+				Redaction: opts.redaction,
+			})
+			if err != nil {
+				return err
+			}
+			defer server.Close()
+
+			// Build the key index before accepting requests
+			rev, err := reader.Revision()
+			if err != nil {
+				return fmt.Errorf("reading snapshot: %w", err)
+			}
+			if opts.revision != 0 {
+				rev = opts.revision
+			}
+
+			lis, err := net.Listen("tcp", listen)
+			if err != nil {
+				return err
+			}
+
+			httpServer := &http.Server{Handler: server, ReadHeaderTimeout: 10 * time.Second}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				httpServer.Shutdown(shutdownCtx)
+			}()
+
+			fmt.Fprintf(os.Stderr, "Serving %s at revision %d on http://%s (read-only)\n", opts.snapshotName(), rev, lis.Addr())
+			if err := httpServer.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", "127.0.0.1:8080", "Address to serve the Kubernetes API on")
+	return cmd
+}
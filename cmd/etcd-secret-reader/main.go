@@ -3,14 +3,20 @@ package main
 import (
 	"encoding/base64"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
 	"unicode"
 
 	"github.com/codanael/etcd-secret-reader/pkg/decrypt"
 	"github.com/codanael/etcd-secret-reader/pkg/etcdreader"
+	"github.com/codanael/etcd-secret-reader/pkg/output"
+	"github.com/codanael/etcd-secret-reader/pkg/redact"
+	"github.com/codanael/etcd-secret-reader/pkg/resource"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
@@ -44,184 +50,259 @@ func safePrintKey(key string) string {
 	return fmt.Sprintf("%q (contains binary data)", key)
 }
 
+// Exit statuses other than 1, for scripts that need to tell failures apart
+const (
+	// exitCorrupt is used when the snapshot fails its integrity checks
+	exitCorrupt = 2
+	// exitUndecryptable is used when secrets cannot be decrypted
+	exitUndecryptable = 3
+)
+
+// exitError is an error that sets the exit status of the command
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
 func main() {
-	// Command line flags
-	snapshotPath := flag.String("snapshot", "", "Path to etcd snapshot file (required)")
-	namespace := flag.String("namespace", "", "Kubernetes namespace")
-	secretName := flag.String("name", "", "Secret name")
-	encryptionKey := flag.String("key", "", "Base64-encoded 32-byte AES-CBC encryption key (required)")
-	keyName := flag.String("key-name", "key1", "Name of the encryption key")
-	listOnly := flag.Bool("list", false, "List all secrets without decrypting")
-	listAll := flag.Bool("list-all", false, "List all keys in the snapshot (for debugging)")
-	showVersion := flag.Bool("version", false, "Show version information")
-
-	flag.Parse()
-
-	// Handle version flag
-	if *showVersion {
-		fmt.Printf("etcd-secret-reader version %s\n", version)
-		os.Exit(0)
-	}
-
-	if *snapshotPath == "" {
-		fmt.Fprintf(os.Stderr, "Error: --snapshot is required\n")
-		flag.Usage()
+	if err := newRootCommand().Execute(); err != nil {
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
+}
 
-	// Open etcd snapshot
-	reader, err := etcdreader.NewReader(*snapshotPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening snapshot: %v\n", err)
-		os.Exit(1)
-	}
-	defer reader.Close()
+// buildDecryptor creates the decryptor for a group resource such as "secrets"
+// from either --key flags or the providers of an EncryptionConfiguration file
+func buildDecryptor(keys []string, defaultKeyName, configPath, groupResource string) (decrypt.ValueDecryptor, error) {
+	if configPath != "" {
+		if len(keys) > 0 {
+			return nil, fmt.Errorf("use either --key or --encryption-config, not both")
+		}
 
-	// List all keys mode (for debugging)
-	if *listAll {
-		keys, err := reader.ListAll()
+		config, err := decrypt.LoadEncryptionConfig(configPath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error listing all keys: %v\n", err)
-			os.Exit(1)
+			return nil, err
 		}
-		fmt.Printf("All keys in snapshot (%d total):\n", len(keys))
+		return config.DecryptorFor(groupResource)
+	}
 
-		// Count how many are secrets
-		secretCount := 0
-		for _, k := range keys {
-			if strings.HasPrefix(k, "/registry/secrets/") {
-				secretCount++
-			}
+	// The key is picked per value from the name in its encryption prefix
+	keyring := decrypt.NewKeyring()
+	for _, value := range keys {
+		provider, name, key, err := parseKeyFlag(value, defaultKeyName)
+		if err != nil {
+			return nil, err
 		}
-
-		if secretCount > 0 {
-			fmt.Printf("  (%d keys match /registry/secrets/ prefix)\n\n", secretCount)
-		} else {
-			fmt.Println("  (no keys match /registry/secrets/ prefix)")
-			fmt.Println()
+		if err := keyring.Add(provider, name, key); err != nil {
+			return nil, err
 		}
+	}
+
+	return keyring, nil
+}
 
-		for _, k := range keys {
-			safeKey := safePrintKey(k)
-			// Highlight secrets
-			if strings.HasPrefix(k, "/registry/secrets/") {
-				fmt.Printf("  [SECRET] %s\n", safeKey)
-			} else {
-				fmt.Printf("  %s\n", safeKey)
+// closeDecryptor releases the plugin connections held by KMS providers
+func closeDecryptor(decryptor decrypt.ValueDecryptor) {
+	if closer, ok := decryptor.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// parseKeyFlag parses a --key value: a bare base64 key named by --key-name,
+// or [provider/]name=base64 such as key2=... or aesgcm/key1=...
+func parseKeyFlag(value, defaultKeyName string) (provider, name string, key []byte, err error) {
+	name = defaultKeyName
+	encoded := value
+
+	// A bare key may itself contain '=' padding, so try it as a whole first
+	if decoded, decodeErr := base64.StdEncoding.DecodeString(value); decodeErr != nil || !validKeyLength(len(decoded)) {
+		if i := strings.Index(value, "="); i > 0 {
+			name, encoded = value[:i], value[i+1:]
+			if j := strings.Index(name, "/"); j >= 0 {
+				provider, name = name[:j], name[j+1:]
 			}
 		}
-		return
 	}
 
-	// List mode
-	if *listOnly {
-		secrets, err := reader.ListSecrets()
+	key, err = base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("decoding encryption key %q: %w", name, err)
+	}
+	if !validKeyLength(len(key)) {
+		return "", "", nil, fmt.Errorf("encryption key %q must be 16, 24 or 32 bytes (got %d bytes)", name, len(key))
+	}
+
+	return provider, name, key, nil
+}
+
+// validKeyLength reports whether n is a key size accepted by any provider
+func validKeyLength(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
+
+// secretPrefixes are the etcd key prefixes secrets are stored under
+// in standard Kubernetes and OpenShift clusters
+var secretPrefixes = []string{"/registry/secrets/", "/kubernetes.io/secrets/"}
+
+// listSecrets lists secret keys, as of revision when it is non-zero
+func listSecrets(reader *etcdreader.Reader, revision int64) ([]string, error) {
+	if revision == 0 {
+		return reader.ListSecrets()
+	}
+
+	var secrets []string
+	for _, prefix := range secretPrefixes {
+		keys, err := reader.ListAtRevision(prefix, revision)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error listing secrets: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Printf("Secrets in snapshot (%d found):\n", len(secrets))
-		if len(secrets) == 0 {
-			fmt.Println("  (no secrets found)")
-			fmt.Println("\nTip: Use --list-all to see all keys in the snapshot and verify the correct prefix.")
-		} else {
-			for _, s := range secrets {
-				fmt.Printf("  %s\n", s)
-			}
+			return nil, err
 		}
-		return
+		secrets = append(secrets, keys...)
 	}
+	return secrets, nil
+}
 
-	// Decrypt mode - requires key
-	if *encryptionKey == "" {
-		fmt.Fprintf(os.Stderr, "Error: --key is required for decryption\n")
-		flag.Usage()
-		os.Exit(1)
+// listAllKeys lists every key, as of revision when it is non-zero
+func listAllKeys(reader *etcdreader.Reader, revision int64) ([]string, error) {
+	if revision == 0 {
+		return reader.ListAll()
 	}
+	return reader.ListAtRevision("", revision)
+}
 
-	// Decode encryption key
-	keyBytes, err := base64.StdEncoding.DecodeString(*encryptionKey)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error decoding encryption key: %v\n", err)
-		os.Exit(1)
+// getValue reads a key, as of revision when it is non-zero
+func getValue(reader *etcdreader.Reader, key string, revision int64) ([]byte, error) {
+	if revision == 0 {
+		return reader.Get(key)
 	}
+	return reader.GetAtRevision(key, revision)
+}
 
-	if len(keyBytes) != 32 {
-		fmt.Fprintf(os.Stderr, "Error: encryption key must be 32 bytes (got %d bytes)\n", len(keyBytes))
-		os.Exit(1)
+// showInfo prints the snapshot metadata in the requested format
+func showInfo(reader *etcdreader.Reader, format string) error {
+	md, err := reader.Metadata()
+	if err != nil {
+		return err
 	}
 
-	// Create decryptor
-	decryptor, err := decrypt.NewAESCBCDecryptor(keyBytes, *keyName)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating decryptor: %v\n", err)
-		os.Exit(1)
+	switch format {
+	case output.FormatJSON:
+		return output.WriteJSON(os.Stdout, md)
+	case output.FormatNDJSON:
+		return json.NewEncoder(os.Stdout).Encode(md)
+	case output.FormatTable:
+		t := output.NewTable(os.Stdout, "REVISION", "COMPACTED", "CONSISTENT INDEX", "TERM", "STORAGE VERSION")
+		t.Row(strconv.FormatInt(md.CurrentRevision, 10), strconv.FormatInt(md.CompactRevision, 10),
+			strconv.FormatUint(md.ConsistentIndex, 10), strconv.FormatUint(md.Term, 10), md.StorageVersion)
+		return t.Flush()
+	case "text":
+		storageVersion := md.StorageVersion
+		if storageVersion == "" {
+			storageVersion = "(not recorded, etcd < 3.6)"
+		}
+		fmt.Println("Snapshot metadata:")
+		fmt.Printf("  Consistent index:           %d\n", md.ConsistentIndex)
+		fmt.Printf("  Raft term:                  %d\n", md.Term)
+		fmt.Printf("  Current revision:           %d\n", md.CurrentRevision)
+		fmt.Printf("  Compacted revision:         %d\n", md.CompactRevision)
+		fmt.Printf("  Scheduled compact revision: %d\n", md.ScheduledCompactRevision)
+		fmt.Printf("  Storage version:            %s\n", storageVersion)
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format %q (expected text, json, ndjson or table)", format)
 	}
+}
 
-	// Get specific secret or all secrets
-	if *namespace != "" && *secretName != "" {
-		// Try both standard Kubernetes and OpenShift secret paths
-		var encryptedData []byte
-		var err error
+// showHistory decrypts and prints every stored version of a secret, oldest first
+func showHistory(reader *etcdreader.Reader, decryptor decrypt.ValueDecryptor, policy *redact.Policy, namespace, name, format string) error {
+	if format != "text" && !output.IsStructured(format) {
+		return fmt.Errorf("unsupported output format %q (expected text, json, ndjson or table)", format)
+	}
 
-		keys := []string{
-			fmt.Sprintf("/registry/secrets/%s/%s", *namespace, *secretName),
-			fmt.Sprintf("/kubernetes.io/secrets/%s/%s", *namespace, *secretName),
+	var versions []etcdreader.KeyVersion
+	var etcdKey string
+	var err error
+	for _, prefix := range secretPrefixes {
+		etcdKey = prefix + namespace + "/" + name
+		versions, err = reader.History(etcdKey)
+		if err == nil {
+			break
 		}
+	}
+	if err != nil {
+		return err
+	}
 
-		for _, key := range keys {
-			encryptedData, err = reader.Get(key)
-			if err == nil {
-				break
+	if records := recordWriter(format); records != nil {
+		for _, v := range versions {
+			if err := records.Write(versionRecord(decryptor, policy, etcdKey, v)); err != nil {
+				return err
 			}
 		}
+		return records.Flush()
+	}
 
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading secret: %v\n", err)
-			os.Exit(1)
+	fmt.Printf("History of %s/%s (%d versions):\n\n", namespace, name, len(versions))
+	for _, v := range versions {
+		if v.Tombstone {
+			fmt.Printf("--- Revision %d: deleted\n\n", v.MainRevision)
+			continue
 		}
 
-		decryptedData, err := decryptor.Decrypt(encryptedData)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error decrypting secret: %v\n", err)
-			os.Exit(1)
-		}
+		fmt.Printf("--- Revision %d (created at %d, version %d, lease %d)\n",
+			v.ModRevision, v.CreateRevision, v.Version, v.Lease)
 
-		// Parse and display secret
-		if err := displaySecret(*namespace, *secretName, decryptedData); err != nil {
-			fmt.Fprintf(os.Stderr, "Error parsing secret: %v\n", err)
-			os.Exit(1)
-		}
-	} else {
-		// Get all secrets
-		secrets, err := reader.ListSecrets()
+		decryptedData, err := decryptor.DecryptValue(etcdKey, v.Value)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error listing secrets: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Warning: could not decrypt revision %d: %v\n", v.ModRevision, err)
+			fmt.Println()
+			continue
 		}
+		if err := displaySecret(policy, namespace, name, decryptedData); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not parse revision %d: %v\n", v.ModRevision, err)
+		}
+		fmt.Println()
+	}
 
-		for _, secretPath := range secrets {
-			encryptedData, err := reader.Get(secretPath)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: could not read %s: %v\n", secretPath, err)
-				continue
-			}
+	return nil
+}
 
-			decryptedData, err := decryptor.Decrypt(encryptedData)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: could not decrypt %s: %v\n", secretPath, err)
-				continue
-			}
+// versionRecord describes one stored version of a secret, decrypted and
+// masked by policy, or a deletion
+func versionRecord(decryptor decrypt.ValueDecryptor, policy *redact.Policy, etcdKey string, v etcdreader.KeyVersion) output.Record {
+	if v.Tombstone {
+		record := valueRecord(etcdKey, v.MainRevision, nil)
+		record.Provider = ""
+		record.Deleted = true
+		return record
+	}
 
-			// Parse path to get namespace and name
-			// Path format: /registry/secrets/<namespace>/<name>
-			ns, name := parseSecretPath(secretPath)
-			if err := displaySecret(ns, name, decryptedData); err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: could not parse %s: %v\n", secretPath, err)
-			}
-			fmt.Println()
-		}
+	record := valueRecord(etcdKey, v.ModRevision, v.Value)
+	data, err := decryptor.DecryptValue(etcdKey, v.Value)
+	if err != nil {
+		record.Error = fmt.Sprintf("could not decrypt: %v", err)
+		return record
+	}
+	secret, err := decodeSecret(data)
+	if err != nil {
+		record.Error = fmt.Sprintf("could not parse: %v", err)
+		return record
 	}
+	addSecret(&record, secret, policy)
+	return record
+}
+
+// revisionSuffix describes the revision being read for output headers
+func revisionSuffix(revision int64) string {
+	if revision == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" at revision %d", revision)
 }
 
 func parseSecretPath(path string) (namespace, name string) {
@@ -249,26 +330,122 @@ func parseSecretPath(path string) (namespace, name string) {
 	return
 }
 
-func displaySecret(namespace, name string, data []byte) error {
-	fmt.Printf("Secret: %s/%s\n", namespace, name)
-
-	// Try to detect format: protobuf vs JSON
-	var secret *corev1.Secret
-	var err error
+// secretOutput prints decrypted secrets in the format selected by --output:
+// text for reading, records for scripts, or manifests for kubectl apply;
+// values hidden by policy are masked in every format
+type secretOutput struct {
+	policy    *redact.Policy
+	manifests *resource.ManifestWriter
+	records   *output.Writer
+	// err is the first error writing a record from fail, returned by flush
+	err error
+}
 
-	// Check if it's protobuf (starts with "k8s\x00")
-	if len(data) > 4 && data[0] == 'k' && data[1] == '8' && data[2] == 's' && data[3] == 0 {
-		// Decode protobuf
-		secret, err = decodeProtobufSecret(data)
-		if err != nil {
-			return fmt.Errorf("failed to decode protobuf secret: %w", err)
+// newSecretOutput creates the output for format; dir, when set, receives one
+// manifest per secret
+func newSecretOutput(format, dir string, policy *redact.Policy) (*secretOutput, error) {
+	o := &secretOutput{policy: policy}
+	switch {
+	case format == "yaml" || format == "manifest" || format == "manifest-json":
+		// json selects records, so JSON manifests have their own name
+		manifestFormat := resource.FormatYAML
+		if format == "manifest-json" {
+			manifestFormat = resource.FormatJSON
 		}
-	} else {
-		// Try JSON
-		secret, err = decodeJSONSecret(data)
+		w, err := resource.NewManifestWriter(manifestFormat, os.Stdout, dir)
 		if err != nil {
-			return fmt.Errorf("failed to parse secret (tried both protobuf and JSON): %w", err)
+			return nil, err
 		}
+		o.manifests = w
+		return o, nil
+	case dir != "":
+		return nil, fmt.Errorf("--output-dir requires --output=yaml, manifest or manifest-json")
+	case format == "text":
+		return o, nil
+	case output.IsStructured(format):
+		o.records = recordWriter(format)
+		return o, nil
+	default:
+		return nil, fmt.Errorf("unsupported output format %q (expected text, yaml, json, ndjson, table, manifest or manifest-json)", format)
+	}
+}
+
+// print prints the secret stored in kv, decrypted as data; namespace and name
+// come from the storage path
+func (o *secretOutput) print(kv *mvccpb.KeyValue, namespace, name string, data []byte) error {
+	if o.manifests == nil && o.records == nil {
+		return displaySecret(o.policy, namespace, name, data)
+	}
+
+	secret, err := decodeSecret(data)
+	if err != nil {
+		return err
+	}
+	if o.records != nil {
+		record := keyRecord(kv)
+		addSecret(&record, secret, o.policy)
+		return o.records.Write(record)
+	}
+
+	// The object's own metadata wins, the storage path fills in what is missing
+	if secret.Namespace == "" {
+		secret.Namespace = namespace
+	}
+	if secret.Name == "" {
+		secret.Name = name
+	}
+	obj, err := o.policy.Unstructured(secret)
+	if err != nil {
+		return err
+	}
+	return o.manifests.Write(obj)
+}
+
+// fail reports a secret that could not be read, as an error record in the
+// record formats and as a warning on stderr otherwise; action is what failed,
+// e.g. "could not decrypt", and kv is nil when the key could not be read
+func (o *secretOutput) fail(key string, kv *mvccpb.KeyValue, action string, err error) {
+	if o.records == nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s %s: %v\n", action, safePrintKey(key), err)
+		return
+	}
+
+	err = fmt.Errorf("%s: %w", action, err)
+	record := errorRecord(key, err)
+	if kv != nil {
+		record = keyRecord(kv)
+		record.Error = err.Error()
+	}
+	if err := o.records.Write(record); err != nil && o.err == nil {
+		o.err = err
+	}
+}
+
+// flush writes the records buffered by the json and table formats, and
+// returns the first error fail could not report
+func (o *secretOutput) flush() error {
+	if o.records == nil {
+		return nil
+	}
+	if err := o.records.Flush(); err != nil {
+		return err
+	}
+	return o.err
+}
+
+// separate reports whether secrets are separated by blank lines
+func (o *secretOutput) separate() bool {
+	return o.manifests == nil && o.records == nil
+}
+
+// displaySecret prints a decrypted secret for reading, masking the values
+// policy does not reveal
+func displaySecret(policy *redact.Policy, namespace, name string, data []byte) error {
+	fmt.Printf("Secret: %s/%s\n", namespace, name)
+
+	secret, err := decodeSecret(data)
+	if err != nil {
+		return err
 	}
 
 	// Display type
@@ -278,7 +455,7 @@ func displaySecret(namespace, name string, data []byte) error {
 	if len(secret.Data) > 0 {
 		fmt.Println("Data:")
 		for key, val := range secret.Data {
-			fmt.Printf("  %s: %s\n", key, string(val))
+			fmt.Printf("  %s: %s\n", key, policy.Value(key, val))
 		}
 	}
 
@@ -286,13 +463,39 @@ func displaySecret(namespace, name string, data []byte) error {
 	if len(secret.StringData) > 0 {
 		fmt.Println("StringData:")
 		for key, val := range secret.StringData {
-			fmt.Printf("  %s: %s\n", key, val)
+			fmt.Printf("  %s: %s\n", key, policy.Value(key, []byte(val)))
 		}
 	}
 
 	return nil
 }
 
+// decodeSecret decodes a decrypted secret stored as protobuf or JSON
+func decodeSecret(data []byte) (*corev1.Secret, error) {
+	var secret *corev1.Secret
+	var err error
+
+	// Check if it's protobuf (starts with "k8s\x00")
+	if len(data) > 4 && data[0] == 'k' && data[1] == '8' && data[2] == 's' && data[3] == 0 {
+		// Decode protobuf
+		secret, err = decodeProtobufSecret(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode protobuf secret: %w", err)
+		}
+	} else {
+		// Try JSON
+		secret, err = decodeJSONSecret(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse secret (tried both protobuf and JSON): %w", err)
+		}
+	}
+
+	// Protobuf values do not carry the type in the object itself
+	secret.APIVersion = "v1"
+	secret.Kind = "Secret"
+	return secret, nil
+}
+
 func decodeProtobufSecret(data []byte) (*corev1.Secret, error) {
 	// Create a Kubernetes scheme and decoder
 	scheme := runtime.NewScheme()
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"unicode/utf8"
+
+	"github.com/codanael/etcd-secret-reader/pkg/decrypt"
+	"github.com/codanael/etcd-secret-reader/pkg/etcdreader"
+	"github.com/codanael/etcd-secret-reader/pkg/output"
+	"github.com/codanael/etcd-secret-reader/pkg/redact"
+	"github.com/codanael/etcd-secret-reader/pkg/resource"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// outputFormats are the values of --output offered for completion
+var outputFormats = []string{"text", "yaml", "json", "ndjson", "table", "manifest", "manifest-json"}
+
+// recordWriter returns the writer for --output when it is a record format,
+// and nil for text and the other formats
+func recordWriter(format string) *output.Writer {
+	if !output.IsStructured(format) {
+		return nil
+	}
+	w, _ := output.NewWriter(format, os.Stdout)
+	return w
+}
+
+// valueRecord describes a value stored under key without decrypting it
+func valueRecord(key string, modRevision int64, value []byte) output.Record {
+	record := output.Record{Key: key, ModRevision: modRevision, Provider: "identity"}
+	if parsed, err := resource.ParseKey(key); err == nil {
+		record.Resource = parsed.Resource.GroupResource()
+		record.Namespace = parsed.Namespace
+		record.Name = parsed.Name
+	}
+	if provider, keyName, err := decrypt.ParseEncryptionPrefix(value); err == nil {
+		record.Provider = provider
+		record.KeyName = keyName
+	}
+	return record
+}
+
+// keyRecord describes a stored key-value pair without decrypting it
+func keyRecord(kv *mvccpb.KeyValue) output.Record {
+	return valueRecord(string(kv.Key), kv.ModRevision, kv.Value)
+}
+
+// errorRecord describes a key that could not be read
+func errorRecord(key string, err error) output.Record {
+	record := valueRecord(key, 0, nil)
+	record.Provider = ""
+	record.Error = err.Error()
+	return record
+}
+
+// addSecret adds the type and data of a decoded secret to record, masking
+// the values policy does not reveal
+func addSecret(record *output.Record, secret *corev1.Secret, policy *redact.Policy) {
+	record.Type = string(secret.Type)
+	record.Data = map[string]string{}
+
+	data := map[string][]byte{}
+	for key, value := range secret.Data {
+		data[key] = value
+	}
+	for key, value := range secret.StringData {
+		data[key] = []byte(value)
+	}
+
+	for key, value := range data {
+		if policy.Reveals(key) && !utf8.Valid(value) {
+			if record.BinaryData == nil {
+				record.BinaryData = map[string][]byte{}
+			}
+			record.BinaryData[key] = value
+			continue
+		}
+		record.Data[key] = policy.Value(key, value)
+	}
+}
+
+// addObject adds a decoded object to record; secrets are added as type and
+// data, masked by policy
+func addObject(record *output.Record, obj runtime.Object, policy *redact.Policy) error {
+	if secret, ok := obj.(*corev1.Secret); ok {
+		addSecret(record, secret, policy)
+		return nil
+	}
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return fmt.Errorf("failed to convert %T: %w", obj, err)
+	}
+	record.Object = content
+	return nil
+}
+
+// getKeyValue reads the record of a key, as of revision when it is non-zero
+func getKeyValue(reader *etcdreader.Reader, key string, revision int64) (*mvccpb.KeyValue, error) {
+	kvs, err := reader.Range(key, "", revision)
+	if err != nil {
+		return nil, err
+	}
+	if len(kvs) == 0 {
+		if revision != 0 {
+			return nil, fmt.Errorf("key not found at revision %d: %s", revision, key)
+		}
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+	return kvs[0], nil
+}
+
+// rangePrefixes reads the records of every key under prefixes, as of
+// revision when it is non-zero; an empty prefix reads every key
+func rangePrefixes(reader *etcdreader.Reader, prefixes []string, revision int64) ([]*mvccpb.KeyValue, error) {
+	var kvs []*mvccpb.KeyValue
+	for _, prefix := range prefixes {
+		found, err := reader.Range(prefix, etcdreader.PrefixEnd(prefix), revision)
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, found...)
+	}
+	return kvs, nil
+}
+
+// writeKeyRecords writes one record per key under prefixes, without
+// decrypting anything
+func writeKeyRecords(w *output.Writer, reader *etcdreader.Reader, prefixes []string, revision int64) error {
+	kvs, err := rangePrefixes(reader, prefixes, revision)
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		if err := w.Write(keyRecord(kv)); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
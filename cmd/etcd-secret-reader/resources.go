@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/codanael/etcd-secret-reader/pkg/decrypt"
+	"github.com/codanael/etcd-secret-reader/pkg/etcdreader"
+	"github.com/codanael/etcd-secret-reader/pkg/output"
+	"github.com/codanael/etcd-secret-reader/pkg/redact"
+	"github.com/codanael/etcd-secret-reader/pkg/resource"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// resourceDecryptor builds the decryptor for a group resource; without --key
+// or --encryption-config values are expected to be stored unencrypted
+func resourceDecryptor(keys []string, defaultKeyName, configPath, groupResource string) (decrypt.ValueDecryptor, error) {
+	if len(keys) == 0 && configPath == "" {
+		return decrypt.IdentityDecryptor{}, nil
+	}
+	return buildDecryptor(keys, defaultKeyName, configPath, groupResource)
+}
+
+// loadRegistry registers the custom resource of every CustomResourceDefinition
+// in the snapshot; definitions that cannot be read are skipped with a warning
+func loadRegistry(reader *etcdreader.Reader, decryptor decrypt.ValueDecryptor, revision int64) (*resource.Registry, error) {
+	registry := resource.NewRegistry()
+
+	keys, err := listResourceKeys(reader, resource.CustomResourceDefinitions, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		data, err := getValue(reader, key, revision)
+		if err == nil {
+			data, err = decryptor.DecryptValue(key, data)
+		}
+		if err == nil {
+			_, err = registry.AddCRD(data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping CustomResourceDefinition %s: %v\n", key, err)
+		}
+	}
+
+	return registry, nil
+}
+
+// listResourceKeys lists the keys of every object of a resource type, as of
+// revision when it is non-zero
+func listResourceKeys(reader *etcdreader.Reader, info resource.Info, revision int64) ([]string, error) {
+	var keys []string
+	for _, prefix := range info.Prefixes() {
+		var found []string
+		var err error
+		if revision == 0 {
+			found, err = reader.ListPrefix(prefix)
+		} else {
+			found, err = reader.ListAtRevision(prefix, revision)
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, found...)
+	}
+	return keys, nil
+}
+
+// showResources decodes and prints the object given by namespace and name,
+// or every object of the resource type when name is empty
+func showResources(reader *etcdreader.Reader, decryptor decrypt.ValueDecryptor, registry *resource.Registry, info resource.Info, namespace, name string, revision int64, format string, policy *redact.Policy) error {
+	if format != "text" && !output.IsStructured(format) {
+		return fmt.Errorf("unsupported output format %q (expected text, json, ndjson or table)", format)
+	}
+	records := recordWriter(format)
+	custom := registry.IsCustom(info)
+
+	if name != "" {
+		if info.Namespaced && namespace == "" {
+			return fmt.Errorf("%s is namespaced, --namespace is required", info.GroupResource())
+		}
+
+		var err error
+		for _, key := range info.Keys(namespace, name) {
+			var kv *mvccpb.KeyValue
+			kv, err = getKeyValue(reader, key, revision)
+			if err != nil {
+				continue
+			}
+			if records == nil {
+				return printResource(decryptor, kv, custom, policy)
+			}
+
+			record := resourceRecord(decryptor, kv, custom, policy)
+			if err := records.Write(record); err != nil {
+				return err
+			}
+			if err := records.Flush(); err != nil {
+				return err
+			}
+			if record.Error != "" {
+				return errors.New(record.Error)
+			}
+			return nil
+		}
+		return err
+	}
+
+	keys, err := listResourceKeys(reader, info, revision)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if namespace != "" {
+			if parsed, err := registry.ParseKey(key); err != nil || parsed.Namespace != namespace {
+				continue
+			}
+		}
+
+		kv, err := getKeyValue(reader, key, revision)
+		switch {
+		case err != nil && records != nil:
+			if err := records.Write(errorRecord(key, fmt.Errorf("could not read: %w", err))); err != nil {
+				return err
+			}
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Warning: could not read %s: %v\n", key, err)
+		case records != nil:
+			if err := records.Write(resourceRecord(decryptor, kv, custom, policy)); err != nil {
+				return err
+			}
+		default:
+			if err := printResource(decryptor, kv, custom, policy); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", key, err)
+			}
+		}
+	}
+
+	if records != nil {
+		return records.Flush()
+	}
+	return nil
+}
+
+// decodeResource decrypts and decodes one stored object; custom resources
+// are decoded as unstructured
+func decodeResource(decryptor decrypt.ValueDecryptor, kv *mvccpb.KeyValue, custom bool) (runtime.Object, error) {
+	plaintext, err := decryptor.DecryptValue(string(kv.Key), kv.Value)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt: %w", err)
+	}
+
+	if custom {
+		return resource.DecodeUnstructured(plaintext)
+	}
+	return resource.Decode(plaintext)
+}
+
+// printResource decrypts and decodes one stored object and prints it as a
+// YAML document; secret values are masked unless policy reveals them
+func printResource(decryptor decrypt.ValueDecryptor, kv *mvccpb.KeyValue, custom bool, policy *redact.Policy) error {
+	obj, err := decodeResource(decryptor, kv, custom)
+	if err != nil {
+		return err
+	}
+	if secret, ok := obj.(*corev1.Secret); ok {
+		if obj, err = policy.Unstructured(secret); err != nil {
+			return err
+		}
+	}
+
+	out, err := resource.ToYAML(obj)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("---\n" + string(out))
+	return nil
+}
+
+// resourceRecord decrypts and decodes one stored object into a record;
+// errors are reported in the record
+func resourceRecord(decryptor decrypt.ValueDecryptor, kv *mvccpb.KeyValue, custom bool, policy *redact.Policy) output.Record {
+	record := keyRecord(kv)
+	obj, err := decodeResource(decryptor, kv, custom)
+	if err == nil {
+		err = addObject(&record, obj, policy)
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return record
+}
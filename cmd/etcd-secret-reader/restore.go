@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/codanael/etcd-secret-reader/pkg/output"
+	"github.com/codanael/etcd-secret-reader/pkg/restore"
+	"github.com/spf13/cobra"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// newRestoreCommand decrypts the secrets selected by --namespace and --name,
+// or every secret with --all, and writes them to a live cluster
+func newRestoreCommand(opts *globalOptions) *cobra.Command {
+	var namespace, secretName, kubeconfig, kubeContext, onConflict string
+	var dryRun, all bool
+
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore secrets from a snapshot into the cluster of the current kubeconfig context",
+		Example: `  etcd-secret-reader restore --snapshot=snapshot.db --key=<base64> --namespace=prod --dry-run
+  etcd-secret-reader restore --snapshot=snapshot.db --key=<base64> --namespace='team-*' --name='*-tls' --on-conflict=rename
+  etcd-secret-reader restore --snapshot=snapshot.db --key=<base64> --all --dry-run`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter := restore.Filter{Namespace: namespace, Name: secretName, All: all}
+			if err := filter.Validate(); err != nil {
+				if errors.Is(err, restore.ErrNoFilter) {
+					return fmt.Errorf("--namespace, --name or --all is required")
+				}
+				if all {
+					return fmt.Errorf("use either --namespace and --name or --all, not both")
+				}
+				return err
+			}
+			policy, err := restore.ParseConflictPolicy(onConflict)
+			if err != nil {
+				return err
+			}
+			if opts.output != "text" && !output.IsStructured(opts.output) {
+				return fmt.Errorf("unsupported output format %q (expected text, json, ndjson or table)", opts.output)
+			}
+			records := recordWriter(opts.output)
+			// With records on stdout, the notes and the summary go to stderr
+			notes := os.Stdout
+			if records != nil {
+				notes = os.Stderr
+			}
+
+			reader, err := opts.openReader()
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			decryptor, err := opts.secretDecryptor()
+			if err != nil {
+				return err
+			}
+			defer closeDecryptor(decryptor)
+
+			client, err := newClientset(kubeconfig, kubeContext)
+			if err != nil {
+				return err
+			}
+
+			restorer, err := restore.NewRestorer(client, restore.Options{DryRun: dryRun, OnConflict: policy})
+			if err != nil {
+				return err
+			}
+
+			secrets, err := listSecrets(reader, opts.revision)
+			if err != nil {
+				return fmt.Errorf("listing secrets: %w", err)
+			}
+
+			if dryRun {
+				fmt.Fprintln(notes, "Dry run: the cluster is not modified")
+			}
+
+			ctx := cmd.Context()
+			var report restore.Report
+			for _, secretPath := range secrets {
+				ns, name := parseSecretPath(secretPath)
+				if !filter.Match(ns, name) {
+					continue
+				}
+
+				result := restore.Result{Namespace: ns, Name: name, RestoredAs: name, Action: restore.ActionFailed}
+				var data []byte
+				kv, err := getKeyValue(reader, secretPath, opts.revision)
+				if err == nil {
+					data, err = decryptor.DecryptValue(secretPath, kv.Value)
+				}
+				if err == nil {
+					secret, decodeErr := decodeSecret(data)
+					if decodeErr != nil {
+						err = decodeErr
+					} else {
+						// The storage path is authoritative for where the secret lived
+						secret.Namespace, secret.Name = ns, name
+						result = restorer.Restore(ctx, secret)
+					}
+				}
+				if err != nil {
+					result.Err = err
+				}
+
+				report.Add(result)
+				if records == nil {
+					printRestoreResult(result)
+				} else if err := records.Write(restoreRecord(secretPath, kv, result)); err != nil {
+					return err
+				}
+			}
+
+			if records != nil {
+				if err := records.Flush(); err != nil {
+					return err
+				}
+			} else {
+				fmt.Println()
+			}
+			fmt.Fprintln(notes, report.Summary())
+			if report.Failed() {
+				return fmt.Errorf("%d secrets could not be restored", report.Count(restore.ActionFailed))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Only restore secrets in namespaces matching this pattern, e.g. prod or team-*")
+	cmd.Flags().StringVar(&secretName, "name", "", "Only restore secrets whose name matches this pattern, e.g. *-tls")
+	cmd.Flags().BoolVar(&all, "all", false, "Restore every secret of the snapshot")
+	cmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "Path to the kubeconfig file (default: $KUBECONFIG or ~/.kube/config)")
+	cmd.Flags().StringVar(&kubeContext, "context", "", "Kubeconfig context to use (default: current context)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would be restored without changing the cluster")
+	cmd.Flags().StringVar(&onConflict, "on-conflict", "skip", "What to do when a secret already exists: skip, overwrite or rename")
+	cmd.RegisterFlagCompletionFunc("on-conflict", cobra.FixedCompletions([]string{"skip", "overwrite", "rename"}, cobra.ShellCompDirectiveNoFileComp))
+	cmd.MarkFlagFilename("kubeconfig")
+	return cmd
+}
+
+// newClientset creates a clientset from a kubeconfig, following the same
+// lookup rules as kubectl when path is empty
+func newClientset(path, kubeContext string) (kubernetes.Interface, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = path
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: kubeContext}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return client, nil
+}
+
+// restoreRecord describes the result of restoring the secret stored under
+// key; kv is nil when the key could not be read
+func restoreRecord(key string, kv *mvccpb.KeyValue, result restore.Result) output.Record {
+	record := valueRecord(key, 0, nil)
+	record.Provider = ""
+	if kv != nil {
+		record = keyRecord(kv)
+	}
+	record.Action = string(result.Action)
+	record.RestoredAs = result.RestoredAs
+	if result.Err != nil {
+		record.Error = result.Err.Error()
+	}
+	return record
+}
+
+// printRestoreResult prints one line of the restore report
+func printRestoreResult(result restore.Result) {
+	line := fmt.Sprintf("  %-8s %s/%s", result.Action, result.Namespace, result.Name)
+	if result.RestoredAs != result.Name {
+		line += " as " + result.RestoredAs
+	}
+	if result.Err != nil {
+		line += ": " + result.Err.Error()
+	}
+	fmt.Println(line)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/codanael/etcd-secret-reader/pkg/decrypt"
+	"github.com/codanael/etcd-secret-reader/pkg/etcdreader"
+	"github.com/codanael/etcd-secret-reader/pkg/redact"
+	"github.com/codanael/etcd-secret-reader/pkg/resource"
+	"github.com/codanael/etcd-secret-reader/pkg/source"
+	"github.com/spf13/cobra"
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
+)
+
+// globalOptions holds the flags shared by every subcommand
+type globalOptions struct {
+	snapshot         string
+	dataDir          string
+	lockTimeout      time.Duration
+	copyDB           bool
+	skipWAL          bool
+	keys             []string
+	keyName          string
+	encryptionConfig string
+	revision         int64
+	output           string
+	reveal           bool
+	revealKeys       []string
+	maxDecompressed  string
+	tempDir          string
+	s3Endpoint       string
+	// maxDecompressedSize is parsed from --max-decompressed-size
+	maxDecompressedSize int64
+	// redaction is built from --reveal and --reveal-key before any command runs
+	redaction *redact.Policy
+}
+
+// newRootCommand builds the command tree
+func newRootCommand() *cobra.Command {
+	opts := &globalOptions{}
+
+	root := &cobra.Command{
+		Use:   "etcd-secret-reader",
+		Short: "Read and decrypt Kubernetes objects from etcd snapshots",
+		Long: `etcd-secret-reader reads etcd snapshot files offline and decrypts the
+Kubernetes secrets and other resources stored in them.`,
+		Version:       version,
+		SilenceUsage:  true,
+		SilenceErrors: false,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if opts.revision < 0 {
+				return fmt.Errorf("--revision must be positive")
+			}
+			if opts.snapshot != "" && opts.dataDir != "" {
+				return fmt.Errorf("use either --snapshot or --data-dir, not both")
+			}
+			redaction, err := redact.NewPolicy(opts.reveal, opts.revealKeys)
+			if err != nil {
+				return fmt.Errorf("--reveal-key: %w", err)
+			}
+			opts.redaction = redaction
+			maxSize, err := apiresource.ParseQuantity(opts.maxDecompressed)
+			if err != nil || maxSize.Sign() <= 0 {
+				return fmt.Errorf("--max-decompressed-size must be a positive size such as 8Gi, got %q", opts.maxDecompressed)
+			}
+			opts.maxDecompressedSize = maxSize.Value()
+			if opts.s3Endpoint != "" {
+				source.Register("s3", &source.S3{Endpoint: opts.s3Endpoint})
+			}
+			return nil
+		},
+	}
+	root.SetVersionTemplate("etcd-secret-reader version {{.Version}}\n")
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&opts.snapshot, "snapshot", "", "Path to etcd snapshot file, - to read it from stdin, or an s3://, gs:// or file:// URL")
+	flags.StringVar(&opts.dataDir, "data-dir", "", "Path to the data directory of an etcd member, read instead of --snapshot")
+	flags.DurationVar(&opts.lockTimeout, "lock-timeout", etcdreader.DefaultLockTimeout, "How long to wait for a running etcd to release the database of --data-dir")
+	flags.BoolVar(&opts.copyDB, "copy-db", false, "Read a copy of the database of --data-dir, taken while etcd keeps running")
+	flags.BoolVar(&opts.skipWAL, "skip-wal", false, "Read the database of --data-dir as last written, without replaying the WAL")
+	flags.StringArrayVar(&opts.keys, "key", nil, "Encryption key as base64 or [provider/]name=base64; repeat for several keys (32 bytes for aescbc and secretbox, 16, 24 or 32 bytes for aesgcm)")
+	flags.StringVar(&opts.keyName, "key-name", "key1", "Name of a --key given without name=")
+	flags.StringVar(&opts.encryptionConfig, "encryption-config", "", "Path to the kube-apiserver EncryptionConfiguration file (replaces --key)")
+	flags.Int64Var(&opts.revision, "revision", 0, "Read the snapshot as of this MVCC revision (default: latest)")
+	flags.StringVarP(&opts.output, "output", "o", "text", "Output format; the accepted values depend on the command")
+	flags.BoolVar(&opts.reveal, "reveal", false, "Print secret values in cleartext instead of their length and SHA-256 fingerprint")
+	flags.StringArrayVar(&opts.revealKeys, "reveal-key", nil, "Print the values of the secret data keys matching this shell pattern in cleartext; repeat for several patterns")
+	flags.StringVar(&opts.maxDecompressed, "max-decompressed-size", "8Gi", "Refuse compressed snapshots larger than this once decompressed, and other temporary copies of snapshots larger than this")
+	flags.StringVar(&opts.s3Endpoint, "s3-endpoint", "", "URL of the S3-compatible storage of s3:// snapshots, such as http://minio.local:9000 (default: $AWS_ENDPOINT_URL_S3, $AWS_ENDPOINT_URL or AWS S3)")
+	flags.StringVar(&opts.tempDir, "temp-dir", "", "Directory for the temporary copies of compressed, piped and downloaded snapshots and of --data-dir databases (default: $TMPDIR)")
+	root.MarkPersistentFlagFilename("snapshot", "db", "gz", "zst", "xz")
+	root.MarkPersistentFlagDirname("data-dir")
+	root.MarkPersistentFlagFilename("encryption-config", "yaml", "yml", "json")
+	root.RegisterFlagCompletionFunc("output", cobra.FixedCompletions(outputFormats, cobra.ShellCompDirectiveNoFileComp))
+
+	root.AddCommand(
+		newListCommand(opts),
+		newGetCommand(opts),
+		newDumpCommand(opts),
+		newHistoryCommand(opts),
+		newInfoCommand(opts),
+		newVerifyCommand(opts),
+		newRestoreCommand(opts),
+		newServeCommand(opts),
+		newKubeServeCommand(opts),
+		newDiffCommand(opts),
+	)
+
+	return root
+}
+
+// errNoSnapshot is returned when neither --snapshot nor --data-dir is given
+var errNoSnapshot = errors.New("--snapshot or --data-dir is required")
+
+// openReader opens the snapshot given by --snapshot or the data directory
+// given by --data-dir
+func (o *globalOptions) openReader() (*etcdreader.Reader, error) {
+	if o.dataDir != "" {
+		reader, err := o.openDataDir()
+		if err != nil {
+			return nil, fmt.Errorf("opening data directory: %w", err)
+		}
+		return reader, nil
+	}
+
+	if o.snapshot == "" {
+		return nil, errNoSnapshot
+	}
+	reader, err := o.openSnapshot(o.snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("opening snapshot: %w", err)
+	}
+	return reader, nil
+}
+
+// errTerminalStdin is returned for --snapshot=- when nothing is piped to stdin
+var errTerminalStdin = errors.New("refusing to read a snapshot from a terminal, pipe it to stdin")
+
+// sourceError is returned when a snapshot URL cannot be fetched, as opposed
+// to a download that turns out corrupt
+type sourceError struct{ err error }
+
+func (e *sourceError) Error() string { return e.err.Error() }
+func (e *sourceError) Unwrap() error { return e.err }
+
+// openSnapshot opens a snapshot file, stdin for "-" or the object at a
+// source URL, decompressing it first when it is compressed
+func (o *globalOptions) openSnapshot(path string) (*etcdreader.Reader, error) {
+	readerOpts := etcdreader.Options{MaxDecompressedSize: o.maxDecompressedSize, TempDir: o.tempDir}
+
+	// Compressed, streamed and downloaded snapshots are copied to a
+	// temporary file, which is unlinked once open; remove a partial copy
+	// when interrupted while copying
+	stop := removeTempFilesOnSignal()
+	defer stop()
+
+	if source.IsURL(path) {
+		obj, err := source.Open(context.Background(), path)
+		if err != nil {
+			return nil, &sourceError{err: err}
+		}
+		defer obj.Close()
+		if obj.Path == "" {
+			// The checksums published by the source are verified as the
+			// object is copied, and a mismatch fails the copy
+			return etcdreader.NewReaderFromWithOptions(obj, readerOpts)
+		}
+		path = obj.Path
+	}
+
+	if path == "-" {
+		if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return nil, errTerminalStdin
+		}
+		return etcdreader.NewReaderFromWithOptions(os.Stdin, readerOpts)
+	}
+	// bbolt tries to initialize missing files even in read-only mode
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return etcdreader.NewReaderWithOptions(path, readerOpts)
+}
+
+// openDataDir opens the data directory given by --data-dir and reports the
+// WAL entries replayed over its database
+func (o *globalOptions) openDataDir() (*etcdreader.Reader, error) {
+	// The database is copied to a temporary file when it is locked or
+	// has WAL entries to replay
+	stop := removeTempFilesOnSignal()
+	defer stop()
+
+	reader, err := etcdreader.OpenDataDir(o.dataDir, etcdreader.DataDirOptions{
+		Options:     etcdreader.Options{MaxDecompressedSize: o.maxDecompressedSize, TempDir: o.tempDir},
+		LockTimeout: o.lockTimeout,
+		Copy:        o.copyDB,
+		SkipWAL:     o.skipWAL,
+	})
+	if errors.Is(err, etcdreader.ErrDataDirLocked) {
+		return nil, fmt.Errorf("%w; stop etcd, or pass --copy-db to read a copy of the database", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if replay := reader.Replay(); replay != nil {
+		if replay.Entries > 0 {
+			fmt.Fprintf(os.Stderr, "Replayed %d WAL entries after index %d up to index %d, %d new revisions\n",
+				replay.Entries, replay.FromIndex, replay.ToIndex, replay.Revisions)
+		}
+		if replay.Skipped > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: skipped %d WAL entries that are not etcd v3 requests\n", replay.Skipped)
+		}
+		if replay.Uncommitted > 0 {
+			fmt.Fprintf(os.Stderr, "Ignored %d WAL entries that were not committed\n", replay.Uncommitted)
+		}
+	}
+	return reader, nil
+}
+
+// snapshotName names the snapshot or data directory being read, for messages
+func (o *globalOptions) snapshotName() string {
+	if o.dataDir != "" {
+		return o.dataDir
+	}
+	return o.snapshot
+}
+
+// removeTempFilesOnSignal removes the temporary copies of snapshots and
+// exits when the process is interrupted, until the returned stop is called;
+// commands install their own handlers once the snapshot is open
+func removeTempFilesOnSignal() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			etcdreader.RemoveTempFiles()
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			os.Exit(code)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// hasKeys reports whether --key or --encryption-config was given
+func (o *globalOptions) hasKeys() bool {
+	return len(o.keys) > 0 || o.encryptionConfig != ""
+}
+
+// secretDecryptor builds the decryptor for secrets, which requires keys
+func (o *globalOptions) secretDecryptor() (decrypt.ValueDecryptor, error) {
+	if !o.hasKeys() {
+		return nil, fmt.Errorf("--key or --encryption-config is required for decryption")
+	}
+	return buildDecryptor(o.keys, o.keyName, o.encryptionConfig, "secrets")
+}
+
+// resourceDecryptor builds the decryptor for a group resource; without keys
+// values are expected to be stored unencrypted
+func (o *globalOptions) resourceDecryptor(groupResource string) (decrypt.ValueDecryptor, error) {
+	return resourceDecryptor(o.keys, o.keyName, o.encryptionConfig, groupResource)
+}
+
+// registry loads the custom resources defined in the snapshot
+func (o *globalOptions) registry(reader *etcdreader.Reader) (*resource.Registry, error) {
+	crdDecryptor, err := o.resourceDecryptor(resource.CustomResourceDefinitions.GroupResource())
+	if err != nil {
+		return nil, err
+	}
+	defer closeDecryptor(crdDecryptor)
+
+	registry, err := loadRegistry(reader, crdDecryptor, o.revision)
+	if err != nil {
+		return nil, fmt.Errorf("reading CustomResourceDefinitions: %w", err)
+	}
+	return registry, nil
+}
+
+// completeResources completes --resource with the built-in resource types
+func completeResources(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var names []string
+	for _, info := range resource.Builtin() {
+		names = append(names, info.GroupResource())
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/codanael/etcd-secret-reader/pkg/etcdserver"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+// newServeCommand serves the snapshot over the etcd v3 gRPC API
+func newServeCommand(opts *globalOptions) *cobra.Command {
+	var listen string
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the snapshot read-only over the etcd v3 gRPC API",
+		Long: `Serve the snapshot read-only over the etcd v3 KV.Range, KV.Txn and
+Maintenance.Status RPCs, so etcdctl and other etcd clients can query it like a
+running member. Past revisions still in the snapshot can be read with etcdctl
+get --rev.
+
+Writes, and transactions that may write, are rejected with Unavailable. Values are served as stored, without
+decryption, and there is no TLS or authentication: keep the listener local.`,
+		Example: `  etcd-secret-reader serve --snapshot=snapshot.db
+  etcdctl --endpoints=127.0.0.1:2379 get /registry/secrets/ --prefix --keys-only`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.revision != 0 {
+				return fmt.Errorf("--revision is not supported by serve, pass the revision with each request instead")
+			}
+
+			reader, err := opts.openReader()
+			if err != nil {
+				return err
+			}
+			defer reader.Close()
+
+			// Build the key index before accepting requests
+			md, err := reader.Metadata()
+			if err != nil {
+				return fmt.Errorf("reading snapshot: %w", err)
+			}
+
+			lis, err := net.Listen("tcp", listen)
+			if err != nil {
+				return err
+			}
+
+			server := grpc.NewServer()
+			etcdserver.NewServer(reader).Register(server)
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				server.GracefulStop()
+			}()
+
+			fmt.Fprintf(os.Stderr, "Serving %s at revision %d on %s (read-only)\n", opts.snapshotName(), md.CurrentRevision, lis.Addr())
+			if err := server.Serve(lis); err != nil && ctx.Err() == nil {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", "127.0.0.1:2379", "Address to serve the etcd gRPC API on")
+	return cmd
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/codanael/etcd-secret-reader/pkg/etcdreader"
+	"github.com/codanael/etcd-secret-reader/pkg/output"
+	"github.com/spf13/cobra"
+)
+
+// verifyResult is what verify writes in the record formats
+type verifyResult struct {
+	Passed  bool               `json:"passed"`
+	Checks  []etcdreader.Check `json:"checks"`
+	Secrets []output.Record    `json:"secrets,omitempty"`
+}
+
+// newVerifyCommand checks the integrity of a snapshot and, when keys are
+// given, that every secret decrypts, without printing any secret value
+func newVerifyCommand(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify",
+		Short: "Check the integrity of the snapshot and that every secret decrypts with the given keys",
+		Long: `Check the integrity of the snapshot before reading it:
+
+  sha256     the SHA-256 hash appended by etcdctl snapshot save, when present
+  size       no page of the database is missing from the end of the file
+  pages      bbolt page consistency
+  buckets    the key and meta buckets exist
+  revisions  the meta bucket has a consistent index, not below the newest
+             revision (a warning only, as restored members restart it)
+
+When --key or --encryption-config is given, every secret is also decrypted and
+decoded to check the keys, without printing any value.
+
+Exit status:
+  0  every check passed
+  1  the command could not run, e.g. a missing flag or an unreadable key
+  2  the snapshot cannot be opened or failed an integrity check
+  3  some secrets could not be decrypted`,
+		Example: `  etcd-secret-reader verify --snapshot=snapshot.db
+  etcd-secret-reader verify --snapshot=snapshot.db --encryption-config=encryption-config.yaml
+  etcd-secret-reader verify --snapshot=snapshot.db --output=json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.output != "text" && !output.IsStructured(opts.output) {
+				return fmt.Errorf("unsupported output format %q (expected text, json, ndjson or table)", opts.output)
+			}
+
+			reader, err := opts.openReader()
+			if err != nil {
+				if snapshotCorrupt(err) {
+					return &exitError{code: exitCorrupt, err: err}
+				}
+				return err
+			}
+			defer reader.Close()
+
+			report, err := reader.Verify()
+			if err != nil {
+				return err
+			}
+			result := &verifyResult{Passed: report.Passed(), Checks: report.Checks}
+			if opts.output == "text" {
+				printChecks(report.Checks)
+			}
+			if !result.Passed {
+				if err := writeVerifyResult(opts.output, result); err != nil {
+					return err
+				}
+				return &exitError{code: exitCorrupt, err: fmt.Errorf("snapshot failed integrity checks")}
+			}
+
+			failed, err := verifySecrets(opts, reader, result)
+			if err != nil {
+				return err
+			}
+			if err := writeVerifyResult(opts.output, result); err != nil {
+				return err
+			}
+			if failed > 0 {
+				return &exitError{code: exitUndecryptable, err: fmt.Errorf("%d secrets could not be decrypted", failed)}
+			}
+			return nil
+		},
+	}
+}
+
+// snapshotCorrupt reports whether err, from opening the snapshot, means the
+// snapshot is unreadable rather than not given, missing or out of reach
+func snapshotCorrupt(err error) bool {
+	var srcErr *sourceError
+	switch {
+	case errors.Is(err, errNoSnapshot), errors.Is(err, fs.ErrNotExist), errors.Is(err, errTerminalStdin),
+		errors.Is(err, etcdreader.ErrDataDirLocked), errors.As(err, &srcErr):
+		return false
+	}
+	return true
+}
+
+// verifySecrets checks that the key index can be read and, when keys are
+// given, that every secret decrypts; it returns the number of secrets that
+// do not. Text is printed as it goes, records are added to result.
+func verifySecrets(opts *globalOptions, reader *etcdreader.Reader, result *verifyResult) (int, error) {
+	text := opts.output == "text"
+
+	keys, err := listAllKeys(reader, opts.revision)
+	if err != nil {
+		return 0, fmt.Errorf("reading key index: %w", err)
+	}
+	secrets, err := listSecrets(reader, opts.revision)
+	if err != nil {
+		return 0, fmt.Errorf("listing secrets: %w", err)
+	}
+	if text {
+		fmt.Printf("Snapshot readable%s: %d keys, %d secrets\n", revisionSuffix(opts.revision), len(keys), len(secrets))
+	}
+
+	if !opts.hasKeys() {
+		if text {
+			fmt.Println("No --key or --encryption-config given, secrets not checked")
+			return 0, nil
+		}
+		kvs, err := rangePrefixes(reader, secretPrefixes, opts.revision)
+		if err != nil {
+			return 0, err
+		}
+		for _, kv := range kvs {
+			result.Secrets = append(result.Secrets, keyRecord(kv))
+		}
+		return 0, nil
+	}
+
+	decryptor, err := opts.secretDecryptor()
+	if err != nil {
+		return 0, err
+	}
+	defer closeDecryptor(decryptor)
+
+	failed := 0
+	for _, secretPath := range secrets {
+		kv, err := getKeyValue(reader, secretPath, opts.revision)
+		var data []byte
+		if err == nil {
+			data, err = decryptor.DecryptValue(secretPath, kv.Value)
+		}
+		if err == nil {
+			_, err = decodeSecret(data)
+		}
+		if err != nil {
+			failed++
+		}
+
+		switch {
+		case !text && kv == nil:
+			result.Secrets = append(result.Secrets, errorRecord(secretPath, err))
+		case !text:
+			// One record per secret, without its data
+			record := keyRecord(kv)
+			if err != nil {
+				record.Error = err.Error()
+			}
+			result.Secrets = append(result.Secrets, record)
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "  FAIL %s: %v\n", safePrintKey(secretPath), err)
+		}
+	}
+
+	if text {
+		fmt.Printf("Secrets decrypted: %d of %d\n", len(secrets)-failed, len(secrets))
+	}
+	result.Passed = failed == 0
+	return failed, nil
+}
+
+// printChecks prints the integrity checks as text
+func printChecks(checks []etcdreader.Check) {
+	fmt.Println("Integrity checks:")
+	for _, c := range checks {
+		fmt.Printf("  %-4s  %-9s  %s\n", strings.ToUpper(string(c.Status)), c.Name, c.Detail)
+	}
+}
+
+// writeVerifyResult writes the result in a record format: one JSON object,
+// on a single line for ndjson, or a table of checks followed by a table of
+// secrets. Nothing is written for text, which verify prints as it goes.
+func writeVerifyResult(format string, result *verifyResult) error {
+	switch format {
+	case output.FormatJSON:
+		return output.WriteJSON(os.Stdout, result)
+	case output.FormatNDJSON:
+		return json.NewEncoder(os.Stdout).Encode(result)
+	case output.FormatTable:
+		t := output.NewTable(os.Stdout, "CHECK", "STATUS", "DETAIL")
+		for _, c := range result.Checks {
+			t.Row(c.Name, string(c.Status), c.Detail)
+		}
+		if err := t.Flush(); err != nil {
+			return err
+		}
+		if len(result.Secrets) == 0 {
+			return nil
+		}
+		fmt.Println()
+		w, _ := output.NewWriter(output.FormatTable, os.Stdout)
+		for _, r := range result.Secrets {
+			if err := w.Write(r); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	}
+	return nil
+}
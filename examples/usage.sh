@@ -21,38 +21,36 @@ ENCRYPTION_KEY="y0xTt+U6xgRdNxe4nDYYsijOGgRDoUYC+wAwOKeNfPs="
 # Example 1: List all secrets
 echo "Example 1: List all secrets in the snapshot"
 echo "Command:"
-echo "  ./etcd-secret-reader --snapshot=$SNAPSHOT --list"
+echo "  ./etcd-secret-reader list --snapshot=$SNAPSHOT"
 echo
 # Uncomment to run:
-# ./etcd-secret-reader --snapshot="$SNAPSHOT" --list
+# ./etcd-secret-reader list --snapshot="$SNAPSHOT"
 echo
 
 # Example 2: Read a specific secret
 echo "Example 2: Read and decrypt a specific secret"
 echo "Command:"
-echo "  ./etcd-secret-reader \\"
-echo "    --snapshot=$SNAPSHOT \\"
+echo "  ./etcd-secret-reader get $SECRET_NAME \\"
 echo "    --namespace=$NAMESPACE \\"
-echo "    --name=$SECRET_NAME \\"
+echo "    --snapshot=$SNAPSHOT \\"
 echo "    --key=\$ENCRYPTION_KEY"
 echo
 # Uncomment to run:
-# ./etcd-secret-reader \
-#   --snapshot="$SNAPSHOT" \
+# ./etcd-secret-reader get "$SECRET_NAME" \
 #   --namespace="$NAMESPACE" \
-#   --name="$SECRET_NAME" \
+#   --snapshot="$SNAPSHOT" \
 #   --key="$ENCRYPTION_KEY"
 echo
 
 # Example 3: Read all secrets
 echo "Example 3: Read and decrypt all secrets"
 echo "Command:"
-echo "  ./etcd-secret-reader \\"
+echo "  ./etcd-secret-reader dump --all \\"
 echo "    --snapshot=$SNAPSHOT \\"
 echo "    --key=\$ENCRYPTION_KEY"
 echo
 # Uncomment to run:
-# ./etcd-secret-reader \
+# ./etcd-secret-reader dump --all \
 #   --snapshot="$SNAPSHOT" \
 #   --key="$ENCRYPTION_KEY"
 echo
@@ -61,18 +59,16 @@ echo
 echo "Example 4: Using environment variable for encryption key"
 echo "Command:"
 echo "  export ETCD_ENCRYPTION_KEY='$ENCRYPTION_KEY'"
-echo "  ./etcd-secret-reader \\"
-echo "    --snapshot=$SNAPSHOT \\"
+echo "  ./etcd-secret-reader get $SECRET_NAME \\"
 echo "    --namespace=$NAMESPACE \\"
-echo "    --name=$SECRET_NAME \\"
+echo "    --snapshot=$SNAPSHOT \\"
 echo "    --key=\$ETCD_ENCRYPTION_KEY"
 echo
 # Uncomment to run:
 # export ETCD_ENCRYPTION_KEY="$ENCRYPTION_KEY"
-# ./etcd-secret-reader \
-#   --snapshot="$SNAPSHOT" \
+# ./etcd-secret-reader get "$SECRET_NAME" \
 #   --namespace="$NAMESPACE" \
-#   --name="$SECRET_NAME" \
+#   --snapshot="$SNAPSHOT" \
 #   --key="$ETCD_ENCRYPTION_KEY"
 echo
 
@@ -46,39 +46,40 @@ func (d *AESCBCDecryptor) Decrypt(data []byte) ([]byte, error) {
 		return nil, fmt.Errorf("data does not have expected encryption prefix (expected: %s)", prefix)
 	}
 
-	// Remove the prefix: k8s:enc:aescbc:v1:
-	dataWithoutPrefix := data[len(prefix):]
-
-	// Extract key name and encrypted data
-	// Format after prefix: <keyName>:<encrypted-data>
-	parts := bytes.SplitN(dataWithoutPrefix, []byte(":"), 2)
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid encrypted data format: expected <keyName>:<encrypted-data>")
+	keyName, encryptedPayload, err := splitPayload(data, prefix)
+	if err != nil {
+		return nil, err
 	}
 
-	keyName := string(parts[0])
-	encryptedPayload := parts[1]
-
 	// Verify key name matches (optional - for informational purposes)
 	if d.keyName != "" && keyName != d.keyName {
 		return nil, fmt.Errorf("key name mismatch: expected %s, got %s", d.keyName, keyName)
 	}
 
-	// Decrypt using AES-CBC
+	return openCBC(d.block, encryptedPayload)
+}
+
+// DecryptValue decrypts a value; aescbc does not authenticate the etcd key
+func (d *AESCBCDecryptor) DecryptValue(etcdKey string, data []byte) ([]byte, error) {
+	return d.Decrypt(data)
+}
+
+// openCBC decrypts IV-prefixed AES-CBC data and removes its PKCS#7 padding
+func openCBC(block cipher.Block, data []byte) ([]byte, error) {
 	// First 16 bytes are the IV, rest is ciphertext
-	blockSize := d.block.BlockSize()
-	if len(encryptedPayload) < blockSize {
+	blockSize := block.BlockSize()
+	if len(data) < blockSize {
 		return nil, fmt.Errorf("ciphertext too short (must be at least %d bytes)", blockSize)
 	}
-	if len(encryptedPayload)%blockSize != 0 {
+	if len(data)%blockSize != 0 {
 		return nil, fmt.Errorf("ciphertext is not a multiple of block size (%d)", blockSize)
 	}
 
-	iv := encryptedPayload[:blockSize]
-	ciphertext := encryptedPayload[blockSize:]
+	iv := data[:blockSize]
+	ciphertext := data[blockSize:]
 
 	// Decrypt
-	mode := cipher.NewCBCDecrypter(d.block, iv)
+	mode := cipher.NewCBCDecrypter(block, iv)
 	plaintext := make([]byte, len(ciphertext))
 	mode.CryptBlocks(plaintext, ciphertext)
 
@@ -0,0 +1,79 @@
+package decrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+)
+
+// aesGCMPrefix is written by the kube-apiserver aesgcm provider
+const aesGCMPrefix = "k8s:enc:aesgcm:v1:"
+
+// AESGCMDecryptor handles decryption of AES-GCM encrypted data from etcd
+type AESGCMDecryptor struct {
+	aead    cipher.AEAD
+	keyName string
+}
+
+// NewAESGCMDecryptor creates a new AES-GCM decryptor with a 16, 24 or 32-byte key
+func NewAESGCMDecryptor(key []byte, keyName string) (*AESGCMDecryptor, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("AES-GCM requires a 16, 24 or 32-byte key, got %d bytes", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM cipher: %w", err)
+	}
+
+	return &AESGCMDecryptor{
+		aead:    aead,
+		keyName: keyName,
+	}, nil
+}
+
+// Decrypt decrypts data that was encrypted by Kubernetes API server
+// Expected format: k8s:enc:aesgcm:v1:<keyName>:<nonce><ciphertext+tag>
+// authenticatedData must be the etcd key the value was stored under, which
+// the API server binds to the ciphertext
+func (d *AESGCMDecryptor) Decrypt(data, authenticatedData []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(aesGCMPrefix)) && bytes.HasPrefix(data, []byte("{")) {
+		// Looks like JSON, might be unencrypted
+		return data, nil
+	}
+
+	keyName, encryptedPayload, err := splitPayload(data, aesGCMPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.keyName != "" && keyName != d.keyName {
+		return nil, fmt.Errorf("key name mismatch: expected %s, got %s", d.keyName, keyName)
+	}
+
+	// First 12 bytes are the nonce, rest is ciphertext with the GCM tag
+	nonceSize := d.aead.NonceSize()
+	if len(encryptedPayload) < nonceSize+d.aead.Overhead() {
+		return nil, fmt.Errorf("ciphertext too short (must be at least %d bytes)", nonceSize+d.aead.Overhead())
+	}
+
+	plaintext, err := d.aead.Open(nil, encryptedPayload[:nonceSize], encryptedPayload[nonceSize:], authenticatedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// DecryptValue decrypts a value using its etcd key as authenticated data
+func (d *AESGCMDecryptor) DecryptValue(etcdKey string, data []byte) ([]byte, error) {
+	return d.Decrypt(data, []byte(etcdKey))
+}
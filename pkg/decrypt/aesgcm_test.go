@@ -0,0 +1,162 @@
+package decrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"testing"
+)
+
+// encryptGCMTestData encrypts plaintext the way the kube-apiserver aesgcm
+// provider does: a random nonce followed by the sealed ciphertext
+func encryptGCMTestData(key, plaintext, authenticatedData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, authenticatedData), nil
+}
+
+func TestNewAESGCMDecryptor(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyLen    int
+		wantError bool
+	}{
+		{name: "Valid 16-byte key", keyLen: 16},
+		{name: "Valid 24-byte key", keyLen: 24},
+		{name: "Valid 32-byte key", keyLen: 32},
+		{name: "Invalid key length - 8 bytes", keyLen: 8, wantError: true},
+		{name: "Invalid key length - 64 bytes", keyLen: 64, wantError: true},
+		{name: "Empty key", keyLen: 0, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decryptor, err := NewAESGCMDecryptor(make([]byte, tt.keyLen), "key1")
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("NewAESGCMDecryptor() expected error, got nil")
+				}
+				if decryptor != nil {
+					t.Errorf("NewAESGCMDecryptor() expected nil decryptor on error")
+				}
+			} else if err != nil {
+				t.Errorf("NewAESGCMDecryptor() unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestAESGCMDecrypt(t *testing.T) {
+	testKey := make([]byte, 32)
+	if _, err := rand.Read(testKey); err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	etcdKey := []byte("/registry/secrets/default/test")
+	plaintext := []byte(`{"kind":"Secret","apiVersion":"v1","metadata":{"name":"test"}}`)
+
+	encrypted, err := encryptGCMTestData(testKey, plaintext, etcdKey)
+	if err != nil {
+		t.Fatalf("Failed to encrypt test data: %v", err)
+	}
+	fullEncrypted := append([]byte("k8s:enc:aesgcm:v1:key1:"), encrypted...)
+
+	tampered := bytes.Clone(fullEncrypted)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		name      string
+		key       []byte
+		keyName   string
+		data      []byte
+		aad       []byte
+		want      []byte
+		wantError bool
+	}{
+		{name: "Successful decryption", key: testKey, keyName: "key1", data: fullEncrypted, aad: etcdKey, want: plaintext},
+		{name: "Wrong key", key: make([]byte, 32), keyName: "key1", data: fullEncrypted, aad: etcdKey, wantError: true},
+		{name: "Wrong key name", key: testKey, keyName: "key2", data: fullEncrypted, aad: etcdKey, wantError: true},
+		{name: "Value moved to another etcd key", key: testKey, keyName: "key1", data: fullEncrypted, aad: []byte("/registry/secrets/default/other"), wantError: true},
+		{name: "Tampered ciphertext", key: testKey, keyName: "key1", data: tampered, aad: etcdKey, wantError: true},
+		{name: "Plaintext JSON (identity provider)", key: testKey, keyName: "key1", data: []byte(`{"kind":"Secret"}`), want: []byte(`{"kind":"Secret"}`)},
+		{name: "aescbc prefix", key: testKey, keyName: "key1", data: []byte("k8s:enc:aescbc:v1:key1:data"), wantError: true},
+		{name: "Truncated encrypted data", key: testKey, keyName: "key1", data: []byte("k8s:enc:aesgcm:v1:key1:short"), aad: etcdKey, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decryptor, err := NewAESGCMDecryptor(tt.key, tt.keyName)
+			if err != nil {
+				t.Fatalf("NewAESGCMDecryptor() unexpected error: %v", err)
+			}
+
+			got, err := decryptor.Decrypt(tt.data, tt.aad)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("Decrypt() expected error, got nil")
+				}
+			} else {
+				if err != nil {
+					t.Errorf("Decrypt() unexpected error: %v", err)
+				}
+				if !bytes.Equal(got, tt.want) {
+					t.Errorf("Decrypt() = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAESGCMRoundTrip(t *testing.T) {
+	etcdKey := "/registry/secrets/kube-system/bootstrap-token"
+	testCases := [][]byte{
+		[]byte(""),
+		[]byte("a"),
+		bytes.Repeat([]byte("x"), 16),
+		[]byte(`{"kind":"Secret","apiVersion":"v1","metadata":{"name":"mysecret","namespace":"default"},"type":"Opaque","data":{"username":"YWRtaW4="}}`),
+	}
+
+	for _, keyLen := range []int{16, 24, 32} {
+		testKey := make([]byte, keyLen)
+		if _, err := rand.Read(testKey); err != nil {
+			t.Fatalf("Failed to generate test key: %v", err)
+		}
+
+		decryptor, err := NewAESGCMDecryptor(testKey, "key1")
+		if err != nil {
+			t.Fatalf("NewAESGCMDecryptor() error: %v", err)
+		}
+
+		for i, plaintext := range testCases {
+			t.Run(fmt.Sprintf("key%d/case%d", keyLen, i), func(t *testing.T) {
+				encrypted, err := encryptGCMTestData(testKey, plaintext, []byte(etcdKey))
+				if err != nil {
+					t.Fatalf("encryptGCMTestData() error: %v", err)
+				}
+
+				decrypted, err := decryptor.DecryptValue(etcdKey, append([]byte("k8s:enc:aesgcm:v1:key1:"), encrypted...))
+				if err != nil {
+					t.Fatalf("DecryptValue() error: %v", err)
+				}
+				if !bytes.Equal(decrypted, plaintext) {
+					t.Errorf("Round-trip failed: got %q, want %q", decrypted, plaintext)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package decrypt
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// EncryptionConfiguration mirrors the apiserver.config.k8s.io/v1
+// EncryptionConfiguration read by kube-apiserver --encryption-provider-config
+type EncryptionConfiguration struct {
+	Kind       string                  `json:"kind"`
+	APIVersion string                  `json:"apiVersion"`
+	Resources  []ResourceConfiguration `json:"resources"`
+}
+
+// ResourceConfiguration lists the providers used for a set of resources
+type ResourceConfiguration struct {
+	// Resources are group resources such as "secrets" or "deployments.apps";
+	// "*.*", "*." (core group) and "*.<group>" are wildcards
+	Resources []string                `json:"resources"`
+	Providers []ProviderConfiguration `json:"providers"`
+}
+
+// ProviderConfiguration holds exactly one provider
+type ProviderConfiguration struct {
+	AESGCM    *AESConfiguration       `json:"aesgcm,omitempty"`
+	AESCBC    *AESConfiguration       `json:"aescbc,omitempty"`
+	Secretbox *SecretboxConfiguration `json:"secretbox,omitempty"`
+	Identity  *IdentityConfiguration  `json:"identity,omitempty"`
+	KMS       *KMSConfiguration       `json:"kms,omitempty"`
+}
+
+// AESConfiguration holds the keys of an aescbc or aesgcm provider
+type AESConfiguration struct {
+	Keys []Key `json:"keys"`
+}
+
+// SecretboxConfiguration holds the keys of a secretbox provider
+type SecretboxConfiguration struct {
+	Keys []Key `json:"keys"`
+}
+
+// Key is a named base64-encoded key
+type Key struct {
+	Name   string `json:"name"`
+	Secret string `json:"secret"`
+}
+
+// IdentityConfiguration is the empty identity provider
+type IdentityConfiguration struct{}
+
+// KMSConfiguration describes an envelope encryption plugin
+type KMSConfiguration struct {
+	// APIVersion is v1 or v2; v1 is the default
+	APIVersion string           `json:"apiVersion,omitempty"`
+	Name       string           `json:"name"`
+	CacheSize  *int32           `json:"cachesize,omitempty"`
+	Endpoint   string           `json:"endpoint"`
+	Timeout    *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// LoadEncryptionConfig reads and validates an EncryptionConfiguration file
+func LoadEncryptionConfig(path string) (*EncryptionConfiguration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption config: %w", err)
+	}
+	return ParseEncryptionConfig(data)
+}
+
+// ParseEncryptionConfig parses and validates an EncryptionConfiguration document
+func ParseEncryptionConfig(data []byte) (*EncryptionConfiguration, error) {
+	var config EncryptionConfiguration
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse encryption config: %w", err)
+	}
+
+	if config.Kind != "EncryptionConfiguration" {
+		return nil, fmt.Errorf("unexpected kind %q in encryption config (expected EncryptionConfiguration)", config.Kind)
+	}
+	if config.APIVersion != "apiserver.config.k8s.io/v1" {
+		return nil, fmt.Errorf("unsupported apiVersion %q in encryption config (expected apiserver.config.k8s.io/v1)", config.APIVersion)
+	}
+
+	for i, rc := range config.Resources {
+		if len(rc.Resources) == 0 {
+			return nil, fmt.Errorf("resources[%d]: no resources listed", i)
+		}
+		if len(rc.Providers) == 0 {
+			return nil, fmt.Errorf("resources[%d]: no providers listed", i)
+		}
+		for j, p := range rc.Providers {
+			if n := p.count(); n != 1 {
+				return nil, fmt.Errorf("resources[%d].providers[%d]: expected exactly one provider, got %d", i, j, n)
+			}
+		}
+	}
+
+	return &config, nil
+}
+
+// DecryptorFor builds the provider chain the API server would use to read
+// the given group resource, e.g. "secrets" or "deployments.apps"
+// Resources not covered by the configuration are stored unencrypted
+func (c *EncryptionConfiguration) DecryptorFor(resource string) (*ProviderChain, error) {
+	for _, rc := range c.Resources {
+		for _, pattern := range rc.Resources {
+			if !matchesResource(pattern, resource) {
+				continue
+			}
+
+			chain := &ProviderChain{}
+			for _, p := range rc.Providers {
+				entries, err := p.chainEntries()
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", resource, err)
+				}
+				chain.entries = append(chain.entries, entries...)
+			}
+			return chain, nil
+		}
+	}
+
+	return &ProviderChain{entries: []chainEntry{{name: "identity", decryptor: IdentityDecryptor{}}}}, nil
+}
+
+// matchesResource reports whether a configured resource pattern covers resource
+func matchesResource(pattern, resource string) bool {
+	group := ""
+	if i := strings.Index(resource, "."); i >= 0 {
+		group = resource[i+1:]
+	}
+
+	switch {
+	case pattern == "*.*":
+		return true
+	case pattern == "*.":
+		return group == ""
+	case strings.HasPrefix(pattern, "*."):
+		return group == strings.TrimPrefix(pattern, "*.")
+	default:
+		return pattern == resource
+	}
+}
+
+// count returns how many providers are set
+func (p ProviderConfiguration) count() int {
+	n := 0
+	for _, set := range []bool{p.AESGCM != nil, p.AESCBC != nil, p.Secretbox != nil, p.Identity != nil, p.KMS != nil} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// chainEntries expands a provider into one chain entry per key
+func (p ProviderConfiguration) chainEntries() ([]chainEntry, error) {
+	switch {
+	case p.AESCBC != nil:
+		return keyedEntries("aescbc", p.AESCBC.Keys)
+	case p.AESGCM != nil:
+		return keyedEntries("aesgcm", p.AESGCM.Keys)
+	case p.Secretbox != nil:
+		return keyedEntries("secretbox", p.Secretbox.Keys)
+	case p.Identity != nil:
+		return []chainEntry{{name: "identity", decryptor: IdentityDecryptor{}}}, nil
+	case p.KMS != nil:
+		return p.KMS.chainEntries()
+	default:
+		return nil, fmt.Errorf("empty provider configuration")
+	}
+}
+
+// chainEntries creates the decryptor for a KMS plugin
+func (k *KMSConfiguration) chainEntries() ([]chainEntry, error) {
+	version := k.APIVersion
+	if version == "" {
+		version = "v1"
+	}
+
+	var timeout time.Duration
+	if k.Timeout != nil {
+		timeout = k.Timeout.Duration
+	}
+
+	entry := chainEntry{
+		name:   "kms/" + k.Name,
+		prefix: fmt.Sprintf("k8s:enc:kms:%s:%s:", version, k.Name),
+	}
+
+	switch version {
+	case "v1":
+		d, err := NewKMSv1Decryptor(k.Name, k.Endpoint, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("kms provider %q: %w", k.Name, err)
+		}
+		entry.decryptor = d
+	case "v2":
+		d, err := NewKMSv2Decryptor(k.Name, k.Endpoint, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("kms provider %q: %w", k.Name, err)
+		}
+		entry.decryptor = d
+	default:
+		entry.decryptor = unsupportedDecryptor{reason: fmt.Sprintf("kms %s provider %q is not supported", version, k.Name)}
+	}
+
+	return []chainEntry{entry}, nil
+}
+
+// keyedEntries creates a decryptor for every key of an aescbc, aesgcm or secretbox provider
+func keyedEntries(provider string, keys []Key) ([]chainEntry, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s provider has no keys", provider)
+	}
+
+	entries := make([]chainEntry, 0, len(keys))
+	for _, key := range keys {
+		secret, err := base64.StdEncoding.DecodeString(key.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("%s key %q: invalid base64 secret: %w", provider, key.Name, err)
+		}
+
+		d, err := NewDecryptor(provider, secret, key.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%s key %q: %w", provider, key.Name, err)
+		}
+
+		entries = append(entries, chainEntry{
+			name:      provider + "/" + key.Name,
+			prefix:    providerPrefix(provider, key.Name),
+			decryptor: d,
+		})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,250 @@
+package decrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadEncryptionConfigExample(t *testing.T) {
+	config, err := LoadEncryptionConfig(filepath.Join("..", "..", "examples", "encryption-config.yaml"))
+	if err != nil {
+		t.Fatalf("LoadEncryptionConfig() error: %v", err)
+	}
+
+	chain, err := config.DecryptorFor("secrets")
+	if err != nil {
+		t.Fatalf("DecryptorFor() error: %v", err)
+	}
+
+	want := []string{"aescbc/key1", "identity"}
+	if got := chain.Providers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Providers() = %v, want %v", got, want)
+	}
+}
+
+func TestParseEncryptionConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{
+			name:   "Wrong kind",
+			config: "apiVersion: apiserver.config.k8s.io/v1\nkind: Secret\n",
+		},
+		{
+			name:   "Wrong apiVersion",
+			config: "apiVersion: v1\nkind: EncryptionConfiguration\n",
+		},
+		{
+			name: "Two providers in one entry",
+			config: `apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+  - resources: [secrets]
+    providers:
+      - identity: {}
+        secretbox:
+          keys: [{name: key1, secret: c2VjcmV0}]
+`,
+		},
+		{
+			name: "No providers",
+			config: `apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+  - resources: [secrets]
+`,
+		},
+		{
+			name: "Unknown field",
+			config: `apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+  - resources: [secrets]
+    providers:
+      - rot13: {}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseEncryptionConfig([]byte(tt.config)); err == nil {
+				t.Errorf("ParseEncryptionConfig() expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestMatchesResource(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		resource string
+		want     bool
+	}{
+		{"secrets", "secrets", true},
+		{"secrets", "configmaps", false},
+		{"*.*", "deployments.apps", true},
+		{"*.", "configmaps", true},
+		{"*.", "deployments.apps", false},
+		{"*.apps", "deployments.apps", true},
+		{"*.apps", "secrets", false},
+		{"deployments.apps", "deployments.apps", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"/"+tt.resource, func(t *testing.T) {
+			if got := matchesResource(tt.pattern, tt.resource); got != tt.want {
+				t.Errorf("matchesResource(%q, %q) = %v, want %v", tt.pattern, tt.resource, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderChain(t *testing.T) {
+	cbcKey := make([]byte, 32)
+	oldCBCKey := make([]byte, 32)
+	gcmKey := make([]byte, 16)
+	boxKey := make([]byte, 32)
+	for _, k := range [][]byte{cbcKey, oldCBCKey, gcmKey, boxKey} {
+		if _, err := rand.Read(k); err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+	}
+	b64 := base64.StdEncoding.EncodeToString
+
+	config, err := ParseEncryptionConfig([]byte(fmt.Sprintf(`apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+  - resources: [secrets]
+    providers:
+      - identity: {}
+      - aesgcm:
+          keys:
+            - name: gcm1
+              secret: %s
+      - aescbc:
+          keys:
+            - name: new
+              secret: %s
+            - name: old
+              secret: %s
+      - secretbox:
+          keys:
+            - name: box1
+              secret: %s
+      - kms:
+          apiVersion: v2
+          name: vault
+          endpoint: unix:///var/run/kms.sock
+          timeout: 3s
+  - resources: ["*.apps"]
+    providers:
+      - secretbox:
+          keys:
+            - name: box1
+              secret: %s
+`, b64(gcmKey), b64(cbcKey), b64(oldCBCKey), b64(boxKey), b64(boxKey))))
+	if err != nil {
+		t.Fatalf("ParseEncryptionConfig() error: %v", err)
+	}
+
+	if got := config.Resources[0].Providers[4].KMS.Timeout.Duration.String(); got != "3s" {
+		t.Errorf("kms timeout = %s, want 3s", got)
+	}
+
+	etcdKey := "/registry/secrets/default/test"
+	plaintext := []byte(`{"kind":"Secret"}`)
+
+	cbcData, _ := encryptTestData(oldCBCKey, plaintext)
+	gcmData, _ := encryptGCMTestData(gcmKey, plaintext, []byte(etcdKey))
+	boxData, _ := encryptSecretboxTestData(boxKey, plaintext)
+
+	chain, err := config.DecryptorFor("secrets")
+	if err != nil {
+		t.Fatalf("DecryptorFor() error: %v", err)
+	}
+
+	wantProviders := []string{"identity", "aesgcm/gcm1", "aescbc/new", "aescbc/old", "secretbox/box1", "kms/vault"}
+	if got := chain.Providers(); !reflect.DeepEqual(got, wantProviders) {
+		t.Errorf("Providers() = %v, want %v", got, wantProviders)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{name: "Unencrypted", data: plaintext},
+		{name: "aesgcm", data: append([]byte("k8s:enc:aesgcm:v1:gcm1:"), gcmData...)},
+		{name: "aescbc rotated key", data: append([]byte("k8s:enc:aescbc:v1:old:"), cbcData...)},
+		{name: "secretbox", data: append([]byte("k8s:enc:secretbox:v1:box1:"), boxData...)},
+		{name: "Wrong aescbc key", data: append([]byte("k8s:enc:aescbc:v1:new:"), cbcData...), wantErr: "aescbc/new"},
+		{name: "Unknown key name", data: append([]byte("k8s:enc:aescbc:v1:missing:"), cbcData...), wantErr: "no configured provider"},
+		{name: "kms", data: []byte("k8s:enc:kms:v2:vault:payload"), wantErr: "invalid EncryptedObject"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chain.DecryptValue(etcdKey, tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("DecryptValue() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecryptValue() unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("DecryptValue() = %q, want %q", got, plaintext)
+			}
+		})
+	}
+
+	// Wildcard group match and the identity fallback for unlisted resources
+	appsChain, err := config.DecryptorFor("deployments.apps")
+	if err != nil {
+		t.Fatalf("DecryptorFor(deployments.apps) error: %v", err)
+	}
+	if got := appsChain.Providers(); !reflect.DeepEqual(got, []string{"secretbox/box1"}) {
+		t.Errorf("deployments.apps providers = %v", got)
+	}
+
+	cmChain, err := config.DecryptorFor("configmaps")
+	if err != nil {
+		t.Fatalf("DecryptorFor(configmaps) error: %v", err)
+	}
+	if got := cmChain.Providers(); !reflect.DeepEqual(got, []string{"identity"}) {
+		t.Errorf("configmaps providers = %v", got)
+	}
+	if _, err := cmChain.DecryptValue("/registry/configmaps/default/x", []byte("k8s:enc:aescbc:v1:new:x")); err == nil {
+		t.Errorf("identity-only chain should reject encrypted data")
+	}
+}
+
+func TestDecryptorForInvalidKey(t *testing.T) {
+	config, err := ParseEncryptionConfig([]byte(`apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+  - resources: [secrets]
+    providers:
+      - aescbc:
+          keys:
+            - name: short
+              secret: c2hvcnQ=
+`))
+	if err != nil {
+		t.Fatalf("ParseEncryptionConfig() error: %v", err)
+	}
+
+	if _, err := config.DecryptorFor("secrets"); err == nil {
+		t.Errorf("DecryptorFor() expected error for a 5-byte aescbc key")
+	}
+}
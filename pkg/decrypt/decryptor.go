@@ -0,0 +1,43 @@
+package decrypt
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ValueDecryptor decrypts values read from etcd
+// etcdKey is the storage path the value was read from; providers that
+// authenticate it, such as aesgcm, use it as additional authenticated data
+type ValueDecryptor interface {
+	DecryptValue(etcdKey string, data []byte) ([]byte, error)
+}
+
+// NewDecryptor creates the decryptor for the given provider name, as returned
+// by ParseEncryptionPrefix
+func NewDecryptor(provider string, key []byte, keyName string) (ValueDecryptor, error) {
+	switch provider {
+	case "aescbc":
+		return NewAESCBCDecryptor(key, keyName)
+	case "aesgcm":
+		return NewAESGCMDecryptor(key, keyName)
+	case "secretbox":
+		return NewSecretboxDecryptor(key, keyName)
+	default:
+		return nil, fmt.Errorf("unsupported encryption provider: %s", provider)
+	}
+}
+
+// splitPayload strips prefix and the key name that follows it
+// Expected format: <prefix><keyName>:<encrypted-data>
+func splitPayload(data []byte, prefix string) (keyName string, payload []byte, err error) {
+	if !bytes.HasPrefix(data, []byte(prefix)) {
+		return "", nil, fmt.Errorf("data does not have expected encryption prefix (expected: %s)", prefix)
+	}
+
+	parts := bytes.SplitN(data[len(prefix):], []byte(":"), 2)
+	if len(parts) != 2 {
+		return "", nil, fmt.Errorf("invalid encrypted data format: expected <keyName>:<encrypted-data>")
+	}
+
+	return string(parts[0]), parts[1], nil
+}
@@ -0,0 +1,56 @@
+package decrypt
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNewDecryptor(t *testing.T) {
+	tests := []struct {
+		provider  string
+		keyLen    int
+		wantType  string
+		wantError bool
+	}{
+		{provider: "aescbc", keyLen: 32, wantType: "*decrypt.AESCBCDecryptor"},
+		{provider: "aesgcm", keyLen: 16, wantType: "*decrypt.AESGCMDecryptor"},
+		{provider: "secretbox", keyLen: 32, wantType: "*decrypt.SecretboxDecryptor"},
+		{provider: "aescbc", keyLen: 16, wantError: true},
+		{provider: "unknown", keyLen: 32, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			d, err := NewDecryptor(tt.provider, make([]byte, tt.keyLen), "key1")
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("NewDecryptor(%q) expected error, got nil", tt.provider)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewDecryptor(%q) unexpected error: %v", tt.provider, err)
+			}
+			if got := fmt.Sprintf("%T", d); got != tt.wantType {
+				t.Errorf("NewDecryptor(%q) = %s, want %s", tt.provider, got, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestSplitPayload(t *testing.T) {
+	keyName, payload, err := splitPayload([]byte("k8s:enc:aesgcm:v1:key1:a:b"), aesGCMPrefix)
+	if err != nil {
+		t.Fatalf("splitPayload() error: %v", err)
+	}
+	if keyName != "key1" || string(payload) != "a:b" {
+		t.Errorf("splitPayload() = %q, %q, want key1, a:b", keyName, payload)
+	}
+
+	if _, _, err := splitPayload([]byte("k8s:enc:aesgcm:v1:key1"), aesGCMPrefix); err == nil {
+		t.Errorf("splitPayload() expected error for missing payload")
+	}
+	if _, _, err := splitPayload([]byte("k8s:enc:aescbc:v1:key1:x"), aesGCMPrefix); err == nil {
+		t.Errorf("splitPayload() expected error for wrong prefix")
+	}
+}
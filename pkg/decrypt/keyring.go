@@ -0,0 +1,122 @@
+package decrypt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Keyring holds named keys across providers and routes each value to the key
+// named in its k8s:enc:<provider>:v1:<keyName>: prefix
+// This reads snapshots taken mid-rotation, where values written with the old
+// and the new key live side by side
+// A Keyring may decrypt values from several goroutines once its keys are added
+type Keyring struct {
+	// keys maps provider and key name to the raw key; an empty provider
+	// means the key may be used with any provider
+	keys map[keyringID][]byte
+
+	// mu guards decryptors, which are built on first use
+	mu         sync.Mutex
+	decryptors map[keyringID]ValueDecryptor
+}
+
+// keyringID identifies a key within a Keyring
+type keyringID struct {
+	provider string
+	name     string
+}
+
+// NewKeyring creates an empty keyring
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys:       make(map[keyringID][]byte),
+		decryptors: make(map[keyringID]ValueDecryptor),
+	}
+}
+
+// Add registers a named key; provider restricts it to one provider such as
+// "aescbc", or is empty to use the key with whichever provider a value names
+func (k *Keyring) Add(provider, name string, key []byte) error {
+	if name == "" {
+		return fmt.Errorf("key name must not be empty")
+	}
+
+	id := keyringID{provider: provider, name: name}
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("duplicate key %s", id)
+	}
+
+	k.keys[id] = key
+	return nil
+}
+
+// Len returns the number of keys in the keyring
+func (k *Keyring) Len() int {
+	return len(k.keys)
+}
+
+// DecryptValue decrypts a value read from etcdKey with the key its prefix names
+// Unencrypted values are returned as is
+func (k *Keyring) DecryptValue(etcdKey string, data []byte) ([]byte, error) {
+	provider, keyName, err := ParseEncryptionPrefix(data)
+	if err != nil {
+		return IdentityDecryptor{}.DecryptValue(etcdKey, data)
+	}
+
+	d, err := k.decryptorFor(provider, keyName)
+	if err != nil {
+		return nil, err
+	}
+	return d.DecryptValue(etcdKey, data)
+}
+
+// decryptorFor returns the decryptor for a provider and key name, preferring
+// a key registered for that provider over one registered for any provider
+func (k *Keyring) decryptorFor(provider, keyName string) (ValueDecryptor, error) {
+	// Decryptors are cached per provider, also for keys usable with any provider
+	cacheID := keyringID{provider: provider, name: keyName}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if d, ok := k.decryptors[cacheID]; ok {
+		return d, nil
+	}
+
+	for _, id := range []keyringID{cacheID, {name: keyName}} {
+		key, ok := k.keys[id]
+		if !ok {
+			continue
+		}
+
+		d, err := NewDecryptor(provider, key, keyName)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", keyName, err)
+		}
+		k.decryptors[cacheID] = d
+		return d, nil
+	}
+
+	return nil, fmt.Errorf("no %s key named %q in keyring (have: %s)", provider, keyName, k.names())
+}
+
+// names lists the keys in the keyring for error messages
+func (k *Keyring) names() string {
+	if len(k.keys) == 0 {
+		return "none"
+	}
+
+	names := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		names = append(names, id.String())
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func (id keyringID) String() string {
+	if id.provider == "" {
+		return id.name
+	}
+	return id.provider + "/" + id.name
+}
@@ -0,0 +1,129 @@
+package decrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestKeyringAdd(t *testing.T) {
+	k := NewKeyring()
+
+	if err := k.Add("", "key1", make([]byte, 32)); err != nil {
+		t.Fatalf("Add() unexpected error: %v", err)
+	}
+	if err := k.Add("aesgcm", "key1", make([]byte, 16)); err != nil {
+		t.Fatalf("Add() provider-specific key unexpected error: %v", err)
+	}
+	if err := k.Add("", "key1", make([]byte, 32)); err == nil {
+		t.Errorf("Add() expected error for duplicate key")
+	}
+	if err := k.Add("", "", make([]byte, 32)); err == nil {
+		t.Errorf("Add() expected error for empty key name")
+	}
+	if k.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", k.Len())
+	}
+}
+
+func TestKeyringDecryptValue(t *testing.T) {
+	key1 := make([]byte, 32)
+	key2 := make([]byte, 32)
+	gcmKey := make([]byte, 24)
+	for _, k := range [][]byte{key1, key2, gcmKey} {
+		if _, err := rand.Read(k); err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+	}
+
+	etcdKey := "/registry/secrets/default/test"
+	plaintext := []byte(`{"kind":"Secret"}`)
+
+	cbc1, _ := encryptTestData(key1, plaintext)
+	cbc2, _ := encryptTestData(key2, plaintext)
+	box2, _ := encryptSecretboxTestData(key2, plaintext)
+	gcm1, _ := encryptGCMTestData(gcmKey, plaintext, []byte(etcdKey))
+
+	k := NewKeyring()
+	for _, add := range []struct {
+		provider, name string
+		key            []byte
+	}{
+		{"", "key1", key1},
+		{"", "key2", key2},
+		{"aesgcm", "key1", gcmKey},
+	} {
+		if err := k.Add(add.provider, add.name, add.key); err != nil {
+			t.Fatalf("Add() error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{name: "aescbc key1", data: append([]byte("k8s:enc:aescbc:v1:key1:"), cbc1...)},
+		{name: "aescbc key2", data: append([]byte("k8s:enc:aescbc:v1:key2:"), cbc2...)},
+		{name: "secretbox key2", data: append([]byte("k8s:enc:secretbox:v1:key2:"), box2...)},
+		{name: "aesgcm prefers provider key", data: append([]byte("k8s:enc:aesgcm:v1:key1:"), gcm1...)},
+		{name: "Unencrypted", data: plaintext},
+		{name: "Unknown key", data: append([]byte("k8s:enc:aescbc:v1:key3:"), cbc1...), wantErr: `no aescbc key named "key3"`},
+		{name: "Wrong key for value", data: append([]byte("k8s:enc:aescbc:v1:key2:"), cbc1...), wantErr: "padding"},
+		{name: "Unsupported provider", data: []byte("k8s:enc:kms:v1:key1:x"), wantErr: "unsupported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.DecryptValue(etcdKey, tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("DecryptValue() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecryptValue() unexpected error: %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("DecryptValue() = %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestKeyringConcurrentDecryptValue(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	plaintext := []byte(`{"kind":"Secret"}`)
+	cbc, _ := encryptTestData(key, plaintext)
+	box, _ := encryptSecretboxTestData(key, plaintext)
+
+	k := NewKeyring()
+	if err := k.Add("", "key1", key); err != nil {
+		t.Fatalf("Add() error: %v", err)
+	}
+
+	// The kube-serve handlers share one keyring, whose decryptors are built
+	// by whichever request needs them first
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		data := append([]byte("k8s:enc:aescbc:v1:key1:"), cbc...)
+		if i%2 == 1 {
+			data = append([]byte("k8s:enc:secretbox:v1:key1:"), box...)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := k.DecryptValue("/registry/secrets/default/test", data)
+			if err != nil || !bytes.Equal(got, plaintext) {
+				t.Errorf("DecryptValue() = %q, %v, want %q", got, err, plaintext)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,63 @@
+package decrypt
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// defaultKMSTimeout matches the kube-apiserver default for KMS calls
+const defaultKMSTimeout = 3 * time.Second
+
+// dialKMSPlugin creates a client connection to the KMS plugin listening on
+// endpoint (unix:///path/to/socket); the connection is established on first use
+func dialKMSPlugin(endpoint string) (*grpc.ClientConn, error) {
+	if !strings.HasPrefix(endpoint, "unix://") {
+		return nil, fmt.Errorf("kms endpoint %q must use the unix:// scheme", endpoint)
+	}
+
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kms client for %s: %w", endpoint, err)
+	}
+	return conn, nil
+}
+
+// dekCache holds unwrapped DEKs keyed by the hash of their encrypted form,
+// so each DEK costs a single plugin call
+type dekCache struct {
+	mu   sync.Mutex
+	deks map[[sha256.Size]byte][]byte
+}
+
+func newDEKCache() *dekCache {
+	return &dekCache{deks: make(map[[sha256.Size]byte][]byte)}
+}
+
+// get returns the cached DEK for encryptedDEK, calling unwrap on a miss
+func (c *dekCache) get(encryptedDEK []byte, unwrap func() ([]byte, error)) ([]byte, error) {
+	cacheKey := sha256.Sum256(encryptedDEK)
+
+	c.mu.Lock()
+	dek, ok := c.deks[cacheKey]
+	c.mu.Unlock()
+	if ok {
+		return dek, nil
+	}
+
+	dek, err := unwrap()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.deks[cacheKey] = dek
+	c.mu.Unlock()
+
+	return dek, nil
+}
@@ -0,0 +1,97 @@
+package decrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	kmsapi "k8s.io/kms/apis/v1beta1"
+)
+
+// kmsv1APIVersion is sent with every request to a KMS v1 plugin
+const kmsv1APIVersion = "v1beta1"
+
+// KMSv1Decryptor decrypts k8s:enc:kms:v1:<name>: values by asking a KMS v1
+// plugin to unwrap the DEK stored in front of each value; unwrapped DEKs
+// are cached by the hash of their encrypted form
+type KMSv1Decryptor struct {
+	name    string
+	conn    *grpc.ClientConn
+	client  kmsapi.KeyManagementServiceClient
+	timeout time.Duration
+	deks    *dekCache
+}
+
+// NewKMSv1Decryptor creates a decryptor for the KMS v1 provider called name,
+// talking to the plugin listening on endpoint (unix:///path/to/socket)
+// The connection is established on first use
+func NewKMSv1Decryptor(name, endpoint string, timeout time.Duration) (*KMSv1Decryptor, error) {
+	if timeout <= 0 {
+		timeout = defaultKMSTimeout
+	}
+
+	conn, err := dialKMSPlugin(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KMSv1Decryptor{
+		name:    name,
+		conn:    conn,
+		client:  kmsapi.NewKeyManagementServiceClient(conn),
+		timeout: timeout,
+		deks:    newDEKCache(),
+	}, nil
+}
+
+// Close closes the connection to the plugin
+func (d *KMSv1Decryptor) Close() error {
+	return d.conn.Close()
+}
+
+// DecryptValue decrypts a value; KMS v1 does not authenticate the etcd key
+// Expected format: k8s:enc:kms:v1:<name>:<DEK length (2 bytes)><encrypted DEK><IV><ciphertext>
+func (d *KMSv1Decryptor) DecryptValue(etcdKey string, data []byte) ([]byte, error) {
+	prefix := "k8s:enc:kms:v1:" + d.name + ":"
+	if !bytes.HasPrefix(data, []byte(prefix)) {
+		return nil, fmt.Errorf("data does not have expected encryption prefix (expected: %s)", prefix)
+	}
+	payload := data[len(prefix):]
+
+	if len(payload) < 2 {
+		return nil, fmt.Errorf("invalid KMS v1 envelope: missing DEK length")
+	}
+	dekLen := int(binary.BigEndian.Uint16(payload[:2]))
+	if dekLen == 0 || len(payload) < 2+dekLen {
+		return nil, fmt.Errorf("invalid KMS v1 envelope: encrypted DEK length %d exceeds data", dekLen)
+	}
+	encryptedDEK := payload[2 : 2+dekLen]
+
+	dek, err := d.deks.get(encryptedDEK, func() ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+		defer cancel()
+
+		resp, err := d.client.Decrypt(ctx, &kmsapi.DecryptRequest{
+			Version: kmsv1APIVersion,
+			Cipher:  encryptedDEK,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("kms plugin %q failed to decrypt DEK: %w", d.name, err)
+		}
+		return resp.GetPlain(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The API server encrypts KMS v1 payloads with AES-CBC under the DEK
+	block, err := aes.NewCipher(dek)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEK: %w", err)
+	}
+	return openCBC(block, payload[2+dekLen:])
+}
@@ -0,0 +1,224 @@
+package decrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	kmsapi "k8s.io/kms/apis/v1beta1"
+)
+
+// fakeKMSv1Plugin is an in-process KMS v1 plugin wrapping DEKs with a local KEK
+type fakeKMSv1Plugin struct {
+	kmsapi.UnimplementedKeyManagementServiceServer
+
+	kek      cipher.AEAD
+	decrypts atomic.Int32
+}
+
+// startFakeKMSv1Plugin serves a fake plugin on a unix socket and returns its endpoint
+func startFakeKMSv1Plugin(t *testing.T) (*fakeKMSv1Plugin, string) {
+	t.Helper()
+
+	kek := make([]byte, 32)
+	if _, err := rand.Read(kek); err != nil {
+		t.Fatalf("Failed to generate KEK: %v", err)
+	}
+	block, _ := aes.NewCipher(kek)
+	aead, _ := cipher.NewGCM(block)
+
+	socket := filepath.Join(t.TempDir(), "kms.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", socket, err)
+	}
+
+	plugin := &fakeKMSv1Plugin{kek: aead}
+	server := grpc.NewServer()
+	kmsapi.RegisterKeyManagementServiceServer(server, plugin)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	return plugin, "unix://" + socket
+}
+
+func (p *fakeKMSv1Plugin) Decrypt(ctx context.Context, req *kmsapi.DecryptRequest) (*kmsapi.DecryptResponse, error) {
+	p.decrypts.Add(1)
+	if req.Version != "v1beta1" {
+		return nil, errors.New("unsupported API version " + req.Version)
+	}
+	plain, err := p.kek.Open(nil, req.Cipher[:12], req.Cipher[12:], nil)
+	if err != nil {
+		return nil, err
+	}
+	return &kmsapi.DecryptResponse{Plain: plain}, nil
+}
+
+// wrap encrypts a DEK with the plugin's KEK
+func (p *fakeKMSv1Plugin) wrap(dek []byte) []byte {
+	nonce := make([]byte, 12)
+	rand.Read(nonce)
+	return p.kek.Seal(nonce, nonce, dek, nil)
+}
+
+// encryptKMSv1TestData builds a value the way the kube-apiserver KMS v1 provider writes it
+func encryptKMSv1TestData(t *testing.T, providerName string, encryptedDEK, dek, plaintext []byte) []byte {
+	t.Helper()
+
+	encrypted, err := encryptTestData(dek, plaintext)
+	if err != nil {
+		t.Fatalf("encryptTestData() error: %v", err)
+	}
+
+	data := []byte("k8s:enc:kms:v1:" + providerName + ":")
+	data = binary.BigEndian.AppendUint16(data, uint16(len(encryptedDEK)))
+	data = append(data, encryptedDEK...)
+	return append(data, encrypted...)
+}
+
+func TestNewKMSv1Decryptor(t *testing.T) {
+	if _, err := NewKMSv1Decryptor("legacy", "/var/run/kms.sock", 0); err == nil {
+		t.Errorf("NewKMSv1Decryptor() expected error for endpoint without unix://")
+	}
+
+	d, err := NewKMSv1Decryptor("legacy", "unix:///nonexistent/kms.sock", 0)
+	if err != nil {
+		t.Fatalf("NewKMSv1Decryptor() unexpected error: %v", err)
+	}
+	defer d.Close()
+
+	if d.timeout != defaultKMSTimeout {
+		t.Errorf("timeout = %v, want default %v", d.timeout, defaultKMSTimeout)
+	}
+}
+
+func TestKMSv1Decrypt(t *testing.T) {
+	plugin, endpoint := startFakeKMSv1Plugin(t)
+
+	d, err := NewKMSv1Decryptor("legacy", endpoint, 0)
+	if err != nil {
+		t.Fatalf("NewKMSv1Decryptor() error: %v", err)
+	}
+	defer d.Close()
+
+	plaintext := []byte(`{"kind":"Secret","apiVersion":"v1"}`)
+	dek := make([]byte, 32)
+	rand.Read(dek)
+	encryptedDEK := plugin.wrap(dek)
+
+	otherDEK := make([]byte, 32)
+	rand.Read(otherDEK)
+
+	tests := []struct {
+		name      string
+		data      []byte
+		wantErr   string
+		wantCalls int32
+	}{
+		{
+			name:      "Valid envelope",
+			data:      encryptKMSv1TestData(t, "legacy", encryptedDEK, dek, plaintext),
+			wantCalls: 1,
+		},
+		{
+			name:      "Same DEK is served from cache",
+			data:      encryptKMSv1TestData(t, "legacy", encryptedDEK, dek, plaintext),
+			wantCalls: 1,
+		},
+		{
+			name:      "New DEK",
+			data:      encryptKMSv1TestData(t, "legacy", plugin.wrap(otherDEK), otherDEK, plaintext),
+			wantCalls: 2,
+		},
+		{
+			name:      "DEK rejected by plugin",
+			data:      encryptKMSv1TestData(t, "legacy", []byte("not-a-wrapped-dek"), dek, plaintext),
+			wantErr:   "failed to decrypt DEK",
+			wantCalls: 3,
+		},
+		{
+			name:      "Other provider name",
+			data:      encryptKMSv1TestData(t, "vault", encryptedDEK, dek, plaintext),
+			wantErr:   "expected encryption prefix",
+			wantCalls: 3,
+		},
+		{
+			name:      "Missing DEK length",
+			data:      []byte("k8s:enc:kms:v1:legacy:\x01"),
+			wantErr:   "missing DEK length",
+			wantCalls: 3,
+		},
+		{
+			name:      "DEK length exceeds data",
+			data:      []byte("k8s:enc:kms:v1:legacy:\x00\x20abc"),
+			wantErr:   "exceeds data",
+			wantCalls: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.DecryptValue("/registry/secrets/default/test", tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("DecryptValue() error = %v, want error containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("DecryptValue() unexpected error: %v", err)
+			} else if string(got) != string(plaintext) {
+				t.Errorf("DecryptValue() = %q, want %q", got, plaintext)
+			}
+
+			if calls := plugin.decrypts.Load(); calls != tt.wantCalls {
+				t.Errorf("plugin Decrypt calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestKMSv1FromEncryptionConfig(t *testing.T) {
+	plugin, endpoint := startFakeKMSv1Plugin(t)
+
+	// apiVersion defaults to v1
+	config, err := ParseEncryptionConfig([]byte(`apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+  - resources: [secrets]
+    providers:
+      - kms:
+          name: legacy
+          endpoint: ` + endpoint + `
+          cachesize: 100
+      - identity: {}
+`))
+	if err != nil {
+		t.Fatalf("ParseEncryptionConfig() error: %v", err)
+	}
+
+	chain, err := config.DecryptorFor("secrets")
+	if err != nil {
+		t.Fatalf("DecryptorFor() error: %v", err)
+	}
+	defer chain.Close()
+
+	dek := make([]byte, 32)
+	rand.Read(dek)
+	value := encryptKMSv1TestData(t, "legacy", plugin.wrap(dek), dek, []byte("plaintext"))
+
+	got, err := chain.DecryptValue("/registry/secrets/kube-system/token", value)
+	if err != nil {
+		t.Fatalf("DecryptValue() error: %v", err)
+	}
+	if string(got) != "plaintext" {
+		t.Errorf("DecryptValue() = %q, want %q", got, "plaintext")
+	}
+}
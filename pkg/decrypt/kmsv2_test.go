@@ -0,0 +1,279 @@
+package decrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+	kmsapi "k8s.io/kms/apis/v2"
+)
+
+// fakeKMSv2Plugin is an in-process KMS v2 plugin wrapping DEKs with a local KEK
+type fakeKMSv2Plugin struct {
+	kmsapi.UnimplementedKeyManagementServiceServer
+
+	kek      cipher.AEAD
+	keyID    string
+	wrapped  map[string][]byte
+	decrypts atomic.Int32
+}
+
+// startFakeKMSv2Plugin serves a fake plugin on a unix socket and returns its endpoint
+func startFakeKMSv2Plugin(t *testing.T) (*fakeKMSv2Plugin, string) {
+	t.Helper()
+
+	kek := make([]byte, 32)
+	if _, err := rand.Read(kek); err != nil {
+		t.Fatalf("Failed to generate KEK: %v", err)
+	}
+	block, _ := aes.NewCipher(kek)
+	aead, _ := cipher.NewGCM(block)
+
+	socket := filepath.Join(t.TempDir(), "kms.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", socket, err)
+	}
+
+	plugin := &fakeKMSv2Plugin{kek: aead, keyID: "kek-1", wrapped: make(map[string][]byte)}
+	server := grpc.NewServer()
+	kmsapi.RegisterKeyManagementServiceServer(server, plugin)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	return plugin, "unix://" + socket
+}
+
+func (p *fakeKMSv2Plugin) Status(ctx context.Context, req *kmsapi.StatusRequest) (*kmsapi.StatusResponse, error) {
+	return &kmsapi.StatusResponse{Version: "v2", Healthz: "ok", KeyId: p.keyID}, nil
+}
+
+func (p *fakeKMSv2Plugin) Decrypt(ctx context.Context, req *kmsapi.DecryptRequest) (*kmsapi.DecryptResponse, error) {
+	p.decrypts.Add(1)
+	if req.KeyId != p.keyID || string(req.Annotations["kms.example.com/region"]) != "eu-1" {
+		return nil, errUnknownKey
+	}
+	plaintext, err := p.kek.Open(nil, req.Ciphertext[:12], req.Ciphertext[12:], nil)
+	if err != nil {
+		return nil, err
+	}
+	return &kmsapi.DecryptResponse{Plaintext: plaintext}, nil
+}
+
+var errUnknownKey = errors.New("unknown key or annotations")
+
+// wrap encrypts a DEK with the plugin's KEK; like the API server, each DEK
+// is wrapped once and the encrypted DEK reused for every value
+func (p *fakeKMSv2Plugin) wrap(dek []byte) []byte {
+	if wrapped, ok := p.wrapped[string(dek)]; ok {
+		return wrapped
+	}
+
+	nonce := make([]byte, 12)
+	rand.Read(nonce)
+	wrapped := p.kek.Seal(nonce, nonce, dek, nil)
+	p.wrapped[string(dek)] = wrapped
+	return wrapped
+}
+
+// encryptKMSv2TestData builds a value the way the kube-apiserver KMS v2
+// provider writes it with an AES-GCM DEK
+func encryptKMSv2TestData(t *testing.T, plugin *fakeKMSv2Plugin, providerName string, dek []byte, etcdKey string, plaintext []byte) []byte {
+	t.Helper()
+
+	encrypted, err := encryptGCMTestData(dek, plaintext, []byte(etcdKey))
+	if err != nil {
+		t.Fatalf("encryptGCMTestData() error: %v", err)
+	}
+	return kmsv2TestEnvelope(plugin, providerName, dek, dekSourceAESGCMKey, encrypted)
+}
+
+// kmsv2TestEnvelope wraps encrypted data in an EncryptedObject whose DEK
+// source, a DEK or an HKDF seed, is wrapped by plugin
+func kmsv2TestEnvelope(plugin *fakeKMSv2Plugin, providerName string, dekSource []byte, sourceType int32, encrypted []byte) []byte {
+	var annotation []byte
+	annotation = protowire.AppendTag(annotation, 1, protowire.BytesType)
+	annotation = protowire.AppendString(annotation, "kms.example.com/region")
+	annotation = protowire.AppendTag(annotation, 2, protowire.BytesType)
+	annotation = protowire.AppendBytes(annotation, []byte("eu-1"))
+
+	var obj []byte
+	obj = protowire.AppendTag(obj, 1, protowire.BytesType)
+	obj = protowire.AppendBytes(obj, encrypted)
+	obj = protowire.AppendTag(obj, 2, protowire.BytesType)
+	obj = protowire.AppendString(obj, plugin.keyID)
+	obj = protowire.AppendTag(obj, 3, protowire.BytesType)
+	obj = protowire.AppendBytes(obj, plugin.wrap(dekSource))
+	obj = protowire.AppendTag(obj, 4, protowire.BytesType)
+	obj = protowire.AppendBytes(obj, annotation)
+	obj = protowire.AppendTag(obj, 5, protowire.VarintType)
+	obj = protowire.AppendVarint(obj, uint64(sourceType))
+
+	return append([]byte("k8s:enc:kms:v2:"+providerName+":"), obj...)
+}
+
+// seedTestVector is a value encrypted in extended nonce mode the way the
+// kube-apiserver does it: the key is HKDF-Expand(SHA-256, seed, info), without
+// the extract step, and the payload is info, nonce and the GCM ciphertext of
+// {"kind":"Secret","apiVersion":"v1"} authenticated with
+// /registry/secrets/default/test. The seed is the bytes 0x00 to 0x1f, info
+// 0x20 to 0x3f and the nonce 0x40 to 0x4b.
+var seedTestVector = struct {
+	seed, payload string
+}{
+	seed: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+	payload: "202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f" +
+		"404142434445464748494a4b" +
+		"7c2240c8c16f5c538cd8e3ce00135f8616a49dc2c0c5ee76b415c1e6aa70338d8340b47e9e9d40b221c93306cc4af7a95f47e0",
+}
+
+func TestNewKMSv2Decryptor(t *testing.T) {
+	if _, err := NewKMSv2Decryptor("vault", "tcp://127.0.0.1:9000", 0); err == nil {
+		t.Errorf("NewKMSv2Decryptor() expected error for non-unix endpoint")
+	}
+
+	d, err := NewKMSv2Decryptor("vault", "unix:///nonexistent/kms.sock", 0)
+	if err != nil {
+		t.Fatalf("NewKMSv2Decryptor() unexpected error: %v", err)
+	}
+	defer d.Close()
+
+	if d.timeout != defaultKMSTimeout {
+		t.Errorf("timeout = %v, want default %v", d.timeout, defaultKMSTimeout)
+	}
+}
+
+func TestKMSv2Decrypt(t *testing.T) {
+	plugin, endpoint := startFakeKMSv2Plugin(t)
+
+	d, err := NewKMSv2Decryptor("vault", endpoint, 0)
+	if err != nil {
+		t.Fatalf("NewKMSv2Decryptor() error: %v", err)
+	}
+	defer d.Close()
+
+	etcdKey := "/registry/secrets/default/test"
+	plaintext := []byte(`{"kind":"Secret","apiVersion":"v1"}`)
+
+	dek := make([]byte, 32)
+	rand.Read(dek)
+	seed, _ := hex.DecodeString(seedTestVector.seed)
+	seedPayload, _ := hex.DecodeString(seedTestVector.payload)
+
+	tests := []struct {
+		name       string
+		data       []byte
+		etcdKey    string
+		wantErr    string
+		wantCalls  int32
+		sourceType int32
+	}{
+		{
+			name:      "AES-GCM DEK",
+			data:      encryptKMSv2TestData(t, plugin, "vault", dek, etcdKey, plaintext),
+			etcdKey:   etcdKey,
+			wantCalls: 1,
+		},
+		{
+			name:      "Same DEK is served from cache",
+			data:      encryptKMSv2TestData(t, plugin, "vault", dek, etcdKey, plaintext),
+			etcdKey:   etcdKey,
+			wantCalls: 1,
+		},
+		{
+			name:      "HKDF seed with extended nonce",
+			data:      kmsv2TestEnvelope(plugin, "vault", seed, dekSourceHKDFSeed, seedPayload),
+			etcdKey:   etcdKey,
+			wantCalls: 2,
+		},
+		{
+			name:      "Value moved to another etcd key",
+			data:      encryptKMSv2TestData(t, plugin, "vault", dek, etcdKey, plaintext),
+			etcdKey:   "/registry/secrets/default/other",
+			wantErr:   "failed to decrypt",
+			wantCalls: 2,
+		},
+		{
+			name:      "Other provider name",
+			data:      encryptKMSv2TestData(t, plugin, "aws", dek, etcdKey, plaintext),
+			etcdKey:   etcdKey,
+			wantErr:   "expected encryption prefix",
+			wantCalls: 2,
+		},
+		{
+			name:      "Malformed envelope",
+			data:      []byte("k8s:enc:kms:v2:vault:\xff\xff"),
+			etcdKey:   etcdKey,
+			wantErr:   "invalid EncryptedObject",
+			wantCalls: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.DecryptValue(tt.etcdKey, tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("DecryptValue() error = %v, want error containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("DecryptValue() unexpected error: %v", err)
+			} else if string(got) != string(plaintext) {
+				t.Errorf("DecryptValue() = %q, want %q", got, plaintext)
+			}
+
+			if calls := plugin.decrypts.Load(); calls != tt.wantCalls {
+				t.Errorf("plugin Decrypt calls = %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestKMSv2FromEncryptionConfig(t *testing.T) {
+	plugin, endpoint := startFakeKMSv2Plugin(t)
+
+	config, err := ParseEncryptionConfig([]byte(`apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+  - resources: [secrets]
+    providers:
+      - kms:
+          apiVersion: v2
+          name: vault
+          endpoint: ` + endpoint + `
+          timeout: 1s
+      - identity: {}
+`))
+	if err != nil {
+		t.Fatalf("ParseEncryptionConfig() error: %v", err)
+	}
+
+	chain, err := config.DecryptorFor("secrets")
+	if err != nil {
+		t.Fatalf("DecryptorFor() error: %v", err)
+	}
+	defer chain.Close()
+
+	dek := make([]byte, 32)
+	rand.Read(dek)
+	etcdKey := "/registry/secrets/kube-system/token"
+	value := encryptKMSv2TestData(t, plugin, "vault", dek, etcdKey, []byte("plaintext"))
+
+	got, err := chain.DecryptValue(etcdKey, value)
+	if err != nil {
+		t.Fatalf("DecryptValue() error: %v", err)
+	}
+	if string(got) != "plaintext" {
+		t.Errorf("DecryptValue() = %q, want %q", got, "plaintext")
+	}
+}
@@ -0,0 +1,69 @@
+package decrypt
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// secretboxPrefix is written by the kube-apiserver secretbox provider
+const secretboxPrefix = "k8s:enc:secretbox:v1:"
+
+// secretboxNonceSize is the length of the random nonce in front of each value
+const secretboxNonceSize = 24
+
+// SecretboxDecryptor handles decryption of XSalsa20-Poly1305 encrypted data from etcd
+type SecretboxDecryptor struct {
+	key     [32]byte
+	keyName string
+}
+
+// NewSecretboxDecryptor creates a new secretbox decryptor with the given 32-byte key
+func NewSecretboxDecryptor(key []byte, keyName string) (*SecretboxDecryptor, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secretbox requires a 32-byte key, got %d bytes", len(key))
+	}
+
+	d := &SecretboxDecryptor{keyName: keyName}
+	copy(d.key[:], key)
+	return d, nil
+}
+
+// Decrypt decrypts data that was encrypted by Kubernetes API server
+// Expected format: k8s:enc:secretbox:v1:<keyName>:<nonce><sealed-box>
+func (d *SecretboxDecryptor) Decrypt(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(secretboxPrefix)) && bytes.HasPrefix(data, []byte("{")) {
+		// Looks like JSON, might be unencrypted
+		return data, nil
+	}
+
+	keyName, encryptedPayload, err := splitPayload(data, secretboxPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.keyName != "" && keyName != d.keyName {
+		return nil, fmt.Errorf("key name mismatch: expected %s, got %s", d.keyName, keyName)
+	}
+
+	// First 24 bytes are the nonce, rest is the sealed box
+	if len(encryptedPayload) < secretboxNonceSize+secretbox.Overhead {
+		return nil, fmt.Errorf("ciphertext too short (must be at least %d bytes)", secretboxNonceSize+secretbox.Overhead)
+	}
+
+	var nonce [secretboxNonceSize]byte
+	copy(nonce[:], encryptedPayload[:secretboxNonceSize])
+
+	plaintext, ok := secretbox.Open(nil, encryptedPayload[secretboxNonceSize:], &nonce, &d.key)
+	if !ok {
+		return nil, fmt.Errorf("failed to decrypt: message authentication failed")
+	}
+
+	return plaintext, nil
+}
+
+// DecryptValue decrypts a value; secretbox does not authenticate the etcd key
+func (d *SecretboxDecryptor) DecryptValue(etcdKey string, data []byte) ([]byte, error) {
+	return d.Decrypt(data)
+}
@@ -0,0 +1,156 @@
+package decrypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// encryptSecretboxTestData encrypts plaintext the way the kube-apiserver
+// secretbox provider does: a random 24-byte nonce followed by the sealed box
+func encryptSecretboxTestData(key []byte, plaintext []byte) ([]byte, error) {
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+
+	var k [32]byte
+	copy(k[:], key)
+
+	return secretbox.Seal(nonce[:], plaintext, &nonce, &k), nil
+}
+
+func TestNewSecretboxDecryptor(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       []byte
+		wantError bool
+	}{
+		{name: "Valid 32-byte key", key: make([]byte, 32)},
+		{name: "Invalid key length - too short", key: make([]byte, 16), wantError: true},
+		{name: "Invalid key length - too long", key: make([]byte, 64), wantError: true},
+		{name: "Empty key", key: []byte{}, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decryptor, err := NewSecretboxDecryptor(tt.key, "key1")
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("NewSecretboxDecryptor() expected error, got nil")
+				}
+				if decryptor != nil {
+					t.Errorf("NewSecretboxDecryptor() expected nil decryptor on error")
+				}
+			} else if err != nil {
+				t.Errorf("NewSecretboxDecryptor() unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSecretboxDecrypt(t *testing.T) {
+	testKey := make([]byte, 32)
+	if _, err := rand.Read(testKey); err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	plaintext := []byte(`{"kind":"Secret","apiVersion":"v1","metadata":{"name":"test"}}`)
+	encrypted, err := encryptSecretboxTestData(testKey, plaintext)
+	if err != nil {
+		t.Fatalf("Failed to encrypt test data: %v", err)
+	}
+	fullEncrypted := append([]byte("k8s:enc:secretbox:v1:key1:"), encrypted...)
+
+	tampered := bytes.Clone(fullEncrypted)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		name      string
+		key       []byte
+		keyName   string
+		data      []byte
+		want      []byte
+		wantError bool
+	}{
+		{name: "Successful decryption", key: testKey, keyName: "key1", data: fullEncrypted, want: plaintext},
+		{name: "Wrong key", key: make([]byte, 32), keyName: "key1", data: fullEncrypted, wantError: true},
+		{name: "Wrong key name", key: testKey, keyName: "key2", data: fullEncrypted, wantError: true},
+		{name: "Tampered ciphertext", key: testKey, keyName: "key1", data: tampered, wantError: true},
+		{name: "Plaintext JSON (identity provider)", key: testKey, keyName: "key1", data: []byte(`{"kind":"Secret"}`), want: []byte(`{"kind":"Secret"}`)},
+		{name: "aescbc prefix", key: testKey, keyName: "key1", data: []byte("k8s:enc:aescbc:v1:key1:data"), wantError: true},
+		{name: "Truncated encrypted data", key: testKey, keyName: "key1", data: []byte("k8s:enc:secretbox:v1:key1:short"), wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decryptor, err := NewSecretboxDecryptor(tt.key, tt.keyName)
+			if err != nil {
+				t.Fatalf("NewSecretboxDecryptor() unexpected error: %v", err)
+			}
+
+			got, err := decryptor.Decrypt(tt.data)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("Decrypt() expected error, got nil")
+				}
+			} else {
+				if err != nil {
+					t.Errorf("Decrypt() unexpected error: %v", err)
+				}
+				if !bytes.Equal(got, tt.want) {
+					t.Errorf("Decrypt() = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// Test round-trip encryption/decryption
+func TestSecretboxRoundTrip(t *testing.T) {
+	testKey := make([]byte, 32)
+	_, err := rand.Read(testKey)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+
+	testCases := [][]byte{
+		[]byte(""),                     // Empty
+		[]byte("a"),                    // Single character
+		[]byte("Hello, World!"),        // Simple text
+		bytes.Repeat([]byte("x"), 24),  // Same length as the nonce
+		bytes.Repeat([]byte("z"), 100), // Longer than one Salsa20 block
+		[]byte(`{"kind":"Secret","apiVersion":"v1","metadata":{"name":"mysecret","namespace":"default"},"type":"Opaque","data":{"username":"YWRtaW4=","password":"MWYyZDFlMmU2N2Rm"}}`),
+	}
+
+	decryptor, err := NewSecretboxDecryptor(testKey, "key1")
+	if err != nil {
+		t.Fatalf("NewSecretboxDecryptor() error: %v", err)
+	}
+
+	for i, plaintext := range testCases {
+		t.Run(string(rune('a'+i)), func(t *testing.T) {
+			// Encrypt
+			encrypted, err := encryptSecretboxTestData(testKey, plaintext)
+			if err != nil {
+				t.Fatalf("encryptSecretboxTestData() error: %v", err)
+			}
+
+			// Add Kubernetes prefix
+			fullEncrypted := append([]byte("k8s:enc:secretbox:v1:key1:"), encrypted...)
+
+			// Decrypt
+			decrypted, err := decryptor.Decrypt(fullEncrypted)
+			if err != nil {
+				t.Fatalf("Decrypt() error: %v", err)
+			}
+
+			// Verify
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("Round-trip failed: got %q, want %q", decrypted, plaintext)
+			}
+		})
+	}
+}
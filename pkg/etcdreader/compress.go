@@ -0,0 +1,105 @@
+package etcdreader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// DefaultMaxDecompressedSize bounds the size of a decompressed snapshot by
+// default; it is the largest backend quota etcd recommends
+const DefaultMaxDecompressedSize int64 = 8 << 30
+
+// ErrDecompressedTooLarge is returned when a compressed or streamed snapshot
+// exceeds the size limit
+var ErrDecompressedTooLarge = errors.New("snapshot exceeds the size limit of temporary copies")
+
+// Options configures how a snapshot is opened
+type Options struct {
+	// MaxDecompressedSize bounds the size of the temporary copy of a
+	// snapshot: a compressed snapshot once decompressed, or a snapshot read
+	// from a stream; 0 means DefaultMaxDecompressedSize
+	MaxDecompressedSize int64
+	// TempDir is where compressed and streamed snapshots are copied; empty
+	// means the default directory for temporary files
+	TempDir string
+}
+
+// compression is a compressed file format recognized by its magic bytes
+type compression struct {
+	name  string
+	magic []byte
+	open  func(r io.Reader) (io.ReadCloser, error)
+}
+
+// compressions lists the formats NewReader decompresses; bbolt files start
+// with the zero id of their first page, so none of them can be mistaken for
+// a snapshot
+var compressions = []compression{
+	{
+		name:  "gzip",
+		magic: []byte{0x1f, 0x8b},
+		open: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	{
+		name:  "zstd",
+		magic: []byte{0x28, 0xb5, 0x2f, 0xfd},
+		open: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+	{
+		name:  "xz",
+		magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00},
+		open: func(r io.Reader) (io.ReadCloser, error) {
+			x, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return io.NopCloser(x), nil
+		},
+	},
+}
+
+// detectCompression returns the format whose magic bytes start header, or
+// nil for uncompressed files
+func detectCompression(header []byte) *compression {
+	for i := range compressions {
+		if bytes.HasPrefix(header, compressions[i].magic) {
+			return &compressions[i]
+		}
+	}
+	return nil
+}
+
+// decompress copies a compressed snapshot to a private temporary file and
+// returns its path; uncompressed snapshots return an empty path and are
+// opened in place
+func decompress(snapshotPath string, opts Options) (string, error) {
+	f, err := os.Open(snapshotPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	src := bufio.NewReader(f)
+	header, _ := src.Peek(6)
+	c := detectCompression(header)
+	if c == nil {
+		return "", nil
+	}
+	return spool(src, c, opts)
+}
@@ -0,0 +1,153 @@
+package etcdreader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compressors write a compressed copy of a file, by format
+var compressors = map[string]func(w io.Writer) (io.WriteCloser, error){
+	"gzip": func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+	"zstd": func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+	"xz":   func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) },
+}
+
+// compressFile writes a compressed copy of path next to it and returns its
+// path
+func compressFile(t *testing.T, path, format string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	var buf bytes.Buffer
+	w, err := compressors[format](&buf)
+	if err != nil {
+		t.Fatalf("Failed to create %s writer: %v", format, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Failed to compress snapshot: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to compress snapshot: %v", err)
+	}
+
+	compressed := path + "." + format
+	if err := os.WriteFile(compressed, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("Failed to write compressed snapshot: %v", err)
+	}
+	return compressed
+}
+
+// assertEmptyDir fails when dir holds any file
+func assertEmptyDir(t *testing.T, dir string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", dir, err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%s holds %d files, want none", dir, len(entries))
+	}
+}
+
+func TestNewReaderCompressed(t *testing.T) {
+	dbPath := createTestSnapshot(t, map[string][]byte{
+		"/registry/secrets/default/test": []byte("data"),
+	})
+
+	for _, format := range []string{"gzip", "zstd", "xz"} {
+		t.Run(format, func(t *testing.T) {
+			tempDir := t.TempDir()
+			reader, err := NewReaderWithOptions(compressFile(t, dbPath, format), Options{TempDir: tempDir})
+			if err != nil {
+				t.Fatalf("NewReaderWithOptions() error: %v", err)
+			}
+
+			kvs, err := reader.Range("/registry/secrets/default/test", "", 0)
+			if err != nil || len(kvs) != 1 || string(kvs[0].Value) != "data" {
+				t.Errorf("Range() = %v, %v, want the test secret", kvs, err)
+			}
+			// The copy is unlinked as soon as it is open
+			assertEmptyDir(t, tempDir)
+
+			if err := reader.Close(); err != nil {
+				t.Errorf("Close() error: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewReaderCompressedErrors(t *testing.T) {
+	dbPath := createTestSnapshot(t, map[string][]byte{
+		"/registry/secrets/default/test": []byte("data"),
+	})
+	compressed := compressFile(t, dbPath, "gzip")
+
+	data, err := os.ReadFile(compressed)
+	if err != nil {
+		t.Fatalf("Failed to read compressed snapshot: %v", err)
+	}
+	truncated := filepath.Join(t.TempDir(), "truncated.db.gz")
+	if err := os.WriteFile(truncated, data[:len(data)/2], 0600); err != nil {
+		t.Fatalf("Failed to write truncated snapshot: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		opts    Options
+		wantErr error
+	}{
+		{name: "Size limit", path: compressed, opts: Options{MaxDecompressedSize: 4096}, wantErr: ErrDecompressedTooLarge},
+		{name: "Truncated stream", path: truncated, wantErr: io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.TempDir = t.TempDir()
+			reader, err := NewReaderWithOptions(tt.path, tt.opts)
+			if err == nil {
+				reader.Close()
+				t.Fatal("NewReaderWithOptions() succeeded")
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewReaderWithOptions() error = %v, want %v", err, tt.wantErr)
+			}
+			assertEmptyDir(t, tt.opts.TempDir)
+		})
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	tests := []struct {
+		header []byte
+		want   string
+	}{
+		{header: []byte{0x1f, 0x8b, 0x08, 0x00}, want: "gzip"},
+		{header: []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, want: "zstd"},
+		{header: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, want: "xz"},
+		{header: make([]byte, 16), want: ""},
+		{header: []byte{0x1f}, want: ""},
+	}
+
+	for _, tt := range tests {
+		got := ""
+		if c := detectCompression(tt.header); c != nil {
+			got = c.name
+		}
+		if got != tt.want {
+			t.Errorf("detectCompression(%x) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package etcdreader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/server/v3/mvcc/buckets"
+)
+
+// DefaultLockTimeout is how long OpenDataDir waits for the database lock
+const DefaultLockTimeout = 2 * time.Second
+
+// ErrDataDirLocked is returned when the database of a data directory is
+// locked by a running etcd member
+var ErrDataDirLocked = errors.New("database is locked by another process, such as a running etcd")
+
+// DataDirOptions configure how the data directory of an etcd member is read
+type DataDirOptions struct {
+	Options
+	// LockTimeout is how long to wait for the lock etcd holds on the
+	// database; zero means DefaultLockTimeout
+	LockTimeout time.Duration
+	// Copy reads a copy of the database taken without the lock, so that the
+	// data directory of a running member can be read
+	Copy bool
+	// SkipWAL reads the database as it was last written, ignoring the
+	// entries of the WAL it does not have yet
+	SkipWAL bool
+}
+
+// OpenDataDir opens the database of an etcd data directory, such as
+// /var/lib/etcd, and replays the committed entries of its WAL that are not
+// in the database yet. dir may also be the member or member/snap directory.
+//
+// The database is opened in place when it has nothing to replay. Otherwise
+// it is copied to a temporary file first, as it is when Copy is set, so the
+// data directory itself is never modified.
+func OpenDataDir(dir string, opts DataDirOptions) (*Reader, error) {
+	dbPath, walDir, err := findDataDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if opts.SkipWAL {
+		walDir = ""
+	}
+	timeout := opts.LockTimeout
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+
+	var tempPath string
+	var log *walLog
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{ReadOnly: true, Timeout: timeout})
+	switch {
+	case errors.Is(err, bolt.ErrTimeout):
+		if !opts.Copy {
+			return nil, fmt.Errorf("%s: %w", dbPath, ErrDataDirLocked)
+		}
+		// etcd keeps writing the file while it is copied; bbolt checks the
+		// copy when it is opened, and Verify checks its pages
+		tempPath, err = copyFile(dbPath, opts.Options)
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	default:
+		if walDir != "" {
+			log, err = readWAL(walDir, consistentIndex(db))
+			if err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+		if !opts.Copy && (log == nil || len(log.entries) == 0) {
+			r := &Reader{db: db}
+			if log != nil {
+				r.replay = &Replay{FromIndex: log.after, ToIndex: log.after, Uncommitted: log.uncommitted}
+			}
+			return r, nil
+		}
+		tempPath, err = copyDB(db, opts.Options)
+		db.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var replay *Replay
+	if walDir != "" {
+		if replay, err = applyWAL(tempPath, walDir, log); err != nil {
+			removeTempFile(tempPath)
+			return nil, err
+		}
+	}
+	r, err := openTempCopy(tempPath)
+	if err != nil {
+		return nil, err
+	}
+	r.replay = replay
+	return r, nil
+}
+
+// Replay returns the WAL entries replayed over the database of a data
+// directory, or nil when no WAL was read
+func (r *Reader) Replay() *Replay {
+	return r.replay
+}
+
+// findDataDir returns the database and WAL directory of the data directory
+// dir; walDir is empty when there is no WAL
+func findDataDir(dir string) (dbPath, walDir string, err error) {
+	for _, snapDir := range []string{filepath.Join(dir, "member", "snap"), filepath.Join(dir, "snap"), dir} {
+		dbPath := filepath.Join(snapDir, "db")
+		if info, err := os.Stat(dbPath); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		walDir := filepath.Join(filepath.Dir(snapDir), "wal")
+		if info, err := os.Stat(walDir); err != nil || !info.IsDir() {
+			walDir = ""
+		}
+		return dbPath, walDir, nil
+	}
+	return "", "", fmt.Errorf("no etcd database in %s, expected %s: %w", dir, filepath.Join(dir, "member", "snap", "db"), fs.ErrNotExist)
+}
+
+// consistentIndex reads the index of the last raft entry applied to db
+func consistentIndex(db *bolt.DB) uint64 {
+	var index uint64
+	db.View(func(tx *bolt.Tx) error {
+		if meta := tx.Bucket(buckets.Meta.Name()); meta != nil {
+			index = readUint64(meta, buckets.MetaConsistentIndexKeyName)
+		}
+		return nil
+	})
+	return index
+}
+
+// copyFile copies the file at path to a private temporary file
+func copyFile(path string, opts Options) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+	return spool(f, nil, opts)
+}
+
+// copyDB copies a consistent view of db to a private temporary file
+func copyDB(db *bolt.DB, opts Options) (string, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(db.View(func(tx *bolt.Tx) error {
+			_, err := tx.WriteTo(pw)
+			return err
+		}))
+	}()
+
+	tempPath, err := spool(pr, nil, opts)
+	// Stop the copy when spool gives up early, such as at the size limit
+	pr.CloseWithError(io.ErrClosedPipe)
+	return tempPath, err
+}
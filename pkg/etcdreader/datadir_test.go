@@ -0,0 +1,295 @@
+package etcdreader
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+)
+
+// createDataDir lays out an etcd data directory with a database holding ops
+// and the given consistent index, and returns it with its WAL writer
+func createDataDir(t *testing.T, ops []mvccOp, index uint64) (string, *testWAL) {
+	t.Helper()
+
+	dir := t.TempDir()
+	snapDir := filepath.Join(dir, "member", "snap")
+	if err := os.MkdirAll(snapDir, 0700); err != nil {
+		t.Fatalf("Failed to create data directory: %v", err)
+	}
+	dbPath := createTestSnapshotWithOps(t, ops)
+	writeMeta(t, dbPath, map[string][]byte{"consistent_index": uint64Bytes(index), "term": uint64Bytes(1)})
+	if err := os.Rename(dbPath, filepath.Join(snapDir, "db")); err != nil {
+		t.Fatalf("Failed to move database: %v", err)
+	}
+
+	w := newTestWAL(t, filepath.Join(dir, "member", "wal"))
+	w.start()
+	return dir, w
+}
+
+// request appends a WAL entry holding req
+func (w *testWAL) request(t *testing.T, index uint64, req *etcdserverpb.InternalRaftRequest) {
+	t.Helper()
+
+	data, err := req.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	w.entry(index, 1, data)
+}
+
+func putOp(key, value string) *etcdserverpb.RequestOp {
+	return &etcdserverpb.RequestOp{Request: &etcdserverpb.RequestOp_RequestPut{
+		RequestPut: &etcdserverpb.PutRequest{Key: []byte(key), Value: []byte(value)},
+	}}
+}
+
+// modRevisionIs compares the mod revision of key, as the Kubernetes API
+// server does before each update
+func modRevisionIs(key string, rev int64) *etcdserverpb.Compare {
+	return &etcdserverpb.Compare{
+		Key:         []byte(key),
+		Target:      etcdserverpb.Compare_MOD,
+		Result:      etcdserverpb.Compare_EQUAL,
+		TargetUnion: &etcdserverpb.Compare_ModRevision{ModRevision: rev},
+	}
+}
+
+func TestOpenDataDir(t *testing.T) {
+	dir, w := createDataDir(t, []mvccOp{
+		{key: "/registry/secrets/default/a", value: []byte("a1")},
+		{key: "/registry/secrets/default/b", value: []byte("b1")},
+		{key: "/registry/events/default/e", value: []byte("e1")},
+	}, 10)
+
+	// Entries up to the consistent index are already in the database
+	w.request(t, 10, &etcdserverpb.InternalRaftRequest{Put: &etcdserverpb.PutRequest{Key: []byte("/registry/secrets/default/old"), Value: []byte("x")}})
+	// Revision 4: a is updated by the API server
+	w.request(t, 11, &etcdserverpb.InternalRaftRequest{Txn: &etcdserverpb.TxnRequest{
+		Compare: []*etcdserverpb.Compare{modRevisionIs("/registry/secrets/default/a", 1)},
+		Success: []*etcdserverpb.RequestOp{putOp("/registry/secrets/default/a", "a2")},
+	}})
+	// A conflicting update runs its failure branch, which only reads
+	w.request(t, 12, &etcdserverpb.InternalRaftRequest{Txn: &etcdserverpb.TxnRequest{
+		Compare: []*etcdserverpb.Compare{modRevisionIs("/registry/secrets/default/a", 1)},
+		Success: []*etcdserverpb.RequestOp{putOp("/registry/secrets/default/a", "stale")},
+	}})
+	// Revision 5: c is created, when it does not exist yet
+	w.request(t, 13, &etcdserverpb.InternalRaftRequest{Txn: &etcdserverpb.TxnRequest{
+		Compare: []*etcdserverpb.Compare{modRevisionIs("/registry/secrets/default/c", 0)},
+		Success: []*etcdserverpb.RequestOp{putOp("/registry/secrets/default/c", "c1")},
+	}})
+	// Revision 6: b is deleted
+	w.request(t, 14, &etcdserverpb.InternalRaftRequest{DeleteRange: &etcdserverpb.DeleteRangeRequest{Key: []byte("/registry/secrets/default/b")}})
+	// Revision 7: an event is written with a lease, revision 8 revokes it
+	w.request(t, 15, &etcdserverpb.InternalRaftRequest{Put: &etcdserverpb.PutRequest{Key: []byte("/registry/events/default/f"), Value: []byte("f1"), Lease: 42}})
+	w.request(t, 16, &etcdserverpb.InternalRaftRequest{LeaseRevoke: &etcdserverpb.LeaseRevokeRequest{ID: 42}})
+	// Entries without data are written by new leaders
+	w.entry(17, 1, nil)
+	w.commit(1, 17)
+	// Not committed yet, so not replayed
+	w.request(t, 18, &etcdserverpb.InternalRaftRequest{Put: &etcdserverpb.PutRequest{Key: []byte("/registry/secrets/default/d"), Value: []byte("d1")}})
+	w.cut(t, 0)
+
+	r, err := OpenDataDir(dir, DataDirOptions{})
+	if err != nil {
+		t.Fatalf("OpenDataDir() error: %v", err)
+	}
+	defer r.Close()
+
+	want := Replay{FromIndex: 10, ToIndex: 17, Entries: 7, Revisions: 5, Uncommitted: 1}
+	if got := r.Replay(); got == nil || *got != want {
+		t.Errorf("Replay() = %+v, want %+v", got, want)
+	}
+
+	secrets, err := r.ListSecrets()
+	if err != nil {
+		t.Fatalf("ListSecrets() error: %v", err)
+	}
+	if len(secrets) != 2 || secrets[0] != "/registry/secrets/default/a" || secrets[1] != "/registry/secrets/default/c" {
+		t.Errorf("ListSecrets() = %v, want a and c", secrets)
+	}
+	if v, err := r.Get("/registry/secrets/default/a"); err != nil || string(v) != "a2" {
+		t.Errorf("Get(a) = %q, %v, want a2", v, err)
+	}
+	if v, err := r.GetAtRevision("/registry/secrets/default/a", 3); err != nil || string(v) != "a1" {
+		t.Errorf("GetAtRevision(a, 3) = %q, %v, want a1", v, err)
+	}
+	if _, err := r.Get("/registry/events/default/f"); err == nil {
+		t.Error("Get(f) succeeded after its lease was revoked")
+	}
+	if v, err := r.GetAtRevision("/registry/events/default/f", 7); err != nil || string(v) != "f1" {
+		t.Errorf("GetAtRevision(f, 7) = %q, %v, want f1", v, err)
+	}
+
+	md, err := r.Metadata()
+	if err != nil {
+		t.Fatalf("Metadata() error: %v", err)
+	}
+	if md.ConsistentIndex != 17 || md.CurrentRevision != 8 {
+		t.Errorf("Metadata() = index %d, revision %d, want 17 and 8", md.ConsistentIndex, md.CurrentRevision)
+	}
+
+	// The data directory itself is left as it was
+	db, err := bolt.Open(filepath.Join(dir, "member", "snap", "db"), 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	if index := consistentIndex(db); index != 10 {
+		t.Errorf("consistent index of the data directory = %d, want 10", index)
+	}
+}
+
+func TestOpenDataDirAfterCompaction(t *testing.T) {
+	dir, w := createDataDir(t, []mvccOp{
+		{key: "/registry/secrets/default/a", value: []byte("a1")},
+		{key: "/registry/secrets/default/b", value: []byte("b1")},
+	}, 10)
+	// Compacting at revision 5 removed the tombstones of revisions 3 to 5
+	writeMeta(t, filepath.Join(dir, "member", "snap", "db"), map[string][]byte{"finishedCompactRev": revisionBytes(5)})
+
+	w.request(t, 11, &etcdserverpb.InternalRaftRequest{Put: &etcdserverpb.PutRequest{Key: []byte("/registry/secrets/default/c"), Value: []byte("c1")}})
+	w.commit(1, 11)
+	w.cut(t, 0)
+
+	r, err := OpenDataDir(dir, DataDirOptions{})
+	if err != nil {
+		t.Fatalf("OpenDataDir() error: %v", err)
+	}
+	defer r.Close()
+
+	if v, err := r.GetAtRevision("/registry/secrets/default/c", 6); err != nil || string(v) != "c1" {
+		t.Errorf("GetAtRevision(c, 6) = %q, %v, want c1 after the compacted revision", v, err)
+	}
+	md, err := r.Metadata()
+	if err != nil {
+		t.Fatalf("Metadata() error: %v", err)
+	}
+	if md.CurrentRevision != 6 || md.CompactRevision != 5 {
+		t.Errorf("Metadata() = revision %d, compacted at %d, want 6 and 5", md.CurrentRevision, md.CompactRevision)
+	}
+	if replay := r.Replay(); replay == nil || replay.Revisions != 1 {
+		t.Errorf("Replay() = %+v, want 1 revision", replay)
+	}
+}
+
+func TestOpenDataDirTxn(t *testing.T) {
+	dir, w := createDataDir(t, []mvccOp{{key: "/registry/secrets/default/a", value: []byte("a1")}}, 10)
+
+	// etcd rejects the whole transaction, as c does not exist to keep its value
+	w.request(t, 11, &etcdserverpb.InternalRaftRequest{Txn: &etcdserverpb.TxnRequest{
+		Success: []*etcdserverpb.RequestOp{
+			putOp("/registry/secrets/default/b", "b1"),
+			{Request: &etcdserverpb.RequestOp_RequestPut{RequestPut: &etcdserverpb.PutRequest{Key: []byte("/registry/secrets/default/c"), IgnoreValue: true}}},
+		},
+	}})
+	// Revision 2: nested comparisons see a as it was before the transaction
+	w.request(t, 12, &etcdserverpb.InternalRaftRequest{Txn: &etcdserverpb.TxnRequest{
+		Success: []*etcdserverpb.RequestOp{
+			putOp("/registry/secrets/default/a", "a2"),
+			{Request: &etcdserverpb.RequestOp_RequestTxn{RequestTxn: &etcdserverpb.TxnRequest{
+				Compare: []*etcdserverpb.Compare{modRevisionIs("/registry/secrets/default/a", 1)},
+				Success: []*etcdserverpb.RequestOp{putOp("/registry/secrets/default/d", "d1")},
+				Failure: []*etcdserverpb.RequestOp{putOp("/registry/secrets/default/d", "stale")},
+			}}},
+		},
+	}})
+	w.commit(1, 12)
+	w.cut(t, 0)
+
+	r, err := OpenDataDir(dir, DataDirOptions{})
+	if err != nil {
+		t.Fatalf("OpenDataDir() error: %v", err)
+	}
+	defer r.Close()
+
+	if replay := r.Replay(); replay == nil || replay.Entries != 2 || replay.Revisions != 1 {
+		t.Errorf("Replay() = %+v, want 2 entries and 1 revision", replay)
+	}
+	if _, err := r.Get("/registry/secrets/default/b"); err == nil {
+		t.Error("Get(b) succeeded, but its transaction was rejected")
+	}
+	if v, err := r.Get("/registry/secrets/default/a"); err != nil || string(v) != "a2" {
+		t.Errorf("Get(a) = %q, %v, want a2", v, err)
+	}
+	if v, err := r.GetAtRevision("/registry/secrets/default/d", 2); err != nil || string(v) != "d1" {
+		t.Errorf("GetAtRevision(d, 2) = %q, %v, want d1", v, err)
+	}
+}
+
+func TestOpenDataDirInPlace(t *testing.T) {
+	dir, w := createDataDir(t, []mvccOp{{key: "/registry/secrets/default/a", value: []byte("a1")}}, 10)
+	w.request(t, 10, &etcdserverpb.InternalRaftRequest{Put: &etcdserverpb.PutRequest{Key: []byte("/registry/secrets/default/a"), Value: []byte("a1")}})
+	w.commit(1, 10)
+	w.cut(t, 0)
+	dbPath := filepath.Join(dir, "member", "snap", "db")
+
+	for _, path := range []string{dir, filepath.Join(dir, "member"), filepath.Join(dir, "member", "snap")} {
+		r, err := OpenDataDir(path, DataDirOptions{})
+		if err != nil {
+			t.Fatalf("OpenDataDir(%s) error: %v", path, err)
+		}
+		// Nothing to replay, so the database is read where it is
+		if r.db.Path() != dbPath {
+			t.Errorf("OpenDataDir(%s) read %s, want %s", path, r.db.Path(), dbPath)
+		}
+		if replay := r.Replay(); replay == nil || replay.Entries != 0 || replay.ToIndex != 10 {
+			t.Errorf("OpenDataDir(%s) Replay() = %+v, want nothing replayed after index 10", path, replay)
+		}
+		r.Close()
+	}
+
+	r, err := OpenDataDir(dir, DataDirOptions{SkipWAL: true})
+	if err != nil {
+		t.Fatalf("OpenDataDir() error: %v", err)
+	}
+	if r.Replay() != nil {
+		t.Errorf("Replay() = %+v with SkipWAL, want nil", r.Replay())
+	}
+	r.Close()
+
+	if _, err := OpenDataDir(t.TempDir(), DataDirOptions{}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenDataDir() of a directory without database error = %v, want not exist", err)
+	}
+}
+
+func TestOpenDataDirLocked(t *testing.T) {
+	dir, w := createDataDir(t, []mvccOp{{key: "/registry/secrets/default/a", value: []byte("a1")}}, 10)
+	w.request(t, 11, &etcdserverpb.InternalRaftRequest{Put: &etcdserverpb.PutRequest{Key: []byte("/registry/secrets/default/b"), Value: []byte("b1")}})
+	w.commit(1, 11)
+	w.cut(t, 0)
+
+	// A running etcd holds an exclusive lock on its database
+	db, err := bolt.Open(filepath.Join(dir, "member", "snap", "db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	start := time.Now()
+	_, err = OpenDataDir(dir, DataDirOptions{LockTimeout: 100 * time.Millisecond})
+	if !errors.Is(err, ErrDataDirLocked) {
+		t.Fatalf("OpenDataDir() error = %v, want %v", err, ErrDataDirLocked)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("OpenDataDir() took %v to give up", elapsed)
+	}
+
+	tempDir := t.TempDir()
+	r, err := OpenDataDir(dir, DataDirOptions{Copy: true, LockTimeout: 100 * time.Millisecond, Options: Options{TempDir: tempDir}})
+	if err != nil {
+		t.Fatalf("OpenDataDir() with Copy error: %v", err)
+	}
+	defer r.Close()
+	if v, err := r.Get("/registry/secrets/default/b"); err != nil || string(v) != "b1" {
+		t.Errorf("Get(b) = %q, %v, want the replayed b1", v, err)
+	}
+	assertEmptyDir(t, tempDir)
+}
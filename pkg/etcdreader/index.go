@@ -0,0 +1,191 @@
+package etcdreader
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/server/v3/mvcc/buckets"
+)
+
+var (
+	// ErrCompacted is returned when a read asks for a revision that was
+	// removed by compaction before the snapshot was taken
+	ErrCompacted = errors.New("required revision has been compacted")
+	// ErrFutureRevision is returned when a read asks for a revision newer
+	// than anything in the snapshot
+	ErrFutureRevision = errors.New("required revision is a future revision")
+)
+
+// indexEntry points at a single MVCC record of a key
+type indexEntry struct {
+	rev       revision
+	revBytes  []byte
+	tombstone bool
+}
+
+// keyIndex maps every key in the snapshot to its surviving MVCC records
+// It is built with a single pass over the key bucket so lookups no longer
+// need to scan and unmarshal every entry
+type keyIndex struct {
+	// revisions holds the records of each key, oldest first
+	revisions map[string][]indexEntry
+	// keys holds every key that has at least one record, sorted
+	keys []string
+	// live holds the keys whose newest record is not a tombstone, sorted
+	live []string
+
+	currentRev int64
+	compactRev int64
+}
+
+// buildIndex scans the key bucket once and records every revision of each key
+func buildIndex(db *bolt.DB) (*keyIndex, error) {
+	idx := &keyIndex{revisions: make(map[string][]indexEntry)}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(buckets.Key.Name())
+		if bucket == nil {
+			return fmt.Errorf("key bucket not found in snapshot - this may not be a valid etcd v3 snapshot")
+		}
+
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if len(k) != revBytesLen && len(k) != markedRevBytesLen {
+				continue // Not an MVCC revision key
+			}
+
+			var kv mvccpb.KeyValue
+			if err := kv.Unmarshal(v); err != nil {
+				continue // Skip malformed entries
+			}
+
+			rev := bytesToRev(k)
+			if rev.main > idx.currentRev {
+				idx.currentRev = rev.main
+			}
+
+			revBytes := make([]byte, len(k))
+			copy(revBytes, k)
+			key := string(kv.Key)
+			idx.revisions[key] = append(idx.revisions[key], indexEntry{
+				rev:       rev,
+				revBytes:  revBytes,
+				tombstone: isTombstone(k),
+			})
+		}
+
+		if meta := tx.Bucket(buckets.Meta.Name()); meta != nil {
+			idx.compactRev = readRevision(meta, finishedCompactKeyName)
+		}
+		// Compaction drops tombstones, so the newest record may be older
+		// than the compacted revision, which always stays readable
+		if idx.compactRev > idx.currentRev {
+			idx.currentRev = idx.compactRev
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for key, entries := range idx.revisions {
+		// The bucket is ordered by revision, but sort anyway so the index
+		// stays correct for hand-built or unusual snapshots
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[j].rev.greaterThan(entries[i].rev)
+		})
+
+		idx.keys = append(idx.keys, key)
+		if !entries[len(entries)-1].tombstone {
+			idx.live = append(idx.live, key)
+		}
+	}
+	sort.Strings(idx.keys)
+	sort.Strings(idx.live)
+
+	return idx, nil
+}
+
+// latest returns the newest record of key
+func (idx *keyIndex) latest(key string) (indexEntry, bool) {
+	entries := idx.revisions[key]
+	if len(entries) == 0 {
+		return indexEntry{}, false
+	}
+	return entries[len(entries)-1], true
+}
+
+// at returns the newest record of key whose main revision is at or before rev
+func (idx *keyIndex) at(key string, rev int64) (indexEntry, bool) {
+	entries := idx.revisions[key]
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].rev.main > rev
+	})
+	if i == 0 {
+		return indexEntry{}, false
+	}
+	return entries[i-1], true
+}
+
+// checkRevision verifies that the snapshot can still answer reads at rev
+func (idx *keyIndex) checkRevision(rev int64) error {
+	if rev <= 0 {
+		return fmt.Errorf("invalid revision %d: must be positive", rev)
+	}
+	if rev < idx.compactRev {
+		return fmt.Errorf("%w: revision %d is older than compacted revision %d", ErrCompacted, rev, idx.compactRev)
+	}
+	if rev > idx.currentRev {
+		return fmt.Errorf("%w: revision %d is newer than current revision %d", ErrFutureRevision, rev, idx.currentRev)
+	}
+	return nil
+}
+
+// withPrefix returns the live keys starting with prefix, in sorted order
+func (idx *keyIndex) withPrefix(prefix string) []string {
+	return prefixRange(idx.live, prefix)
+}
+
+// keyRange returns the keys, live or deleted, in [start, end) with etcd range
+// semantics: an empty end selects start alone and "\x00" has no upper bound
+func (idx *keyIndex) keyRange(start, end string) []string {
+	i := sort.SearchStrings(idx.keys, start)
+	if end == "" {
+		if i < len(idx.keys) && idx.keys[i] == start {
+			return idx.keys[i : i+1]
+		}
+		return nil
+	}
+
+	j := len(idx.keys)
+	if end != "\x00" {
+		j = sort.SearchStrings(idx.keys, end)
+	}
+	if j < i {
+		return nil
+	}
+	return idx.keys[i:j]
+}
+
+// prefixRange returns the elements of the sorted slice that start with prefix
+func prefixRange(sorted []string, prefix string) []string {
+	start := sort.SearchStrings(sorted, prefix)
+	end := start
+	for end < len(sorted) && strings.HasPrefix(sorted[end], prefix) {
+		end++
+	}
+	return sorted[start:end]
+}
+
+// greaterThan reports whether r is a later revision than other
+func (r revision) greaterThan(other revision) bool {
+	if r.main != other.main {
+		return r.main > other.main
+	}
+	return r.sub > other.sub
+}
@@ -0,0 +1,100 @@
+package etcdreader
+
+import (
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBuildIndex(t *testing.T) {
+	dbPath := createTestSnapshotWithOps(t, []mvccOp{
+		{key: "/registry/secrets/default/a", value: []byte("a1")},
+		{key: "/registry/secrets/kube-system/b", value: []byte("b1")},
+		{key: "/registry/configmaps/default/c", value: []byte("c1")},
+		{key: "/registry/secrets/default/a", value: []byte("a2")},
+		{key: "/registry/secrets/kube-system/b", delete: true},
+	})
+
+	db, err := bolt.Open(dbPath, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("bolt.Open() error: %v", err)
+	}
+	defer db.Close()
+
+	idx, err := buildIndex(db)
+	if err != nil {
+		t.Fatalf("buildIndex() error: %v", err)
+	}
+
+	if got, _ := idx.latest("/registry/secrets/default/a"); got.rev.main != 4 {
+		t.Errorf("newest revision of a = %d, want 4", got.rev.main)
+	}
+	if got := len(idx.revisions["/registry/secrets/default/a"]); got != 2 {
+		t.Errorf("a has %d records, want 2", got)
+	}
+	if got, _ := idx.latest("/registry/secrets/kube-system/b"); !got.tombstone {
+		t.Errorf("b should be recorded as a tombstone")
+	}
+	if idx.currentRev != 5 {
+		t.Errorf("currentRev = %d, want 5", idx.currentRev)
+	}
+
+	wantLive := []string{"/registry/configmaps/default/c", "/registry/secrets/default/a"}
+	if !reflect.DeepEqual(idx.live, wantLive) {
+		t.Errorf("live keys = %v, want %v", idx.live, wantLive)
+	}
+}
+
+func TestKeyIndexWithPrefix(t *testing.T) {
+	idx := &keyIndex{live: []string{
+		"/registry/configmaps/default/c",
+		"/registry/secrets/default/a",
+		"/registry/secrets/default/b",
+		"/registry/services/default/d",
+	}}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "/registry/secrets/", want: []string{"/registry/secrets/default/a", "/registry/secrets/default/b"}},
+		{prefix: "/registry/", want: idx.live},
+		{prefix: "/registry/pods/", want: []string{}},
+		{prefix: "/zzz", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			got := idx.withPrefix(tt.prefix)
+			if len(got) != len(tt.want) {
+				t.Fatalf("withPrefix(%q) = %v, want %v", tt.prefix, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("withPrefix(%q)[%d] = %s, want %s", tt.prefix, i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBuildIndexMissingBucket(t *testing.T) {
+	dbPath := createTestSnapshotWithOps(t, nil)
+
+	db, err := bolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open() error: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("key"))
+	}); err != nil {
+		t.Fatalf("DeleteBucket() error: %v", err)
+	}
+
+	if _, err := buildIndex(db); err == nil {
+		t.Errorf("buildIndex() expected error for snapshot without key bucket")
+	}
+}
@@ -0,0 +1,82 @@
+package etcdreader
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/server/v3/mvcc/buckets"
+)
+
+// Keys stored in the etcd meta bucket
+var (
+	// finishedCompactKeyName holds the last completed compaction revision
+	finishedCompactKeyName = []byte("finishedCompactRev")
+	// scheduledCompactKeyName holds the revision of the last requested compaction
+	scheduledCompactKeyName = []byte("scheduledCompactRev")
+	// storageVersionKeyName holds the storage schema version (etcd 3.6+)
+	storageVersionKeyName = []byte("storageVersion")
+)
+
+// Metadata describes the state of the etcd member the snapshot was taken from
+type Metadata struct {
+	// ConsistentIndex is the index of the last raft entry applied to the backend
+	ConsistentIndex uint64 `json:"consistentIndex"`
+	// Term is the raft term of the last applied entry
+	Term uint64 `json:"term"`
+	// CompactRevision is the revision of the last finished compaction
+	CompactRevision int64 `json:"compactRevision"`
+	// ScheduledCompactRevision is the revision of the last scheduled compaction;
+	// it is ahead of CompactRevision when a compaction was interrupted
+	ScheduledCompactRevision int64 `json:"scheduledCompactRevision"`
+	// CurrentRevision is the newest MVCC revision in the snapshot
+	CurrentRevision int64 `json:"currentRevision"`
+	// StorageVersion is only recorded by etcd 3.6 and later
+	StorageVersion string `json:"storageVersion,omitempty"`
+}
+
+// Metadata decodes the meta bucket of the snapshot
+func (r *Reader) Metadata() (*Metadata, error) {
+	idx, err := r.keyIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	md := &Metadata{CurrentRevision: idx.currentRev}
+	err = r.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(buckets.Meta.Name())
+		if meta == nil {
+			return fmt.Errorf("meta bucket not found in snapshot")
+		}
+
+		md.ConsistentIndex = readUint64(meta, buckets.MetaConsistentIndexKeyName)
+		md.Term = readUint64(meta, buckets.MetaTermKeyName)
+		md.CompactRevision = readRevision(meta, finishedCompactKeyName)
+		md.ScheduledCompactRevision = readRevision(meta, scheduledCompactKeyName)
+		md.StorageVersion = string(meta.Get(storageVersionKeyName))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return md, nil
+}
+
+// readUint64 decodes a big-endian uint64 meta value, returning 0 when absent
+func readUint64(meta *bolt.Bucket, key []byte) uint64 {
+	v := meta.Get(key)
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+// readRevision decodes a revision-encoded meta value, returning 0 when absent
+func readRevision(meta *bolt.Bucket, key []byte) int64 {
+	v := meta.Get(key)
+	if len(v) < revBytesLen {
+		return 0
+	}
+	return bytesToRev(v).main
+}
@@ -0,0 +1,135 @@
+package etcdreader
+
+import (
+	"encoding/binary"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/server/v3/mvcc/buckets"
+)
+
+// writeMeta stores raw values in the snapshot's meta bucket
+func writeMeta(t *testing.T, dbPath string, values map[string][]byte) {
+	t.Helper()
+
+	db, err := bolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(buckets.Meta.Name())
+		if err != nil {
+			return err
+		}
+		for k, v := range values {
+			if err := bucket.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to write meta bucket: %v", err)
+	}
+}
+
+func uint64Bytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func revisionBytes(main int64) []byte {
+	b := make([]byte, revBytesLen)
+	binary.BigEndian.PutUint64(b[0:8], uint64(main))
+	b[8] = '_'
+	return b
+}
+
+func TestReaderMetadata(t *testing.T) {
+	dbPath := createTestSnapshotWithOps(t, []mvccOp{
+		{key: "/registry/secrets/default/a", value: []byte("a")},
+		{key: "/registry/secrets/default/b", value: []byte("b")},
+		{key: "/registry/secrets/default/a", value: []byte("a2")},
+	})
+	writeMeta(t, dbPath, map[string][]byte{
+		"consistent_index":    uint64Bytes(4242),
+		"term":                uint64Bytes(7),
+		"finishedCompactRev":  revisionBytes(2),
+		"scheduledCompactRev": revisionBytes(3),
+		"storageVersion":      []byte("3.6.0"),
+	})
+
+	reader, err := NewReader(dbPath)
+	if err != nil {
+		t.Fatalf("NewReader() error: %v", err)
+	}
+	defer reader.Close()
+
+	md, err := reader.Metadata()
+	if err != nil {
+		t.Fatalf("Metadata() error: %v", err)
+	}
+
+	want := Metadata{
+		ConsistentIndex:          4242,
+		Term:                     7,
+		CompactRevision:          2,
+		ScheduledCompactRevision: 3,
+		CurrentRevision:          3,
+		StorageVersion:           "3.6.0",
+	}
+	if *md != want {
+		t.Errorf("Metadata() = %+v, want %+v", *md, want)
+	}
+}
+
+func TestReaderMetadataCompactedTombstones(t *testing.T) {
+	// Compaction removed the tombstone at revision 5, leaving the
+	// compacted revision ahead of every remaining record
+	dbPath := createTestSnapshotWithOps(t, []mvccOp{
+		{key: "/registry/secrets/default/a", value: []byte("a")},
+	})
+	writeMeta(t, dbPath, map[string][]byte{
+		"finishedCompactRev": revisionBytes(5),
+	})
+
+	reader, err := NewReader(dbPath)
+	if err != nil {
+		t.Fatalf("NewReader() error: %v", err)
+	}
+	defer reader.Close()
+
+	md, err := reader.Metadata()
+	if err != nil {
+		t.Fatalf("Metadata() error: %v", err)
+	}
+	if md.CurrentRevision != 5 {
+		t.Errorf("CurrentRevision = %d, want 5", md.CurrentRevision)
+	}
+	if md.StorageVersion != "" {
+		t.Errorf("StorageVersion = %q, want empty", md.StorageVersion)
+	}
+
+	if _, err := reader.GetAtRevision("/registry/secrets/default/a", 5); err != nil {
+		t.Errorf("GetAtRevision() at compacted revision error: %v", err)
+	}
+}
+
+func TestReaderMetadataMissingBucket(t *testing.T) {
+	dbPath := createTestSnapshotWithOps(t, []mvccOp{
+		{key: "/registry/secrets/default/a", value: []byte("a")},
+	})
+
+	reader, err := NewReader(dbPath)
+	if err != nil {
+		t.Fatalf("NewReader() error: %v", err)
+	}
+	defer reader.Close()
+
+	if _, err := reader.Metadata(); err == nil {
+		t.Errorf("Metadata() expected error for snapshot without meta bucket")
+	}
+}
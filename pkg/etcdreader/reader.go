@@ -4,7 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"strings"
+	"os"
+	"sync"
 
 	bolt "go.etcd.io/bbolt"
 	"go.etcd.io/etcd/api/v3/mvccpb"
@@ -23,10 +24,34 @@ const markedRevBytesLen = revBytesLen + 1
 // Reader provides access to etcd snapshot data
 type Reader struct {
 	db *bolt.DB
+	// file is the temporary copy of a compressed or streamed snapshot, and
+	// tempPath its path until it is unlinked
+	file     *os.File
+	tempPath string
+	// replay describes the WAL entries replayed over a data directory
+	replay *Replay
+
+	indexOnce sync.Once
+	index     *keyIndex
+	indexErr  error
 }
 
-// NewReader opens an etcd snapshot file for reading
+// NewReader opens an etcd snapshot file for reading; gzip, zstd and xz
+// compressed snapshots are decompressed to a temporary file first
 func NewReader(snapshotPath string) (*Reader, error) {
+	return NewReaderWithOptions(snapshotPath, Options{})
+}
+
+// NewReaderWithOptions opens an etcd snapshot file for reading with opts
+func NewReaderWithOptions(snapshotPath string, opts Options) (*Reader, error) {
+	tempPath, err := decompress(snapshotPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	if tempPath != "" {
+		return openTempCopy(tempPath)
+	}
+
 	// Open the bbolt database in read-only mode
 	db, err := bolt.Open(snapshotPath, 0600, &bolt.Options{ReadOnly: true})
 	if err != nil {
@@ -36,147 +61,294 @@ func NewReader(snapshotPath string) (*Reader, error) {
 	return &Reader{db: db}, nil
 }
 
-// Close closes the snapshot file
+// Close closes the snapshot file and removes its temporary copy
 func (r *Reader) Close() error {
+	var err error
 	if r.db != nil {
-		return r.db.Close()
+		err = r.db.Close()
 	}
-	return nil
+	if r.file != nil {
+		r.file.Close()
+	}
+	if r.tempPath != "" {
+		if rmErr := removeTempFile(r.tempPath); rmErr != nil && err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
+
+// keyIndex returns the key index, building it on first use
+func (r *Reader) keyIndex() (*keyIndex, error) {
+	r.indexOnce.Do(func() {
+		r.index, r.indexErr = buildIndex(r.db)
+	})
+	return r.index, r.indexErr
 }
 
 // Get retrieves a value from etcd by key name (not MVCC revision)
 func (r *Reader) Get(key string) ([]byte, error) {
-	var data []byte
+	idx, err := r.keyIndex()
+	if err != nil {
+		return nil, err
+	}
 
-	err := r.db.View(func(tx *bolt.Tx) error {
+	entry, ok := idx.latest(key)
+	if !ok || entry.tombstone {
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+
+	return r.readValue(key, entry)
+}
+
+// GetAtRevision retrieves the value a key had at the given MVCC revision
+// A key that did not exist yet or had been deleted at that revision is not found
+func (r *Reader) GetAtRevision(key string, rev int64) ([]byte, error) {
+	idx, err := r.keyIndex()
+	if err != nil {
+		return nil, err
+	}
+	if err := idx.checkRevision(rev); err != nil {
+		return nil, err
+	}
+
+	entry, ok := idx.at(key, rev)
+	if !ok || entry.tombstone {
+		return nil, fmt.Errorf("key not found at revision %d: %s", rev, key)
+	}
+
+	return r.readValue(key, entry)
+}
+
+// ListAtRevision lists the keys starting with prefix that existed at the
+// given MVCC revision; an empty prefix lists every key
+func (r *Reader) ListAtRevision(prefix string, rev int64) ([]string, error) {
+	idx, err := r.keyIndex()
+	if err != nil {
+		return nil, err
+	}
+	if err := idx.checkRevision(rev); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, key := range prefixRange(idx.keys, prefix) {
+		if entry, ok := idx.at(key, rev); ok && !entry.tombstone {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// KeyVersion is a single surviving MVCC record of a key
+type KeyVersion struct {
+	MainRevision   int64
+	SubRevision    int64
+	CreateRevision int64
+	ModRevision    int64
+	Version        int64
+	Lease          int64
+	// Tombstone is set when this record deleted the key; Value is then empty
+	Tombstone bool
+	Value     []byte
+}
+
+// History returns every surviving MVCC record of a key, oldest first,
+// including the tombstones left by deletions
+func (r *Reader) History(key string) ([]KeyVersion, error) {
+	idx, err := r.keyIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := idx.revisions[key]
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+
+	history := make([]KeyVersion, 0, len(entries))
+	err = r.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(buckets.Key.Name())
 		if bucket == nil {
 			return fmt.Errorf("key bucket not found in snapshot")
 		}
 
-		// Iterate through all MVCC entries to find the latest revision for this key
-		c := bucket.Cursor()
-		var latestRev int64 = -1
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			// Decode the MVCC key-value pair
+		for _, entry := range entries {
 			var kv mvccpb.KeyValue
-			if err := kv.Unmarshal(v); err != nil {
-				continue // Skip malformed entries
+			if err := kv.Unmarshal(bucket.Get(entry.revBytes)); err != nil {
+				return fmt.Errorf("failed to decode %s at revision %d: %w", key, entry.rev.main, err)
 			}
 
-			// Check if this is the key we're looking for
-			if string(kv.Key) == key {
-				// Check if it's not a tombstone (deleted)
-				if !isTombstone(k) {
-					// Get the revision
-					rev := bytesToRev(k)
-					if rev.main > latestRev {
-						latestRev = rev.main
-						data = make([]byte, len(kv.Value))
-						copy(data, kv.Value)
-					}
-				}
+			version := KeyVersion{
+				MainRevision:   entry.rev.main,
+				SubRevision:    entry.rev.sub,
+				CreateRevision: kv.CreateRevision,
+				ModRevision:    kv.ModRevision,
+				Version:        kv.Version,
+				Lease:          kv.Lease,
+				Tombstone:      entry.tombstone,
 			}
-		}
-
-		if latestRev == -1 {
-			return fmt.Errorf("key not found: %s", key)
+			if entry.tombstone {
+				// etcd stores only the key in a tombstone record
+				version.ModRevision = entry.rev.main
+			} else {
+				version.Value = make([]byte, len(kv.Value))
+				copy(version.Value, kv.Value)
+			}
+			history = append(history, version)
 		}
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return data, err
+	return history, nil
 }
 
-// ListSecrets lists all secrets in the snapshot
-func (r *Reader) ListSecrets() ([]string, error) {
-	var secrets []string
-	seenKeys := make(map[string]struct{})
+// Range returns the records of the keys in [key, end) as they were at rev, in
+// key order; as in etcd an empty end selects key alone, an end of "\x00"
+// selects every key from key onwards, and rev 0 reads the latest revision
+func (r *Reader) Range(key, end string, rev int64) ([]*mvccpb.KeyValue, error) {
+	idx, err := r.keyIndex()
+	if err != nil {
+		return nil, err
+	}
+	if rev == 0 {
+		rev = idx.currentRev
+	} else if err := idx.checkRevision(rev); err != nil {
+		return nil, err
+	}
 
-	err := r.db.View(func(tx *bolt.Tx) error {
+	var entries []indexEntry
+	for _, k := range idx.keyRange(key, end) {
+		if entry, ok := idx.at(k, rev); ok && !entry.tombstone {
+			entries = append(entries, entry)
+		}
+	}
+
+	kvs := make([]*mvccpb.KeyValue, 0, len(entries))
+	err = r.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(buckets.Key.Name())
 		if bucket == nil {
-			return fmt.Errorf("key bucket not found in snapshot - this may not be a valid etcd v3 snapshot")
+			return fmt.Errorf("key bucket not found in snapshot")
 		}
 
-		c := bucket.Cursor()
-		// Support both standard Kubernetes and OpenShift secret paths
-		prefixes := []string{"/registry/secrets/", "/kubernetes.io/secrets/"}
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			// Unmarshal the MVCC KeyValue
-			var kv mvccpb.KeyValue
-			if err := kv.Unmarshal(v); err != nil {
-				continue // Skip malformed entries
-			}
-
-			key := string(kv.Key)
-
-			// Check if this key is a secret (match any prefix)
-			for _, prefix := range prefixes {
-				if strings.HasPrefix(key, prefix) {
-					// Handle tombstones (deleted keys)
-					if !isTombstone(k) {
-						seenKeys[key] = struct{}{}
-					} else {
-						delete(seenKeys, key)
-					}
-					break
-				}
+		for _, entry := range entries {
+			// Unmarshal copies the key and value out of the bbolt page
+			kv := &mvccpb.KeyValue{}
+			if err := kv.Unmarshal(bucket.Get(entry.revBytes)); err != nil {
+				return fmt.Errorf("failed to decode record at revision %d: %w", entry.rev.main, err)
 			}
+			kvs = append(kvs, kv)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return kvs, nil
+}
 
-		// Convert map to slice
-		for key := range seenKeys {
-			secrets = append(secrets, key)
+// PrefixEnd returns the end of the Range holding every key starting with
+// prefix, as clientv3.WithPrefix computes it
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
 		}
+	}
+	return "\x00"
+}
+
+// Revision returns the newest MVCC revision in the snapshot
+func (r *Reader) Revision() (int64, error) {
+	idx, err := r.keyIndex()
+	if err != nil {
+		return 0, err
+	}
+	return idx.currentRev, nil
+}
 
+// Size returns the size of the snapshot database in bytes
+func (r *Reader) Size() (int64, error) {
+	var size int64
+	err := r.db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
 		return nil
 	})
-
-	return secrets, err
+	return size, err
 }
 
-// ListAll lists all keys in the snapshot (for debugging)
-func (r *Reader) ListAll() ([]string, error) {
-	var keys []string
-	seenKeys := make(map[string]struct{})
-
+// readValue loads the value stored in a single MVCC record
+func (r *Reader) readValue(key string, entry indexEntry) ([]byte, error) {
+	var data []byte
 	err := r.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(buckets.Key.Name())
 		if bucket == nil {
 			return fmt.Errorf("key bucket not found in snapshot")
 		}
 
-		c := bucket.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			// Unmarshal the MVCC KeyValue
-			var kv mvccpb.KeyValue
-			if err := kv.Unmarshal(v); err != nil {
-				continue
-			}
-
-			key := string(kv.Key)
-
-			// Handle tombstones
-			if !isTombstone(k) {
-				seenKeys[key] = struct{}{}
-			} else {
-				delete(seenKeys, key)
-			}
+		v := bucket.Get(entry.revBytes)
+		if v == nil {
+			return fmt.Errorf("key not found: %s", key)
 		}
 
-		// Convert map to slice
-		for key := range seenKeys {
-			keys = append(keys, key)
+		var kv mvccpb.KeyValue
+		if err := kv.Unmarshal(v); err != nil {
+			return fmt.Errorf("failed to decode %s: %w", key, err)
 		}
 
+		data = make([]byte, len(kv.Value))
+		copy(data, kv.Value)
 		return nil
 	})
 
-	return keys, err
+	return data, err
+}
+
+// ListSecrets lists all secrets in the snapshot
+func (r *Reader) ListSecrets() ([]string, error) {
+	idx, err := r.keyIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets []string
+	// Support both standard Kubernetes and OpenShift secret paths
+	for _, prefix := range []string{"/registry/secrets/", "/kubernetes.io/secrets/"} {
+		secrets = append(secrets, idx.withPrefix(prefix)...)
+	}
+
+	return secrets, nil
+}
+
+// ListAll lists all keys in the snapshot (for debugging)
+func (r *Reader) ListAll() ([]string, error) {
+	idx, err := r.keyIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(idx.live))
+	copy(keys, idx.live)
+	return keys, nil
+}
+
+// ListPrefix lists the live keys starting with prefix, such as every key
+// of one resource type
+func (r *Reader) ListPrefix(prefix string) ([]string, error) {
+	idx, err := r.keyIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]string(nil), idx.withPrefix(prefix)...), nil
 }
 
 // bytesToRev converts a byte slice to a revision
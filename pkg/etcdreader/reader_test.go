@@ -2,6 +2,8 @@ package etcdreader
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -12,7 +14,7 @@ import (
 )
 
 // createTestSnapshot creates a test etcd snapshot database with MVCC encoding
-func createTestSnapshot(t *testing.T, data map[string][]byte) string {
+func createTestSnapshot(t testing.TB, data map[string][]byte) string {
 	t.Helper()
 
 	tmpDir := t.TempDir()
@@ -73,6 +75,78 @@ func createTestSnapshot(t *testing.T, data map[string][]byte) string {
 	return dbPath
 }
 
+// mvccOp describes a single write applied to a test snapshot
+type mvccOp struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+// createTestSnapshotWithOps creates a test snapshot by applying ops in order,
+// one main revision per op, the way etcd records puts and deletes
+func createTestSnapshotWithOps(t testing.TB, ops []mvccOp) string {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "test-snapshot.db")
+
+	db, err := bolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(buckets.Key.Name())
+		if err != nil {
+			return err
+		}
+
+		created := make(map[string]int64)
+		versions := make(map[string]int64)
+
+		for i, op := range ops {
+			rev := int64(i + 1)
+
+			revBytes := make([]byte, 17, 18)
+			binary.BigEndian.PutUint64(revBytes[0:8], uint64(rev))
+			revBytes[8] = '_'
+
+			kv := &mvccpb.KeyValue{Key: []byte(op.key)}
+			if op.delete {
+				// Tombstones carry a trailing 't' marker and only the key
+				revBytes = append(revBytes, 't')
+				delete(created, op.key)
+				delete(versions, op.key)
+			} else {
+				if _, ok := created[op.key]; !ok {
+					created[op.key] = rev
+				}
+				versions[op.key]++
+				kv.Value = op.value
+				kv.CreateRevision = created[op.key]
+				kv.ModRevision = rev
+				kv.Version = versions[op.key]
+			}
+
+			kvBytes, err := kv.Marshal()
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(revBytes, kvBytes); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to populate test database: %v", err)
+	}
+
+	return dbPath
+}
+
 func TestNewReader(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -345,6 +419,32 @@ func TestReaderListAll(t *testing.T) {
 	}
 }
 
+func TestReaderListPrefix(t *testing.T) {
+	dbPath := createTestSnapshotWithOps(t, []mvccOp{
+		{key: "/registry/configmaps/default/config1", value: []byte("a")},
+		{key: "/registry/configmaps/kube-system/config2", value: []byte("b")},
+		{key: "/registry/configmaps/default/gone", value: []byte("c")},
+		{key: "/registry/configmaps/default/gone", delete: true},
+		{key: "/registry/configmapsextra/default/other", value: []byte("d")},
+		{key: "/registry/secrets/default/secret1", value: []byte("e")},
+	})
+	reader, err := NewReader(dbPath)
+	if err != nil {
+		t.Fatalf("NewReader() error: %v", err)
+	}
+	defer reader.Close()
+
+	keys, err := reader.ListPrefix("/registry/configmaps/")
+	if err != nil {
+		t.Fatalf("ListPrefix() error: %v", err)
+	}
+
+	want := []string{"/registry/configmaps/default/config1", "/registry/configmaps/kube-system/config2"}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Errorf("ListPrefix() = %v, want %v", keys, want)
+	}
+}
+
 func TestReaderWithRealSecretFormat(t *testing.T) {
 	// Simulate real Kubernetes secret data (encrypted)
 	encryptedSecret := []byte("k8s:enc:aescbc:v1:key1:\x00\x01\x02\x03\x04encrypted-data-here")
@@ -384,24 +484,323 @@ func TestReaderWithRealSecretFormat(t *testing.T) {
 	}
 }
 
-// Benchmark reader operations
-func BenchmarkReaderGet(b *testing.B) {
-	testData := map[string][]byte{
-		"/registry/secrets/default/secret1": []byte("secret-data"),
+func TestReaderUpdatesAndDeletes(t *testing.T) {
+	dbPath := createTestSnapshotWithOps(t, []mvccOp{
+		{key: "/registry/secrets/default/rotated", value: []byte("v1")},
+		{key: "/registry/secrets/default/deleted", value: []byte("gone")},
+		{key: "/registry/secrets/default/rotated", value: []byte("v2")},
+		{key: "/registry/secrets/default/deleted", delete: true},
+		{key: "/registry/secrets/default/recreated", value: []byte("old")},
+		{key: "/registry/secrets/default/recreated", delete: true},
+		{key: "/registry/secrets/default/recreated", value: []byte("new")},
+	})
+
+	reader, err := NewReader(dbPath)
+	if err != nil {
+		t.Fatalf("NewReader() error: %v", err)
 	}
+	defer reader.Close()
 
-	tmpDir := b.TempDir()
-	dbPath := filepath.Join(tmpDir, "bench-snapshot.db")
+	tests := []struct {
+		name      string
+		key       string
+		want      string
+		wantError bool
+	}{
+		{name: "Updated key returns newest value", key: "/registry/secrets/default/rotated", want: "v2"},
+		{name: "Deleted key is not found", key: "/registry/secrets/default/deleted", wantError: true},
+		{name: "Recreated key returns new value", key: "/registry/secrets/default/recreated", want: "new"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := reader.Get(tt.key)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("Get() expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get() unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Get() = %q, want %q", got, tt.want)
+			}
+		})
+	}
 
-	db, _ := bolt.Open(dbPath, 0600, nil)
-	db.Update(func(tx *bolt.Tx) error {
-		bucket, _ := tx.CreateBucketIfNotExists([]byte("key"))
-		for k, v := range testData {
-			bucket.Put([]byte(k), v)
+	secrets, err := reader.ListSecrets()
+	if err != nil {
+		t.Fatalf("ListSecrets() error: %v", err)
+	}
+	want := []string{"/registry/secrets/default/recreated", "/registry/secrets/default/rotated"}
+	if len(secrets) != len(want) {
+		t.Fatalf("ListSecrets() = %v, want %v", secrets, want)
+	}
+	for i := range want {
+		if secrets[i] != want[i] {
+			t.Errorf("ListSecrets()[%d] = %s, want %s", i, secrets[i], want[i])
 		}
-		return nil
+	}
+}
+
+func TestReaderGetAtRevision(t *testing.T) {
+	dbPath := createTestSnapshotWithOps(t, []mvccOp{
+		{key: "/registry/secrets/default/rotated", value: []byte("v1")},    // rev 1
+		{key: "/registry/secrets/default/deleted", value: []byte("gone")},  // rev 2
+		{key: "/registry/secrets/default/rotated", value: []byte("v2")},    // rev 3
+		{key: "/registry/secrets/default/deleted", delete: true},           // rev 4
+		{key: "/registry/secrets/default/late", value: []byte("late")},     // rev 5
+		{key: "/registry/configmaps/default/config", value: []byte("cfg")}, // rev 6
+	})
+	writeMeta(t, dbPath, map[string][]byte{"finishedCompactRev": revisionBytes(2)})
+
+	reader, err := NewReader(dbPath)
+	if err != nil {
+		t.Fatalf("NewReader() error: %v", err)
+	}
+	defer reader.Close()
+
+	tests := []struct {
+		name      string
+		key       string
+		rev       int64
+		want      string
+		wantError error
+	}{
+		{name: "Value at compacted revision", key: "/registry/secrets/default/rotated", rev: 2, want: "v1"},
+		{name: "Value after update", key: "/registry/secrets/default/rotated", rev: 3, want: "v2"},
+		{name: "Value before delete", key: "/registry/secrets/default/deleted", rev: 3, want: "gone"},
+		{name: "Deleted at revision", key: "/registry/secrets/default/deleted", rev: 4},
+		{name: "Not yet created", key: "/registry/secrets/default/late", rev: 4},
+		{name: "Compacted revision", key: "/registry/secrets/default/rotated", rev: 1, wantError: ErrCompacted},
+		{name: "Future revision", key: "/registry/secrets/default/rotated", rev: 7, wantError: ErrFutureRevision},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := reader.GetAtRevision(tt.key, tt.rev)
+			if tt.wantError != nil {
+				if !errors.Is(err, tt.wantError) {
+					t.Errorf("GetAtRevision() error = %v, want %v", err, tt.wantError)
+				}
+				return
+			}
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("GetAtRevision() expected not found, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetAtRevision() unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("GetAtRevision() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderListAtRevision(t *testing.T) {
+	dbPath := createTestSnapshotWithOps(t, []mvccOp{
+		{key: "/registry/secrets/default/a", value: []byte("a")},     // rev 1
+		{key: "/registry/secrets/default/b", value: []byte("b")},     // rev 2
+		{key: "/registry/configmaps/default/c", value: []byte("c")},  // rev 3
+		{key: "/registry/secrets/default/a", delete: true},           // rev 4
+		{key: "/registry/secrets/kube-system/d", value: []byte("d")}, // rev 5
+	})
+
+	reader, err := NewReader(dbPath)
+	if err != nil {
+		t.Fatalf("NewReader() error: %v", err)
+	}
+	defer reader.Close()
+
+	tests := []struct {
+		prefix string
+		rev    int64
+		want   []string
+	}{
+		{prefix: "/registry/secrets/", rev: 1, want: []string{"/registry/secrets/default/a"}},
+		{prefix: "/registry/secrets/", rev: 3, want: []string{"/registry/secrets/default/a", "/registry/secrets/default/b"}},
+		{prefix: "/registry/secrets/", rev: 5, want: []string{"/registry/secrets/default/b", "/registry/secrets/kube-system/d"}},
+		{prefix: "", rev: 4, want: []string{"/registry/configmaps/default/c", "/registry/secrets/default/b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s@%d", tt.prefix, tt.rev), func(t *testing.T) {
+			got, err := reader.ListAtRevision(tt.prefix, tt.rev)
+			if err != nil {
+				t.Fatalf("ListAtRevision() error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ListAtRevision() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("ListAtRevision()[%d] = %s, want %s", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	if _, err := reader.ListAtRevision("", 0); err == nil {
+		t.Errorf("ListAtRevision() expected error for revision 0")
+	}
+}
+
+func TestReaderRange(t *testing.T) {
+	dbPath := createTestSnapshotWithOps(t, []mvccOp{
+		{key: "/registry/secrets/default/a", value: []byte("a1")},    // rev 1
+		{key: "/registry/secrets/default/b", value: []byte("b")},     // rev 2
+		{key: "/registry/configmaps/default/c", value: []byte("c")},  // rev 3
+		{key: "/registry/secrets/default/a", value: []byte("a2")},    // rev 4
+		{key: "/registry/secrets/default/b", delete: true},           // rev 5
+		{key: "/registry/secrets/kube-system/d", value: []byte("d")}, // rev 6
+	})
+
+	reader, err := NewReader(dbPath)
+	if err != nil {
+		t.Fatalf("NewReader() error: %v", err)
+	}
+	defer reader.Close()
+
+	tests := []struct {
+		name      string
+		key, end  string
+		rev       int64
+		want      []string
+		wantValue string
+	}{
+		{name: "Single key", key: "/registry/secrets/default/a", want: []string{"/registry/secrets/default/a"}, wantValue: "a2"},
+		{name: "Single key at revision", key: "/registry/secrets/default/a", rev: 3, want: []string{"/registry/secrets/default/a"}, wantValue: "a1"},
+		{name: "Deleted key", key: "/registry/secrets/default/b"},
+		{name: "Half-open range", key: "/registry/secrets/", end: "/registry/secrets0", want: []string{"/registry/secrets/default/a", "/registry/secrets/kube-system/d"}, wantValue: "a2"},
+		{name: "Prefix", key: "/registry/secrets/kube-system/", end: PrefixEnd("/registry/secrets/kube-system/"), want: []string{"/registry/secrets/kube-system/d"}, wantValue: "d"},
+		{name: "From key", key: "/registry/secrets/default/b", end: "\x00", want: []string{"/registry/secrets/kube-system/d"}, wantValue: "d"},
+		{name: "All keys at revision", key: "\x00", end: "\x00", rev: 3, want: []string{"/registry/configmaps/default/c", "/registry/secrets/default/a", "/registry/secrets/default/b"}, wantValue: "c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kvs, err := reader.Range(tt.key, tt.end, tt.rev)
+			if err != nil {
+				t.Fatalf("Range() error: %v", err)
+			}
+			if len(kvs) != len(tt.want) {
+				t.Fatalf("Range() returned %d keys, want %v", len(kvs), tt.want)
+			}
+			for i, kv := range kvs {
+				if string(kv.Key) != tt.want[i] {
+					t.Errorf("Range()[%d] = %s, want %s", i, kv.Key, tt.want[i])
+				}
+			}
+			if len(kvs) > 0 && string(kvs[0].Value) != tt.wantValue {
+				t.Errorf("Range()[0] value = %q, want %q", kvs[0].Value, tt.wantValue)
+			}
+		})
+	}
+
+	if rev, err := reader.Revision(); err != nil || rev != 6 {
+		t.Errorf("Revision() = %d, %v, want 6", rev, err)
+	}
+	if _, err := reader.Range("\x00", "\x00", 7); !errors.Is(err, ErrFutureRevision) {
+		t.Errorf("Range() error = %v, want %v", err, ErrFutureRevision)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{prefix: "/registry/secrets/", want: "/registry/secrets0"},
+		{prefix: "a\xff", want: "b"},
+		{prefix: "\xff\xff", want: "\x00"},
+	}
+
+	for _, tt := range tests {
+		if got := PrefixEnd(tt.prefix); got != tt.want {
+			t.Errorf("PrefixEnd(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestReaderHistory(t *testing.T) {
+	dbPath := createTestSnapshotWithOps(t, []mvccOp{
+		{key: "/registry/secrets/default/creds", value: []byte("v1")}, // rev 1
+		{key: "/registry/secrets/default/other", value: []byte("x")},  // rev 2
+		{key: "/registry/secrets/default/creds", value: []byte("v2")}, // rev 3
+		{key: "/registry/secrets/default/creds", delete: true},        // rev 4
+		{key: "/registry/secrets/default/creds", value: []byte("v3")}, // rev 5
+	})
+
+	reader, err := NewReader(dbPath)
+	if err != nil {
+		t.Fatalf("NewReader() error: %v", err)
+	}
+	defer reader.Close()
+
+	history, err := reader.History("/registry/secrets/default/creds")
+	if err != nil {
+		t.Fatalf("History() error: %v", err)
+	}
+
+	want := []KeyVersion{
+		{MainRevision: 1, CreateRevision: 1, ModRevision: 1, Version: 1, Value: []byte("v1")},
+		{MainRevision: 3, CreateRevision: 1, ModRevision: 3, Version: 2, Value: []byte("v2")},
+		{MainRevision: 4, ModRevision: 4, Tombstone: true},
+		{MainRevision: 5, CreateRevision: 5, ModRevision: 5, Version: 1, Value: []byte("v3")},
+	}
+
+	if len(history) != len(want) {
+		t.Fatalf("History() returned %d versions, want %d", len(history), len(want))
+	}
+	for i := range want {
+		got := history[i]
+		if got.MainRevision != want[i].MainRevision || got.CreateRevision != want[i].CreateRevision ||
+			got.ModRevision != want[i].ModRevision || got.Version != want[i].Version ||
+			got.Tombstone != want[i].Tombstone || string(got.Value) != string(want[i].Value) {
+			t.Errorf("History()[%d] = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	if _, err := reader.History("/registry/secrets/default/missing"); err == nil {
+		t.Errorf("History() expected error for missing key")
+	}
+}
+
+// createBenchSnapshot creates a snapshot holding n secrets plus the same
+// number of unrelated keys, each written twice so the bucket holds history
+func createBenchSnapshot(b *testing.B, n int) (string, []string) {
+	b.Helper()
+
+	var ops []mvccOp
+	var secrets []string
+	for round := 0; round < 2; round++ {
+		for i := 0; i < n; i++ {
+			secret := fmt.Sprintf("/registry/secrets/ns%d/secret%d", i%10, i)
+			configMap := fmt.Sprintf("/registry/configmaps/ns%d/config%d", i%10, i)
+			ops = append(ops,
+				mvccOp{key: secret, value: []byte("secret-data")},
+				mvccOp{key: configMap, value: []byte("config-data")},
+			)
+			if round == 0 {
+				secrets = append(secrets, secret)
+			}
+		}
+	}
+
+	return createTestSnapshotWithOps(b, ops), secrets
+}
+
+// Benchmark reader operations
+func BenchmarkReaderGet(b *testing.B) {
+	dbPath := createTestSnapshot(b, map[string][]byte{
+		"/registry/secrets/default/secret1": []byte("secret-data"),
 	})
-	db.Close()
 
 	reader, _ := NewReader(dbPath)
 	defer reader.Close()
@@ -415,6 +814,61 @@ func BenchmarkReaderGet(b *testing.B) {
 	}
 }
 
+// BenchmarkReaderGetLargeSnapshot measures a single lookup in a snapshot with
+// many keys; with the index this no longer depends on the snapshot size
+func BenchmarkReaderGetLargeSnapshot(b *testing.B) {
+	for _, n := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("keys=%d", 2*n), func(b *testing.B) {
+			dbPath, secrets := createBenchSnapshot(b, n)
+
+			reader, _ := NewReader(dbPath)
+			defer reader.Close()
+
+			// Build the index outside of the timed section
+			if _, err := reader.Get(secrets[0]); err != nil {
+				b.Fatalf("Get failed: %v", err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := reader.Get(secrets[i%len(secrets)]); err != nil {
+					b.Fatalf("Get failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkReaderGetAllSecrets mirrors the CLI decrypt-all path: open the
+// snapshot, list the secrets and fetch every one of them
+func BenchmarkReaderGetAllSecrets(b *testing.B) {
+	for _, n := range []int{100, 1000} {
+		b.Run(fmt.Sprintf("secrets=%d", n), func(b *testing.B) {
+			dbPath, _ := createBenchSnapshot(b, n)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				reader, err := NewReader(dbPath)
+				if err != nil {
+					b.Fatalf("NewReader failed: %v", err)
+				}
+
+				secrets, err := reader.ListSecrets()
+				if err != nil {
+					b.Fatalf("ListSecrets failed: %v", err)
+				}
+				for _, s := range secrets {
+					if _, err := reader.Get(s); err != nil {
+						b.Fatalf("Get failed: %v", err)
+					}
+				}
+
+				reader.Close()
+			}
+		})
+	}
+}
+
 func BenchmarkReaderListSecrets(b *testing.B) {
 	testData := make(map[string][]byte)
 	for i := 0; i < 100; i++ {
@@ -422,18 +876,7 @@ func BenchmarkReaderListSecrets(b *testing.B) {
 		testData["/registry/secrets/default/secret"+key] = []byte("data")
 	}
 
-	tmpDir := b.TempDir()
-	dbPath := filepath.Join(tmpDir, "bench-snapshot.db")
-
-	db, _ := bolt.Open(dbPath, 0600, nil)
-	db.Update(func(tx *bolt.Tx) error {
-		bucket, _ := tx.CreateBucketIfNotExists([]byte("key"))
-		for k, v := range testData {
-			bucket.Put([]byte(k), v)
-		}
-		return nil
-	})
-	db.Close()
+	dbPath := createTestSnapshot(b, testData)
 
 	reader, _ := NewReader(dbPath)
 	defer reader.Close()
@@ -0,0 +1,405 @@
+package etcdreader
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/server/v3/mvcc/buckets"
+)
+
+// Replay describes the WAL entries applied over the database of a data
+// directory
+type Replay struct {
+	// FromIndex is the consistent index of the database, the last entry it
+	// had applied
+	FromIndex uint64 `json:"fromIndex"`
+	// ToIndex is the index of the last committed entry in the WAL
+	ToIndex uint64 `json:"toIndex"`
+	// Entries is the number of entries applied
+	Entries int `json:"entries"`
+	// Revisions is the number of MVCC revisions the entries created
+	Revisions int64 `json:"revisions"`
+	// Skipped counts the requests that are not replayed, such as etcd v2
+	// requests
+	Skipped int `json:"skipped,omitempty"`
+	// Uncommitted counts the entries written after the last commit, which
+	// are ignored
+	Uncommitted int `json:"uncommitted,omitempty"`
+}
+
+// applyWAL applies the committed entries of the WAL in walDir that follow
+// the consistent index of the database at path, which is modified; log holds
+// the entries when they were already read for that index
+func applyWAL(path, walDir string, log *walLog) (*Replay, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer db.Close()
+
+	var replay *Replay
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(buckets.Meta.Name())
+		if meta == nil {
+			return fmt.Errorf("meta bucket not found in snapshot")
+		}
+		index := readUint64(meta, buckets.MetaConsistentIndexKeyName)
+		if log == nil || log.after != index {
+			var err error
+			if log, err = readWAL(walDir, index); err != nil {
+				return err
+			}
+		}
+
+		replay = &Replay{FromIndex: index, ToIndex: index, Uncommitted: log.uncommitted}
+		if len(log.entries) == 0 {
+			return nil
+		}
+
+		a, err := newWALApplier(tx)
+		if err != nil {
+			return err
+		}
+		startRev := a.rev
+		var term uint64
+		for _, e := range log.entries {
+			if e.typ == raftEntryNormal && len(e.data) > 0 {
+				var req etcdserverpb.InternalRaftRequest
+				if err := req.Unmarshal(e.data); err != nil {
+					replay.Skipped++
+				} else if err := a.apply(&req); err != nil {
+					return fmt.Errorf("replaying WAL entry %d: %w", e.index, err)
+				}
+			}
+			replay.ToIndex, term = e.index, e.term
+			replay.Entries++
+		}
+		replay.Revisions = a.rev - startRev
+
+		// The database now holds every committed entry, as etcd would
+		// record it after applying them
+		if err := meta.Put(buckets.MetaConsistentIndexKeyName, uint64ToBytes(replay.ToIndex)); err != nil {
+			return err
+		}
+		return meta.Put(buckets.MetaTermKeyName, uint64ToBytes(term))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replay, nil
+}
+
+// walApplier applies the requests of WAL entries to the key bucket the way
+// the etcd MVCC store does: each request that changes keys creates one main
+// revision, with a sub revision per change
+type walApplier struct {
+	bucket *bolt.Bucket
+	// live holds the newest record of every key that is not deleted
+	live map[string]*mvccpb.KeyValue
+	// rev is the current main revision and sub the next sub revision of the
+	// request being applied
+	rev int64
+	sub int64
+}
+
+// newWALApplier loads the current state of the key bucket of tx
+func newWALApplier(tx *bolt.Tx) (*walApplier, error) {
+	bucket := tx.Bucket(buckets.Key.Name())
+	if bucket == nil {
+		return nil, fmt.Errorf("key bucket not found in snapshot - this may not be a valid etcd v3 snapshot")
+	}
+
+	a := &walApplier{bucket: bucket, live: make(map[string]*mvccpb.KeyValue)}
+	// Compaction may remove the records of the newest revisions, such as
+	// tombstones, so etcd never restarts below the compacted revision
+	if meta := tx.Bucket(buckets.Meta.Name()); meta != nil {
+		a.rev = readRevision(meta, finishedCompactKeyName)
+	}
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if len(k) != revBytesLen && len(k) != markedRevBytesLen {
+			continue // Not an MVCC revision key
+		}
+		var kv mvccpb.KeyValue
+		if err := kv.Unmarshal(v); err != nil {
+			continue // Skip malformed entries
+		}
+		if rev := bytesToRev(k); rev.main > a.rev {
+			a.rev = rev.main
+		}
+		if isTombstone(k) {
+			delete(a.live, string(kv.Key))
+		} else {
+			a.live[string(kv.Key)] = &kv
+		}
+	}
+	return a, nil
+}
+
+// apply applies one request; requests that do not change keys, such as
+// lease grants, compactions and cluster changes, are ignored
+func (a *walApplier) apply(req *etcdserverpb.InternalRaftRequest) error {
+	a.sub = 0
+	var err error
+	switch {
+	case req.Put != nil:
+		err = a.put(req.Put)
+	case req.DeleteRange != nil:
+		err = a.deleteRange(req.DeleteRange.Key, req.DeleteRange.RangeEnd)
+	case req.Txn != nil:
+		err = a.txn(req.Txn)
+	case req.LeaseRevoke != nil:
+		// Revoking a lease deletes the keys attached to it
+		var keys []string
+		for key, kv := range a.live {
+			if kv.Lease == req.LeaseRevoke.ID {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err = a.delete(key); err != nil {
+				break
+			}
+		}
+	}
+	if a.sub > 0 {
+		a.rev++
+	}
+	return err
+}
+
+// put writes a new version of a key
+func (a *walApplier) put(p *etcdserverpb.PutRequest) error {
+	main := a.rev + 1
+	kv := &mvccpb.KeyValue{
+		Key:            p.Key,
+		Value:          p.Value,
+		CreateRevision: main,
+		ModRevision:    main,
+		Version:        1,
+		Lease:          p.Lease,
+	}
+	if prev, ok := a.live[string(p.Key)]; ok {
+		kv.CreateRevision = prev.CreateRevision
+		kv.Version = prev.Version + 1
+		if p.IgnoreValue {
+			kv.Value = prev.Value
+		}
+		if p.IgnoreLease {
+			kv.Lease = prev.Lease
+		}
+	} else if p.IgnoreValue || p.IgnoreLease {
+		return nil // etcd rejects these for missing keys
+	}
+
+	if err := a.write(false, kv); err != nil {
+		return err
+	}
+	a.live[string(p.Key)] = kv
+	return nil
+}
+
+// deleteRange deletes the keys from key to end, as in a DeleteRangeRequest
+func (a *walApplier) deleteRange(key, end []byte) error {
+	for _, k := range a.rangeKeys(key, end) {
+		if err := a.delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete writes the tombstone of a key
+func (a *walApplier) delete(key string) error {
+	if err := a.write(true, &mvccpb.KeyValue{Key: []byte(key)}); err != nil {
+		return err
+	}
+	delete(a.live, key)
+	return nil
+}
+
+// txn applies a transaction the way etcd does: the comparisons of it and of
+// its nested transactions pick their branches against the keys as they were
+// before it, and a transaction whose operations cannot all succeed changes
+// nothing
+func (a *walApplier) txn(t *etcdserverpb.TxnRequest) error {
+	path := a.txnPath(t)
+	if _, ok := a.checkTxn(t, path); !ok {
+		return nil
+	}
+	_, err := a.applyTxn(t, path)
+	return err
+}
+
+// txnPath evaluates the comparisons of t, then of the nested transactions
+// of the branch they pick, in the order they are applied
+func (a *walApplier) txnPath(t *etcdserverpb.TxnRequest) []bool {
+	success := true
+	for _, c := range t.Compare {
+		if !a.compare(c) {
+			success = false
+			break
+		}
+	}
+
+	path := []bool{success}
+	for _, op := range txnOps(t, success) {
+		if r, ok := op.Request.(*etcdserverpb.RequestOp_RequestTxn); ok {
+			path = append(path, a.txnPath(r.RequestTxn)...)
+		}
+	}
+	return path
+}
+
+// checkTxn reports whether every operation of the branches path picks can
+// succeed: etcd rejects puts that keep the value or lease of a missing key.
+// It returns the rest of path, for the transactions that follow t
+func (a *walApplier) checkTxn(t *etcdserverpb.TxnRequest, path []bool) ([]bool, bool) {
+	ops, path := txnOps(t, path[0]), path[1:]
+	for _, op := range ops {
+		switch r := op.Request.(type) {
+		case *etcdserverpb.RequestOp_RequestPut:
+			if _, ok := a.live[string(r.RequestPut.Key)]; !ok && (r.RequestPut.IgnoreValue || r.RequestPut.IgnoreLease) {
+				return nil, false
+			}
+		case *etcdserverpb.RequestOp_RequestTxn:
+			var ok bool
+			if path, ok = a.checkTxn(r.RequestTxn, path); !ok {
+				return nil, false
+			}
+		}
+	}
+	return path, true
+}
+
+// applyTxn applies the operations of the branches path picks, and returns
+// the rest of path
+func (a *walApplier) applyTxn(t *etcdserverpb.TxnRequest, path []bool) ([]bool, error) {
+	ops, path := txnOps(t, path[0]), path[1:]
+	for _, op := range ops {
+		var err error
+		switch r := op.Request.(type) {
+		case *etcdserverpb.RequestOp_RequestPut:
+			err = a.put(r.RequestPut)
+		case *etcdserverpb.RequestOp_RequestDeleteRange:
+			err = a.deleteRange(r.RequestDeleteRange.Key, r.RequestDeleteRange.RangeEnd)
+		case *etcdserverpb.RequestOp_RequestTxn:
+			path, err = a.applyTxn(r.RequestTxn, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return path, nil
+}
+
+// txnOps returns the success or failure operations of t
+func txnOps(t *etcdserverpb.TxnRequest, success bool) []*etcdserverpb.RequestOp {
+	if success {
+		return t.Success
+	}
+	return t.Failure
+}
+
+// compare evaluates a transaction comparison against every key in its
+// range; a missing key has zero revisions, version and lease, and no value
+func (a *walApplier) compare(c *etcdserverpb.Compare) bool {
+	keys := a.rangeKeys(c.Key, c.RangeEnd)
+	if len(keys) == 0 {
+		if c.Target == etcdserverpb.Compare_VALUE {
+			return false
+		}
+		return CompareKeyValue(c, &mvccpb.KeyValue{})
+	}
+	for _, key := range keys {
+		if !CompareKeyValue(c, a.live[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// CompareKeyValue evaluates a transaction comparison against one key the way
+// etcd does; a missing key is compared as an empty record
+func CompareKeyValue(c *etcdserverpb.Compare, kv *mvccpb.KeyValue) bool {
+	var result int
+	switch c.Target {
+	case etcdserverpb.Compare_VALUE:
+		result = bytes.Compare(kv.Value, c.GetValue())
+	case etcdserverpb.Compare_CREATE:
+		result = cmp.Compare(kv.CreateRevision, c.GetCreateRevision())
+	case etcdserverpb.Compare_MOD:
+		result = cmp.Compare(kv.ModRevision, c.GetModRevision())
+	case etcdserverpb.Compare_VERSION:
+		result = cmp.Compare(kv.Version, c.GetVersion())
+	case etcdserverpb.Compare_LEASE:
+		result = cmp.Compare(kv.Lease, c.GetLease())
+	}
+
+	switch c.Result {
+	case etcdserverpb.Compare_EQUAL:
+		return result == 0
+	case etcdserverpb.Compare_NOT_EQUAL:
+		return result != 0
+	case etcdserverpb.Compare_GREATER:
+		return result > 0
+	case etcdserverpb.Compare_LESS:
+		return result < 0
+	}
+	return false
+}
+
+// rangeKeys returns the live keys from key to end, sorted: only key when
+// end is empty, and every key from key on when end is "\x00"
+func (a *walApplier) rangeKeys(key, end []byte) []string {
+	if len(end) == 0 {
+		if _, ok := a.live[string(key)]; ok {
+			return []string{string(key)}
+		}
+		return nil
+	}
+
+	var keys []string
+	for k := range a.live {
+		if k >= string(key) && (bytes.Equal(end, []byte{0}) || k < string(end)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// write stores a record at the next revision of the current request
+func (a *walApplier) write(tombstone bool, kv *mvccpb.KeyValue) error {
+	revBytes := make([]byte, revBytesLen, markedRevBytesLen)
+	binary.BigEndian.PutUint64(revBytes[0:8], uint64(a.rev+1))
+	revBytes[8] = '_'
+	binary.BigEndian.PutUint64(revBytes[9:], uint64(a.sub))
+	if tombstone {
+		revBytes = append(revBytes, 't')
+	}
+
+	value, err := kv.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := a.bucket.Put(revBytes, value); err != nil {
+		return err
+	}
+	a.sub++
+	return nil
+}
+
+// uint64ToBytes encodes a big-endian uint64 meta value
+func uint64ToBytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,129 @@
+package etcdreader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// tempFiles holds the temporary copies of snapshots still on disk
+var tempFiles = struct {
+	sync.Mutex
+	paths map[string]struct{}
+}{paths: map[string]struct{}{}}
+
+// RemoveTempFiles removes the temporary copies of every snapshot being
+// copied or open. It is meant for signal handlers, which exit without
+// closing readers; readers whose copy is removed can no longer be used.
+func RemoveTempFiles() {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+
+	for path := range tempFiles.paths {
+		if err := os.Remove(path); err == nil || os.IsNotExist(err) {
+			delete(tempFiles.paths, path)
+		}
+	}
+}
+
+// removeTempFile removes a temporary copy and stops tracking it once gone
+func removeTempFile(path string) error {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+
+	err := os.Remove(path)
+	if err == nil || os.IsNotExist(err) {
+		delete(tempFiles.paths, path)
+		return nil
+	}
+	return err
+}
+
+// NewReaderFrom reads a snapshot from r, such as stdin or an archive entry;
+// the snapshot is copied to a temporary file, decompressed when it is gzip,
+// zstd or xz compressed
+func NewReaderFrom(r io.Reader) (*Reader, error) {
+	return NewReaderFromWithOptions(r, Options{})
+}
+
+// NewReaderFromWithOptions reads a snapshot from r with opts
+func NewReaderFromWithOptions(r io.Reader, opts Options) (*Reader, error) {
+	src := bufio.NewReader(r)
+	header, _ := src.Peek(6)
+
+	tempPath, err := spool(src, detectCompression(header), opts)
+	if err != nil {
+		return nil, err
+	}
+	return openTempCopy(tempPath)
+}
+
+// spool copies src to a private temporary file, decompressing it with c
+// when it is not nil, and returns the path of the copy
+func spool(src io.Reader, c *compression, opts Options) (string, error) {
+	action := "copy"
+	if c != nil {
+		action = "decompress " + c.name
+		r, err := c.open(src)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s snapshot: %w", c.name, err)
+		}
+		defer r.Close()
+		src = r
+	}
+
+	limit := opts.MaxDecompressedSize
+	if limit <= 0 {
+		limit = DefaultMaxDecompressedSize
+	}
+
+	// CreateTemp creates the file readable by the current user only
+	tmp, err := os.CreateTemp(opts.TempDir, "etcd-snapshot-*.db")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tempFiles.Lock()
+	tempFiles.paths[tmp.Name()] = struct{}{}
+	tempFiles.Unlock()
+
+	n, err := io.Copy(tmp, io.LimitReader(src, limit+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n > limit {
+		err = fmt.Errorf("%w of %d bytes", ErrDecompressedTooLarge, limit)
+	}
+	if err != nil {
+		removeTempFile(tmp.Name())
+		return "", fmt.Errorf("failed to %s snapshot: %w", action, err)
+	}
+	return tmp.Name(), nil
+}
+
+// openTempCopy opens the temporary copy of a snapshot. The copy is unlinked
+// as soon as it is open where the system allows it, so that nothing is left
+// behind even when the process is killed; elsewhere Close removes it.
+func openTempCopy(path string) (*Reader, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		removeTempFile(path)
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	// Verify reads the file itself, which has no path once unlinked
+	file, err := os.Open(path)
+	if err != nil {
+		db.Close()
+		removeTempFile(path)
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+
+	r := &Reader{db: db, file: file, tempPath: path}
+	if removeTempFile(path) == nil {
+		r.tempPath = ""
+	}
+	return r, nil
+}
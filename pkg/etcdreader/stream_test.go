@@ -0,0 +1,94 @@
+package etcdreader
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNewReaderFrom(t *testing.T) {
+	dbPath := createVerifiableSnapshot(t, map[string][]byte{"consistent_index": uint64Bytes(10)})
+	appendHashTrailer(t, dbPath)
+
+	for _, format := range []string{"", "gzip", "zstd"} {
+		t.Run("Stream "+format, func(t *testing.T) {
+			path := dbPath
+			if format != "" {
+				path = compressFile(t, dbPath, format)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read snapshot: %v", err)
+			}
+
+			tempDir := t.TempDir()
+			reader, err := NewReaderFromWithOptions(bytes.NewReader(data), Options{TempDir: tempDir})
+			if err != nil {
+				t.Fatalf("NewReaderFromWithOptions() error: %v", err)
+			}
+			defer reader.Close()
+			assertEmptyDir(t, tempDir)
+
+			kvs, err := reader.Range("/registry/secrets/default/b", "", 0)
+			if err != nil || len(kvs) != 1 || string(kvs[0].Value) != "b" {
+				t.Errorf("Range() = %v, %v, want the test secret", kvs, err)
+			}
+
+			// Verify hashes the unlinked copy
+			report, err := reader.Verify()
+			if err != nil {
+				t.Fatalf("Verify() error: %v", err)
+			}
+			if !report.Passed() || report.Checks[0].Status != CheckPass {
+				t.Errorf("Verify() = %+v, want every check passed", report.Checks)
+			}
+		})
+	}
+}
+
+func TestNewReaderFromErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		opts    Options
+		wantErr error
+	}{
+		{name: "Not a snapshot", data: "not a valid bolt db"},
+		{name: "Size limit", data: strings.Repeat("x", 8192), opts: Options{MaxDecompressedSize: 4096}, wantErr: ErrDecompressedTooLarge},
+		{name: "Empty stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.TempDir = t.TempDir()
+			reader, err := NewReaderFromWithOptions(strings.NewReader(tt.data), tt.opts)
+			if err == nil {
+				reader.Close()
+				t.Fatal("NewReaderFromWithOptions() succeeded")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewReaderFromWithOptions() error = %v, want %v", err, tt.wantErr)
+			}
+			assertEmptyDir(t, tt.opts.TempDir)
+		})
+	}
+}
+
+func TestRemoveTempFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	path, err := spool(strings.NewReader("partial"), nil, Options{TempDir: tempDir})
+	if err != nil {
+		t.Fatalf("spool() error: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("spooled copy missing: %v", err)
+	}
+
+	RemoveTempFiles()
+	assertEmptyDir(t, tempDir)
+	if _, tracked := tempFiles.paths[path]; tracked {
+		t.Error("RemoveTempFiles() kept tracking a removed copy")
+	}
+}
@@ -0,0 +1,210 @@
+package etcdreader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/server/v3/mvcc/buckets"
+)
+
+// CheckStatus is the outcome of an integrity check
+type CheckStatus string
+
+// Outcomes of an integrity check; only CheckFail fails a report
+const (
+	CheckPass CheckStatus = "pass"
+	CheckFail CheckStatus = "fail"
+	CheckWarn CheckStatus = "warn"
+	CheckSkip CheckStatus = "skip"
+)
+
+// Names of the checks run by Verify, in order
+const (
+	CheckSHA256    = "sha256"
+	CheckSize      = "size"
+	CheckPages     = "pages"
+	CheckBuckets   = "buckets"
+	CheckRevisions = "revisions"
+)
+
+// Check is the result of one integrity check
+type Check struct {
+	Name   string      `json:"name"`
+	Status CheckStatus `json:"status"`
+	Detail string      `json:"detail"`
+}
+
+// VerifyReport lists the integrity checks run on a snapshot, in order
+type VerifyReport struct {
+	Checks []Check `json:"checks"`
+}
+
+// Passed reports whether no check failed; warnings and skipped checks pass
+func (r *VerifyReport) Passed() bool {
+	for _, c := range r.Checks {
+		if c.Status == CheckFail {
+			return false
+		}
+	}
+	return true
+}
+
+// add records the result of a check
+func (r *VerifyReport) add(name string, status CheckStatus, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, Check{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Verify checks the integrity of the snapshot file: the SHA-256 trailer
+// appended by etcdctl snapshot save, that no page is missing from the end of
+// the file, the consistency of the bbolt pages, that the key and meta buckets
+// exist and that the meta bucket agrees with the key index.
+//
+// Failed checks are reported, not returned; the error is only set when the
+// file cannot be read. Checks that depend on a failed one are skipped, so a
+// truncated snapshot is never read past its end.
+func (r *Reader) Verify() (*VerifyReport, error) {
+	f := r.file
+	if f == nil {
+		var err error
+		if f, err = os.Open(r.db.Path()); err != nil {
+			return nil, fmt.Errorf("failed to open snapshot: %w", err)
+		}
+		defer f.Close()
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat snapshot: %w", err)
+	}
+
+	report := &VerifyReport{}
+	dataSize := info.Size()
+	if hasHashTrailer(dataSize) {
+		dataSize -= sha256.Size
+		if err := verifyHashTrailer(f, dataSize); err != nil {
+			report.add(CheckSHA256, CheckFail, "%v", err)
+		} else {
+			report.add(CheckSHA256, CheckPass, "matches the trailer written by etcdctl snapshot save")
+		}
+	} else {
+		report.add(CheckSHA256, CheckSkip, "no SHA-256 trailer; the file was not written by etcdctl snapshot save")
+	}
+
+	var dbSize int64
+	if err := r.db.View(func(tx *bolt.Tx) error {
+		dbSize = tx.Size()
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	pageSize := int64(r.db.Info().PageSize)
+
+	switch extra := dataSize - dbSize; {
+	case extra < 0:
+		report.add(CheckSize, CheckFail, "file holds %d of the %d bytes of the database; the snapshot is truncated", dataSize, dbSize)
+		for _, name := range []string{CheckPages, CheckBuckets, CheckRevisions} {
+			report.add(name, CheckSkip, "snapshot is truncated")
+		}
+		return report, nil
+	case extra%pageSize != 0:
+		report.add(CheckSize, CheckWarn, "%d bytes after the database are neither pages nor a SHA-256 trailer", extra)
+	default:
+		report.add(CheckSize, CheckPass, "%d bytes in %d pages of %d bytes", dbSize, dbSize/pageSize, pageSize)
+	}
+
+	r.checkPages(report, dbSize/pageSize)
+	if r.checkBuckets(report) {
+		r.checkRevisions(report)
+	} else {
+		report.add(CheckRevisions, CheckSkip, "snapshot has no key or meta bucket")
+	}
+	return report, nil
+}
+
+// hasHashTrailer reports whether a file of size bytes ends with a SHA-256
+// hash; bbolt files are page aligned, so etcd detects the trailer the same way
+func hasHashTrailer(size int64) bool {
+	return size%512 == sha256.Size
+}
+
+// verifyHashTrailer compares the SHA-256 hash of the first size bytes of f
+// with the hash stored right after them
+func verifyHashTrailer(f *os.File, size int64) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, size)); err != nil {
+		return fmt.Errorf("failed to hash snapshot: %w", err)
+	}
+
+	trailer := make([]byte, sha256.Size)
+	if _, err := f.ReadAt(trailer, size); err != nil {
+		return fmt.Errorf("failed to read SHA-256 trailer: %w", err)
+	}
+	if sum := h.Sum(nil); !bytes.Equal(sum, trailer) {
+		return fmt.Errorf("SHA-256 mismatch: file hashes to %x, trailer has %x", sum, trailer)
+	}
+	return nil
+}
+
+// checkPages runs the bbolt consistency check over every page
+func (r *Reader) checkPages(report *VerifyReport, pages int64) {
+	var problems []string
+	err := r.db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			problems = append(problems, err.Error())
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		report.add(CheckPages, CheckFail, "%v", err)
+	case len(problems) > 1:
+		report.add(CheckPages, CheckFail, "%s (and %d more problems)", problems[0], len(problems)-1)
+	case len(problems) == 1:
+		report.add(CheckPages, CheckFail, "%s", problems[0])
+	default:
+		report.add(CheckPages, CheckPass, "%d pages consistent", pages)
+	}
+}
+
+// checkBuckets checks that the buckets read by the key index exist, and
+// reports whether they do
+func (r *Reader) checkBuckets(report *VerifyReport) bool {
+	var missing []string
+	r.db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{buckets.Key.Name(), buckets.Meta.Name()} {
+			if tx.Bucket(name) == nil {
+				missing = append(missing, string(name))
+			}
+		}
+		return nil
+	})
+	if len(missing) > 0 {
+		report.add(CheckBuckets, CheckFail, "missing %s bucket", strings.Join(missing, " and "))
+		return false
+	}
+	report.add(CheckBuckets, CheckPass, "key and meta buckets present")
+	return true
+}
+
+// checkRevisions cross-checks the meta bucket against the newest revision of
+// the key index. Every write is a raft entry, so the consistent index is
+// normally at least the revision; a member restored from a snapshot restarts
+// its index below the revision, which is only a warning.
+func (r *Reader) checkRevisions(report *VerifyReport) {
+	md, err := r.Metadata()
+	switch {
+	case err != nil:
+		report.add(CheckRevisions, CheckFail, "%v", err)
+	case md.ConsistentIndex == 0:
+		report.add(CheckRevisions, CheckFail, "meta bucket has no consistent index")
+	case md.ConsistentIndex < uint64(md.CurrentRevision):
+		report.add(CheckRevisions, CheckWarn, "consistent index %d is below revision %d; expected only on a member restored from a snapshot", md.ConsistentIndex, md.CurrentRevision)
+	default:
+		report.add(CheckRevisions, CheckPass, "consistent index %d, revision %d", md.ConsistentIndex, md.CurrentRevision)
+	}
+}
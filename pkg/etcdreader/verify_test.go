@@ -0,0 +1,171 @@
+package etcdreader
+
+import (
+	"crypto/sha256"
+	"os"
+	"testing"
+)
+
+// createVerifiableSnapshot creates a snapshot with key and meta buckets
+func createVerifiableSnapshot(t *testing.T, meta map[string][]byte) string {
+	t.Helper()
+
+	dbPath := createTestSnapshotWithOps(t, []mvccOp{
+		{key: "/registry/secrets/default/a", value: []byte("a")},
+		{key: "/registry/secrets/default/b", value: []byte("b")},
+		{key: "/registry/secrets/default/a", delete: true},
+	})
+	writeMeta(t, dbPath, meta)
+	return dbPath
+}
+
+// appendHashTrailer appends the SHA-256 hash of the file, as etcdctl
+// snapshot save does
+func appendHashTrailer(t *testing.T, dbPath string) {
+	t.Helper()
+
+	data, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	if err := os.WriteFile(dbPath, append(data, sum[:]...), 0600); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+}
+
+func TestReaderVerify(t *testing.T) {
+	healthyMeta := map[string][]byte{"consistent_index": uint64Bytes(10)}
+
+	tests := []struct {
+		name   string
+		meta   map[string][]byte
+		modify func(t *testing.T, dbPath string)
+		want   map[string]CheckStatus
+		passed bool
+	}{
+		{
+			name: "Without trailer",
+			meta: healthyMeta,
+			want: map[string]CheckStatus{
+				CheckSHA256: CheckSkip, CheckSize: CheckPass, CheckPages: CheckPass,
+				CheckBuckets: CheckPass, CheckRevisions: CheckPass,
+			},
+			passed: true,
+		},
+		{
+			name:   "With trailer",
+			meta:   healthyMeta,
+			modify: appendHashTrailer,
+			want:   map[string]CheckStatus{CheckSHA256: CheckPass, CheckSize: CheckPass},
+			passed: true,
+		},
+		{
+			name: "Trailer mismatch",
+			meta: healthyMeta,
+			modify: func(t *testing.T, dbPath string) {
+				appendHashTrailer(t, dbPath)
+				f, err := os.OpenFile(dbPath, os.O_WRONLY, 0)
+				if err != nil {
+					t.Fatalf("Failed to open snapshot: %v", err)
+				}
+				defer f.Close()
+				info, _ := f.Stat()
+				f.WriteAt([]byte{0}, info.Size()-1)
+			},
+			want:   map[string]CheckStatus{CheckSHA256: CheckFail, CheckPages: CheckPass},
+			passed: false,
+		},
+		{
+			name: "Truncated",
+			meta: healthyMeta,
+			modify: func(t *testing.T, dbPath string) {
+				// Cut into the last page of the database, not just the
+				// space bbolt preallocates after it
+				if err := os.Truncate(dbPath, 5*4096-100); err != nil {
+					t.Fatalf("Failed to truncate snapshot: %v", err)
+				}
+			},
+			want:   map[string]CheckStatus{CheckSize: CheckFail, CheckPages: CheckSkip, CheckRevisions: CheckSkip},
+			passed: false,
+		},
+		{
+			name:   "Missing consistent index",
+			meta:   map[string][]byte{"term": uint64Bytes(2)},
+			want:   map[string]CheckStatus{CheckBuckets: CheckPass, CheckRevisions: CheckFail},
+			passed: false,
+		},
+		{
+			name:   "Restored member",
+			meta:   map[string][]byte{"consistent_index": uint64Bytes(1)},
+			want:   map[string]CheckStatus{CheckRevisions: CheckWarn},
+			passed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbPath := createVerifiableSnapshot(t, tt.meta)
+			if tt.modify != nil {
+				tt.modify(t, dbPath)
+			}
+
+			reader, err := NewReader(dbPath)
+			if err != nil {
+				t.Fatalf("NewReader() error: %v", err)
+			}
+			defer reader.Close()
+
+			report, err := reader.Verify()
+			if err != nil {
+				t.Fatalf("Verify() error: %v", err)
+			}
+			if len(report.Checks) != 5 {
+				t.Errorf("Verify() ran %d checks, want 5: %+v", len(report.Checks), report.Checks)
+			}
+
+			got := map[string]CheckStatus{}
+			for _, c := range report.Checks {
+				got[c.Name] = c.Status
+			}
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("check %s = %s, want %s (%+v)", name, got[name], want, report.Checks)
+				}
+			}
+			if report.Passed() != tt.passed {
+				t.Errorf("Passed() = %v, want %v (%+v)", report.Passed(), tt.passed, report.Checks)
+			}
+		})
+	}
+}
+
+func TestReaderVerifyMissingMeta(t *testing.T) {
+	dbPath := createTestSnapshot(t, map[string][]byte{"/registry/secrets/default/a": []byte("a")})
+
+	reader, err := NewReader(dbPath)
+	if err != nil {
+		t.Fatalf("NewReader() error: %v", err)
+	}
+	defer reader.Close()
+
+	report, err := reader.Verify()
+	if err != nil {
+		t.Fatalf("Verify() error: %v", err)
+	}
+	for _, c := range report.Checks {
+		switch c.Name {
+		case CheckBuckets:
+			if c.Status != CheckFail || c.Detail != "missing meta bucket" {
+				t.Errorf("buckets check = %+v, want missing meta bucket", c)
+			}
+		case CheckRevisions:
+			if c.Status != CheckSkip {
+				t.Errorf("revisions check = %+v, want skipped", c)
+			}
+		}
+	}
+	if report.Passed() {
+		t.Error("Passed() = true without a meta bucket")
+	}
+}
@@ -0,0 +1,280 @@
+package etcdreader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Record types of the etcd write-ahead log
+const (
+	walMetadataType int64 = iota + 1
+	walEntryType
+	walStateType
+	walCRCType
+	walSnapshotType
+)
+
+// raftEntryNormal is the raft entry type of client requests, as opposed to
+// configuration changes
+const raftEntryNormal = 0
+
+// walCRCTable is the table of the CRCs chained through WAL records
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errWALRecord is returned for a record that cannot be decoded, which is
+// expected at the end of a WAL that was being written
+var errWALRecord = errors.New("invalid WAL record")
+
+// walEntry is a raft log entry read from the WAL
+type walEntry struct {
+	index uint64
+	term  uint64
+	typ   uint64
+	data  []byte
+}
+
+// walLog holds the committed entries of a WAL that follow an index
+type walLog struct {
+	// after is the index the entries follow
+	after uint64
+	// entries are committed, in index order
+	entries []walEntry
+	// uncommitted counts the entries written after the last commit
+	uncommitted int
+}
+
+// readWAL reads the entries of the WAL in dir that follow index after. The
+// WAL is only read, so it does not conflict with a running etcd, and a record
+// torn by a write in progress ends the last file.
+func readWAL(dir string, after uint64) (*walLog, error) {
+	names, err := walFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each file is named after the index of its first entry, so the files
+	// before the one holding after+1 are skipped
+	start := 0
+	for i, name := range names {
+		if first, ok := walFileIndex(name); ok && first <= after+1 {
+			start = i
+		}
+	}
+
+	var entries []walEntry
+	var commit uint64
+	var crc uint32
+	for i, name := range names[start:] {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read WAL: %w", err)
+		}
+		last := start+i == len(names)-1
+
+		for off := 0; off+8 <= len(data); {
+			lenField := binary.LittleEndian.Uint64(data[off:])
+			if lenField == 0 {
+				break // Preallocated space
+			}
+			// The record size is stored in the lower 56 bits and the
+			// padding in the lower 3 bits of the top byte when it is set
+			size := int(lenField &^ (0xff << 56))
+			pad := 0
+			if lenField&(1<<63) != 0 {
+				pad = int(lenField>>56) & 0x7
+			}
+			end := off + 8 + size + pad
+			if end > len(data) {
+				if last {
+					break
+				}
+				return nil, fmt.Errorf("%s at offset %d: %w", name, off, errWALRecord)
+			}
+
+			typ, recCRC, recData, err := decodeWALRecord(data[off+8 : off+8+size])
+			if err == nil {
+				err = checkWALCRC(typ, crc, recCRC, recData)
+			}
+			if err != nil {
+				if last {
+					break
+				}
+				return nil, fmt.Errorf("%s at offset %d: %w", name, off, err)
+			}
+			// Each record chains the CRC of the records before it; each file
+			// starts with the CRC the previous one ended with
+			crc = recCRC
+			off = end
+
+			switch typ {
+			case walEntryType:
+				e, err := decodeWALEntry(recData)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", name, err)
+				}
+				if e.index <= after {
+					continue
+				}
+				// A newer leader may overwrite entries that were never
+				// committed, which truncates the log at that index
+				pos := e.index - after - 1
+				if pos > uint64(len(entries)) {
+					return nil, fmt.Errorf("WAL is missing the entries from index %d to %d", after+uint64(len(entries))+1, e.index-1)
+				}
+				entries = append(entries[:pos], e)
+			case walStateType:
+				if c, err := decodeHardStateCommit(recData); err == nil && c > commit {
+					commit = c
+				}
+			}
+		}
+	}
+
+	log := &walLog{after: after}
+	for _, e := range entries {
+		if e.index > commit {
+			log.uncommitted++
+			continue
+		}
+		log.entries = append(log.entries, e)
+	}
+	return log, nil
+}
+
+// checkWALCRC checks the CRC of a record against crc, the CRC of the
+// records before it; the first file of a read starts the chain
+func checkWALCRC(typ int64, crc, recCRC uint32, data []byte) error {
+	if typ == walCRCType {
+		if crc != 0 && recCRC != crc {
+			return fmt.Errorf("%w: CRC mismatch", errWALRecord)
+		}
+		return nil
+	}
+	if crc32.Update(crc, walCRCTable, data) != recCRC {
+		return fmt.Errorf("%w: CRC mismatch", errWALRecord)
+	}
+	return nil
+}
+
+// walFiles lists the WAL files of dir in sequence order
+func walFiles(dir string) ([]string, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAL: %w", err)
+	}
+	var names []string
+	for _, e := range dirEntries {
+		if _, ok := walFileIndex(e.Name()); ok && e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// walFileIndex parses the index of the first entry from a WAL file name,
+// such as 0000000000000002-0000000000001b3c.wal
+func walFileIndex(name string) (uint64, bool) {
+	var seq, index uint64
+	if !strings.HasSuffix(name, ".wal") {
+		return 0, false
+	}
+	if _, err := fmt.Sscanf(name, "%016x-%016x.wal", &seq, &index); err != nil {
+		return 0, false
+	}
+	return index, true
+}
+
+// decodeWALRecord decodes the type, CRC and data of a walpb.Record
+func decodeWALRecord(b []byte) (typ int64, crc uint32, data []byte, err error) {
+	err = decodeFields(b, func(num protowire.Number, v uint64, bytes []byte) {
+		switch num {
+		case 1:
+			typ = int64(v)
+		case 2:
+			crc = uint32(v)
+		case 3:
+			data = bytes
+		}
+	})
+	if err == nil && (typ < walMetadataType || typ > walSnapshotType) {
+		err = fmt.Errorf("%w: unknown type %d", errWALRecord, typ)
+	}
+	return typ, crc, data, err
+}
+
+// decodeWALEntry decodes a raftpb.Entry
+func decodeWALEntry(b []byte) (walEntry, error) {
+	var e walEntry
+	err := decodeFields(b, func(num protowire.Number, v uint64, bytes []byte) {
+		switch num {
+		case 1:
+			e.typ = v
+		case 2:
+			e.term = v
+		case 3:
+			e.index = v
+		case 4:
+			e.data = bytes
+		}
+	})
+	if err != nil {
+		return e, fmt.Errorf("invalid WAL entry: %w", err)
+	}
+	return e, nil
+}
+
+// decodeHardStateCommit decodes the commit index of a raftpb.HardState
+func decodeHardStateCommit(b []byte) (uint64, error) {
+	var commit uint64
+	err := decodeFields(b, func(num protowire.Number, v uint64, bytes []byte) {
+		if num == 3 {
+			commit = v
+		}
+	})
+	return commit, err
+}
+
+// decodeFields calls fn for each varint and bytes field of a protobuf
+// message, the only kinds the WAL messages use; other fields are skipped
+func decodeFields(b []byte, fn func(num protowire.Number, v uint64, bytes []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("%w: %v", errWALRecord, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return fmt.Errorf("%w: %v", errWALRecord, protowire.ParseError(n))
+			}
+			fn(num, v, nil)
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return fmt.Errorf("%w: %v", errWALRecord, protowire.ParseError(n))
+			}
+			fn(num, 0, v)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return fmt.Errorf("%w: %v", errWALRecord, protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
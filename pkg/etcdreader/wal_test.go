@@ -0,0 +1,258 @@
+package etcdreader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// testWAL writes WAL files the way etcd does: framed walpb.Records with a
+// CRC chained through every file
+type testWAL struct {
+	dir string
+	seq uint64
+	buf []byte
+	crc uint32
+}
+
+func newTestWAL(t *testing.T, dir string) *testWAL {
+	t.Helper()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatalf("Failed to create WAL directory: %v", err)
+	}
+	return &testWAL{dir: dir}
+}
+
+// record appends a record to the current file
+func (w *testWAL) record(typ int64, data []byte) {
+	if typ != walCRCType {
+		w.crc = crc32.Update(w.crc, walCRCTable, data)
+	}
+
+	var rec []byte
+	rec = protowire.AppendTag(rec, 1, protowire.VarintType)
+	rec = protowire.AppendVarint(rec, uint64(typ))
+	rec = protowire.AppendTag(rec, 2, protowire.VarintType)
+	rec = protowire.AppendVarint(rec, uint64(w.crc))
+	if data != nil {
+		rec = protowire.AppendTag(rec, 3, protowire.BytesType)
+		rec = protowire.AppendBytes(rec, data)
+	}
+
+	lenField := uint64(len(rec))
+	pad := (8 - len(rec)%8) % 8
+	if pad > 0 {
+		lenField |= uint64(0x80|pad) << 56
+	}
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, lenField)
+	w.buf = append(w.buf, rec...)
+	w.buf = append(w.buf, make([]byte, pad)...)
+}
+
+// entry appends a normal raft entry
+func (w *testWAL) entry(index, term uint64, data []byte) {
+	var e []byte
+	e = protowire.AppendTag(e, 1, protowire.VarintType)
+	e = protowire.AppendVarint(e, raftEntryNormal)
+	e = protowire.AppendTag(e, 2, protowire.VarintType)
+	e = protowire.AppendVarint(e, term)
+	e = protowire.AppendTag(e, 3, protowire.VarintType)
+	e = protowire.AppendVarint(e, index)
+	if data != nil {
+		e = protowire.AppendTag(e, 4, protowire.BytesType)
+		e = protowire.AppendBytes(e, data)
+	}
+	w.record(walEntryType, e)
+}
+
+// commit appends a hard state committing index
+func (w *testWAL) commit(term, index uint64) {
+	var s []byte
+	s = protowire.AppendTag(s, 1, protowire.VarintType)
+	s = protowire.AppendVarint(s, term)
+	s = protowire.AppendTag(s, 3, protowire.VarintType)
+	s = protowire.AppendVarint(s, index)
+	w.record(walStateType, s)
+}
+
+// cut writes the current file, named after index, the index of its first
+// entry, and starts the next one with the CRC so far
+func (w *testWAL) cut(t *testing.T, index uint64) string {
+	t.Helper()
+
+	path := filepath.Join(w.dir, fmt.Sprintf("%016x-%016x.wal", w.seq, index))
+	// Files are preallocated, so they end with zeros
+	data := append(w.buf, make([]byte, 64)...)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write WAL file: %v", err)
+	}
+	w.seq++
+	w.buf = nil
+	w.record(walCRCType, nil)
+	return path
+}
+
+// start begins the first file, as etcd does when creating the WAL
+func (w *testWAL) start() {
+	w.record(walCRCType, nil)
+	w.record(walMetadataType, []byte("metadata"))
+	w.record(walSnapshotType, nil)
+}
+
+func walIndexes(log *walLog) []uint64 {
+	var indexes []uint64
+	for _, e := range log.entries {
+		indexes = append(indexes, e.index)
+	}
+	return indexes
+}
+
+func TestReadWAL(t *testing.T) {
+	tests := []struct {
+		name            string
+		write           func(t *testing.T, w *testWAL)
+		after           uint64
+		wantIndexes     []uint64
+		wantTerms       []uint64
+		wantUncommitted int
+		wantErr         bool
+	}{
+		{
+			name: "Committed entries after the index",
+			write: func(t *testing.T, w *testWAL) {
+				for i := uint64(1); i <= 5; i++ {
+					w.entry(i, 1, []byte("data"))
+				}
+				w.commit(1, 4)
+				w.cut(t, 0)
+			},
+			after:           2,
+			wantIndexes:     []uint64{3, 4},
+			wantUncommitted: 1,
+		},
+		{
+			name: "Entries overwritten by a new leader",
+			write: func(t *testing.T, w *testWAL) {
+				for i := uint64(1); i <= 4; i++ {
+					w.entry(i, 1, nil)
+				}
+				w.entry(3, 2, nil)
+				w.commit(2, 3)
+				w.cut(t, 0)
+			},
+			wantIndexes: []uint64{1, 2, 3},
+			wantTerms:   []uint64{1, 1, 2},
+		},
+		{
+			name: "Several files",
+			write: func(t *testing.T, w *testWAL) {
+				w.entry(1, 1, nil)
+				w.entry(2, 1, nil)
+				w.cut(t, 0)
+				w.entry(3, 1, nil)
+				w.commit(1, 3)
+				w.cut(t, 3)
+			},
+			after:       1,
+			wantIndexes: []uint64{2, 3},
+		},
+		{
+			name: "Later files only",
+			write: func(t *testing.T, w *testWAL) {
+				w.entry(1, 1, nil)
+				w.entry(2, 1, nil)
+				w.cut(t, 0)
+				w.entry(3, 1, nil)
+				w.entry(4, 1, nil)
+				w.commit(1, 4)
+				w.cut(t, 3)
+			},
+			after:       3,
+			wantIndexes: []uint64{4},
+		},
+		{
+			name: "Torn write at the end",
+			write: func(t *testing.T, w *testWAL) {
+				w.entry(1, 1, nil)
+				w.commit(1, 1)
+				w.entry(2, 1, []byte("torn"))
+				// The last record was only partly written
+				w.buf = w.buf[:len(w.buf)-4]
+				w.cut(t, 0)
+			},
+			wantIndexes: []uint64{1},
+		},
+		{
+			name: "Corrupt record before the last file",
+			write: func(t *testing.T, w *testWAL) {
+				w.entry(1, 1, []byte("data"))
+				w.buf[len(w.buf)-8] ^= 0xff
+				w.cut(t, 0)
+				w.entry(2, 1, nil)
+				w.commit(1, 2)
+				w.cut(t, 2)
+			},
+			wantErr: true,
+		},
+		{
+			name: "Entries missing after the index",
+			write: func(t *testing.T, w *testWAL) {
+				w.entry(10, 1, nil)
+				w.commit(1, 10)
+				w.cut(t, 10)
+			},
+			after:   5,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestWAL(t, t.TempDir())
+			w.start()
+			tt.write(t, w)
+
+			log, err := readWAL(w.dir, tt.after)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("readWAL() = %v, want an error", walIndexes(log))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readWAL() error: %v", err)
+			}
+
+			if got := fmt.Sprint(walIndexes(log)); got != fmt.Sprint(tt.wantIndexes) {
+				t.Errorf("entries = %s, want %v", got, tt.wantIndexes)
+			}
+			for i, term := range tt.wantTerms {
+				if log.entries[i].term != term {
+					t.Errorf("entry %d term = %d, want %d", log.entries[i].index, log.entries[i].term, term)
+				}
+			}
+			if log.uncommitted != tt.wantUncommitted {
+				t.Errorf("uncommitted = %d, want %d", log.uncommitted, tt.wantUncommitted)
+			}
+		})
+	}
+}
+
+func TestReadWALErrors(t *testing.T) {
+	if _, err := readWAL(filepath.Join(t.TempDir(), "missing"), 0); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("readWAL() of a missing directory error = %v, want not exist", err)
+	}
+
+	// A directory without WAL files has no entries
+	log, err := readWAL(t.TempDir(), 0)
+	if err != nil || len(log.entries) != 0 {
+		t.Errorf("readWAL() of an empty directory = %v, %v, want no entries", walIndexes(log), err)
+	}
+}
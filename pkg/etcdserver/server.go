@@ -0,0 +1,281 @@
+package etcdserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/codanael/etcd-secret-reader/pkg/etcdreader"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/api/v3/version"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errReadOnly is returned by every RPC that would modify the snapshot
+var errReadOnly = status.Error(codes.Unavailable, "etcdserver: read-only snapshot server")
+
+// Server answers the etcd v3 KV and Maintenance RPCs from a snapshot, so
+// etcdctl and other etcd clients can query it like a running member
+type Server struct {
+	pb.UnimplementedKVServer
+	pb.UnimplementedMaintenanceServer
+
+	reader *etcdreader.Reader
+}
+
+// NewServer creates a server reading from reader
+func NewServer(reader *etcdreader.Reader) *Server {
+	return &Server{reader: reader}
+}
+
+// Register registers the KV and Maintenance services on g
+func (s *Server) Register(g *grpc.Server) {
+	pb.RegisterKVServer(g, s)
+	pb.RegisterMaintenanceServer(g, s)
+}
+
+// header returns the response header; a snapshot has no live raft state, so
+// only the revision and the term of the last applied entry are filled in
+func (s *Server) header() (*pb.ResponseHeader, *etcdreader.Metadata, error) {
+	md, err := s.reader.Metadata()
+	if err != nil {
+		return nil, nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.ResponseHeader{Revision: md.CurrentRevision, RaftTerm: md.Term}, md, nil
+}
+
+// Range reads keys as etcd does, including past revisions still present in
+// the snapshot, sorting, limits and revision filters
+func (s *Server) Range(ctx context.Context, req *pb.RangeRequest) (*pb.RangeResponse, error) {
+	header, _, err := s.header()
+	if err != nil {
+		return nil, err
+	}
+
+	kvs, err := s.reader.Range(string(req.Key), string(req.RangeEnd), req.Revision)
+	switch {
+	case errors.Is(err, etcdreader.ErrCompacted):
+		return nil, rpctypes.ErrGRPCCompacted
+	case errors.Is(err, etcdreader.ErrFutureRevision):
+		return nil, rpctypes.ErrGRPCFutureRev
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &pb.RangeResponse{Header: header, Count: int64(len(kvs))}
+	kvs = filterKVs(kvs, req)
+	sortKVs(kvs, req.SortTarget, req.SortOrder)
+
+	if req.Limit > 0 && int64(len(kvs)) > req.Limit {
+		kvs = kvs[:req.Limit]
+		resp.More = true
+	}
+	if req.CountOnly {
+		return resp, nil
+	}
+	if req.KeysOnly {
+		for _, kv := range kvs {
+			kv.Value = nil
+		}
+	}
+
+	resp.Kvs = kvs
+	return resp, nil
+}
+
+// filterKVs applies the min and max revision filters of a range request
+func filterKVs(kvs []*mvccpb.KeyValue, req *pb.RangeRequest) []*mvccpb.KeyValue {
+	filtered := kvs[:0]
+	for _, kv := range kvs {
+		if req.MinModRevision > 0 && kv.ModRevision < req.MinModRevision ||
+			req.MaxModRevision > 0 && kv.ModRevision > req.MaxModRevision ||
+			req.MinCreateRevision > 0 && kv.CreateRevision < req.MinCreateRevision ||
+			req.MaxCreateRevision > 0 && kv.CreateRevision > req.MaxCreateRevision {
+			continue
+		}
+		filtered = append(filtered, kv)
+	}
+	return filtered
+}
+
+// sortKVs sorts kvs in place; keys are already in ascending order, which is
+// also what etcd returns when no order is requested
+func sortKVs(kvs []*mvccpb.KeyValue, target pb.RangeRequest_SortTarget, order pb.RangeRequest_SortOrder) {
+	if order == pb.RangeRequest_NONE {
+		return
+	}
+
+	var less func(a, b *mvccpb.KeyValue) bool
+	switch target {
+	case pb.RangeRequest_VERSION:
+		less = func(a, b *mvccpb.KeyValue) bool { return a.Version < b.Version }
+	case pb.RangeRequest_CREATE:
+		less = func(a, b *mvccpb.KeyValue) bool { return a.CreateRevision < b.CreateRevision }
+	case pb.RangeRequest_MOD:
+		less = func(a, b *mvccpb.KeyValue) bool { return a.ModRevision < b.ModRevision }
+	case pb.RangeRequest_VALUE:
+		less = func(a, b *mvccpb.KeyValue) bool { return bytes.Compare(a.Value, b.Value) < 0 }
+	default:
+		less = func(a, b *mvccpb.KeyValue) bool { return bytes.Compare(a.Key, b.Key) < 0 }
+	}
+
+	if order == pb.RangeRequest_DESCEND {
+		sort.SliceStable(kvs, func(i, j int) bool { return less(kvs[j], kvs[i]) })
+	} else {
+		sort.SliceStable(kvs, func(i, j int) bool { return less(kvs[i], kvs[j]) })
+	}
+}
+
+// Put is rejected, the snapshot is read-only
+func (s *Server) Put(ctx context.Context, req *pb.PutRequest) (*pb.PutResponse, error) {
+	return nil, errReadOnly
+}
+
+// DeleteRange is rejected, the snapshot is read-only
+func (s *Server) DeleteRange(ctx context.Context, req *pb.DeleteRangeRequest) (*pb.DeleteRangeResponse, error) {
+	return nil, errReadOnly
+}
+
+// Txn answers transactions that only read, such as etcdctl txn with
+// comparisons and gets; a transaction with a put or delete in either branch
+// is rejected, whatever its comparisons pick
+func (s *Server) Txn(ctx context.Context, req *pb.TxnRequest) (*pb.TxnResponse, error) {
+	if !readOnlyTxn(req) {
+		return nil, errReadOnly
+	}
+	return s.txn(ctx, req)
+}
+
+// txn evaluates the comparisons of a read-only transaction and runs the
+// ranges of the branch they pick
+func (s *Server) txn(ctx context.Context, req *pb.TxnRequest) (*pb.TxnResponse, error) {
+	header, _, err := s.header()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.TxnResponse{Header: header, Succeeded: true}
+	for _, c := range req.Compare {
+		ok, err := s.compare(c)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			resp.Succeeded = false
+			break
+		}
+	}
+
+	ops := req.Success
+	if !resp.Succeeded {
+		ops = req.Failure
+	}
+	for _, op := range ops {
+		switch r := op.Request.(type) {
+		case *pb.RequestOp_RequestRange:
+			rangeResp, err := s.Range(ctx, r.RequestRange)
+			if err != nil {
+				return nil, err
+			}
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{ResponseRange: rangeResp}})
+		case *pb.RequestOp_RequestTxn:
+			txnResp, err := s.txn(ctx, r.RequestTxn)
+			if err != nil {
+				return nil, err
+			}
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseTxn{ResponseTxn: txnResp}})
+		}
+	}
+	return resp, nil
+}
+
+// compare evaluates a transaction comparison against every key in its
+// range at the latest revision
+func (s *Server) compare(c *pb.Compare) (bool, error) {
+	kvs, err := s.reader.Range(string(c.Key), string(c.RangeEnd), 0)
+	if err != nil {
+		return false, status.Error(codes.Internal, err.Error())
+	}
+	if len(kvs) == 0 {
+		// A missing key has no value to compare, but zero revisions
+		return c.Target != pb.Compare_VALUE && etcdreader.CompareKeyValue(c, &mvccpb.KeyValue{}), nil
+	}
+	for _, kv := range kvs {
+		if !etcdreader.CompareKeyValue(c, kv) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// readOnlyTxn reports whether neither branch of a transaction, nor of its
+// nested transactions, writes
+func readOnlyTxn(req *pb.TxnRequest) bool {
+	for _, ops := range [][]*pb.RequestOp{req.Success, req.Failure} {
+		for _, op := range ops {
+			switch r := op.Request.(type) {
+			case *pb.RequestOp_RequestRange:
+			case *pb.RequestOp_RequestTxn:
+				if !readOnlyTxn(r.RequestTxn) {
+					return false
+				}
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Compact is rejected, the snapshot is read-only
+func (s *Server) Compact(ctx context.Context, req *pb.CompactionRequest) (*pb.CompactionResponse, error) {
+	return nil, errReadOnly
+}
+
+// Status describes the snapshot as if it were a single-member cluster
+func (s *Server) Status(ctx context.Context, req *pb.StatusRequest) (*pb.StatusResponse, error) {
+	header, md, err := s.header()
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := s.reader.Size()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.StatusResponse{
+		Header:           header,
+		Version:          version.Version,
+		DbSize:           size,
+		DbSizeInUse:      size,
+		RaftIndex:        md.ConsistentIndex,
+		RaftTerm:         md.Term,
+		RaftAppliedIndex: md.ConsistentIndex,
+	}, nil
+}
+
+// Alarm is rejected, the snapshot is read-only
+func (s *Server) Alarm(ctx context.Context, req *pb.AlarmRequest) (*pb.AlarmResponse, error) {
+	return nil, errReadOnly
+}
+
+// Defragment is rejected, the snapshot is read-only
+func (s *Server) Defragment(ctx context.Context, req *pb.DefragmentRequest) (*pb.DefragmentResponse, error) {
+	return nil, errReadOnly
+}
+
+// MoveLeader is rejected, a snapshot has no leader
+func (s *Server) MoveLeader(ctx context.Context, req *pb.MoveLeaderRequest) (*pb.MoveLeaderResponse, error) {
+	return nil, errReadOnly
+}
+
+// Downgrade is rejected, the snapshot is read-only
+func (s *Server) Downgrade(ctx context.Context, req *pb.DowngradeRequest) (*pb.DowngradeResponse, error) {
+	return nil, errReadOnly
+}
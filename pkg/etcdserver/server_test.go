@@ -0,0 +1,337 @@
+package etcdserver
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codanael/etcd-secret-reader/pkg/etcdreader"
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/mvcc/buckets"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// op is a put or delete applied to a test snapshot, one main revision each
+type op struct {
+	key, value string
+	delete     bool
+}
+
+// createSnapshot writes ops the way etcd records them and marks compactRev
+// as the last finished compaction
+func createSnapshot(t *testing.T, ops []op, compactRev int64) string {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "snapshot.db")
+	db, err := bolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(buckets.Key.Name())
+		if err != nil {
+			return err
+		}
+		created := make(map[string]int64)
+		versions := make(map[string]int64)
+
+		for i, o := range ops {
+			rev := int64(i + 1)
+			revBytes := revisionBytes(rev)
+
+			kv := &mvccpb.KeyValue{Key: []byte(o.key)}
+			if o.delete {
+				revBytes = append(revBytes, 't')
+				delete(created, o.key)
+				delete(versions, o.key)
+			} else {
+				if _, ok := created[o.key]; !ok {
+					created[o.key] = rev
+				}
+				versions[o.key]++
+				kv.Value = []byte(o.value)
+				kv.CreateRevision = created[o.key]
+				kv.ModRevision = rev
+				kv.Version = versions[o.key]
+			}
+
+			data, err := kv.Marshal()
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(revBytes, data); err != nil {
+				return err
+			}
+		}
+
+		meta, err := tx.CreateBucketIfNotExists(buckets.Meta.Name())
+		if err != nil {
+			return err
+		}
+		term := make([]byte, 8)
+		binary.BigEndian.PutUint64(term, 3)
+		if err := meta.Put(buckets.MetaTermKeyName, term); err != nil {
+			return err
+		}
+		if compactRev > 0 {
+			return meta.Put([]byte("finishedCompactRev"), revisionBytes(compactRev))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to populate test database: %v", err)
+	}
+
+	return dbPath
+}
+
+func revisionBytes(main int64) []byte {
+	b := make([]byte, 17, 18)
+	binary.BigEndian.PutUint64(b[0:8], uint64(main))
+	b[8] = '_'
+	return b
+}
+
+// startServer serves the snapshot on a local listener and returns a client
+func startServer(t *testing.T, dbPath string) *clientv3.Client {
+	t.Helper()
+
+	reader, err := etcdreader.NewReader(dbPath)
+	if err != nil {
+		t.Fatalf("NewReader() error: %v", err)
+	}
+	t.Cleanup(func() { reader.Close() })
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	g := grpc.NewServer()
+	NewServer(reader).Register(g)
+	go g.Serve(lis)
+	t.Cleanup(g.Stop)
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{lis.Addr().String()},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("clientv3.New() error: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+var testOps = []op{
+	{key: "/registry/secrets/default/a", value: "a1"},    // rev 1
+	{key: "/registry/secrets/default/b", value: "b1"},    // rev 2
+	{key: "/registry/configmaps/default/c", value: "c1"}, // rev 3
+	{key: "/registry/secrets/default/a", value: "a2"},    // rev 4
+	{key: "/registry/secrets/default/b", delete: true},   // rev 5
+	{key: "/registry/secrets/prod/d", value: "d1"},       // rev 6
+}
+
+func keysOf(resp *clientv3.GetResponse) []string {
+	var keys []string
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys
+}
+
+func TestRange(t *testing.T) {
+	client := startServer(t, createSnapshot(t, testOps, 0))
+	ctx := context.Background()
+
+	resp, err := client.Get(ctx, "/registry/secrets/default/a")
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != "a2" {
+		t.Fatalf("Get() = %v, want a2", resp.Kvs)
+	}
+	kv := resp.Kvs[0]
+	if kv.CreateRevision != 1 || kv.ModRevision != 4 || kv.Version != 2 {
+		t.Errorf("Get() record = create %d mod %d version %d, want 1, 4, 2", kv.CreateRevision, kv.ModRevision, kv.Version)
+	}
+	if resp.Header.Revision != 6 || resp.Header.RaftTerm != 3 {
+		t.Errorf("header = revision %d term %d, want 6 and 3", resp.Header.Revision, resp.Header.RaftTerm)
+	}
+
+	resp, err = client.Get(ctx, "/registry/secrets/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatalf("Get(prefix) error: %v", err)
+	}
+	want := []string{"/registry/secrets/default/a", "/registry/secrets/prod/d"}
+	if got := keysOf(resp); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Get(prefix) keys = %v, want %v", got, want)
+	}
+
+	resp, err = client.Get(ctx, "/registry/secrets/default/missing")
+	if err != nil || len(resp.Kvs) != 0 || resp.Count != 0 {
+		t.Errorf("Get(missing) = %v, %v, want no keys", resp, err)
+	}
+
+	resp, err = client.Get(ctx, "\x00", clientv3.WithFromKey(), clientv3.WithCountOnly())
+	if err != nil || resp.Count != 3 || len(resp.Kvs) != 0 {
+		t.Errorf("Get(count) = %v, %v, want count 3", resp, err)
+	}
+}
+
+func TestRangeAtRevision(t *testing.T) {
+	client := startServer(t, createSnapshot(t, testOps, 2))
+	ctx := context.Background()
+
+	resp, err := client.Get(ctx, "/registry/secrets/", clientv3.WithPrefix(), clientv3.WithRev(3))
+	if err != nil {
+		t.Fatalf("Get(rev 3) error: %v", err)
+	}
+	want := []string{"/registry/secrets/default/a", "/registry/secrets/default/b"}
+	if got := keysOf(resp); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Get(rev 3) keys = %v, want %v", got, want)
+	}
+	if string(resp.Kvs[0].Value) != "a1" {
+		t.Errorf("Get(rev 3) value = %q, want a1", resp.Kvs[0].Value)
+	}
+
+	if _, err := client.Get(ctx, "/registry/secrets/default/a", clientv3.WithRev(1)); !errors.Is(err, rpctypes.ErrCompacted) {
+		t.Errorf("Get(compacted) error = %v, want %v", err, rpctypes.ErrCompacted)
+	}
+	if _, err := client.Get(ctx, "/registry/secrets/default/a", clientv3.WithRev(99)); !errors.Is(err, rpctypes.ErrFutureRev) {
+		t.Errorf("Get(future) error = %v, want %v", err, rpctypes.ErrFutureRev)
+	}
+}
+
+func TestRangeSortAndLimit(t *testing.T) {
+	client := startServer(t, createSnapshot(t, testOps, 0))
+	ctx := context.Background()
+
+	resp, err := client.Get(ctx, "/registry/", clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByModRevision, clientv3.SortDescend), clientv3.WithLimit(2))
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	want := []string{"/registry/secrets/prod/d", "/registry/secrets/default/a"}
+	if got := keysOf(resp); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Get() keys = %v, want %v", got, want)
+	}
+	if !resp.More || resp.Count != 3 {
+		t.Errorf("Get() more = %v count = %d, want true and 3", resp.More, resp.Count)
+	}
+
+	resp, err = client.Get(ctx, "/registry/", clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithMinModRev(4))
+	if err != nil {
+		t.Fatalf("Get(keys only) error: %v", err)
+	}
+	if len(resp.Kvs) != 2 || resp.Kvs[0].Value != nil {
+		t.Errorf("Get(keys only) = %v, want two keys without values", resp.Kvs)
+	}
+}
+
+func TestWritesUnavailable(t *testing.T) {
+	client := startServer(t, createSnapshot(t, testOps, 0))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.Put(ctx, "/registry/secrets/default/a", "changed")
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Put() error = %v, want Unavailable", err)
+	}
+	_, err = client.Delete(ctx, "/registry/secrets/default/a")
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Delete() error = %v, want Unavailable", err)
+	}
+
+	// A transaction that may write is rejected, whichever branch it takes
+	_, err = client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision("/registry/secrets/default/a"), "=", 1)).
+		Then(clientv3.OpPut("/registry/secrets/default/a", "changed")).
+		Else(clientv3.OpGet("/registry/secrets/default/a")).
+		Commit()
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Txn(put) error = %v, want Unavailable", err)
+	}
+
+	resp, err := client.Get(ctx, "/registry/secrets/default/a")
+	if err != nil || string(resp.Kvs[0].Value) != "a2" {
+		t.Errorf("Get() after writes = %v, %v, want a2", resp, err)
+	}
+}
+
+func TestReadOnlyTxn(t *testing.T) {
+	client := startServer(t, createSnapshot(t, testOps, 0))
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		cmp       clientv3.Cmp
+		succeeded bool
+	}{
+		{name: "Value", cmp: clientv3.Compare(clientv3.Value("/registry/secrets/default/a"), "=", "a2"), succeeded: true},
+		{name: "Mod revision", cmp: clientv3.Compare(clientv3.ModRevision("/registry/secrets/default/a"), ">", 4)},
+		{name: "Missing key", cmp: clientv3.Compare(clientv3.CreateRevision("/registry/secrets/default/b"), "=", 0), succeeded: true},
+		{name: "Prefix", cmp: clientv3.Compare(clientv3.Version("/registry/secrets/"), ">", 0).WithPrefix(), succeeded: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Txn(ctx).
+				If(tt.cmp).
+				Then(clientv3.OpGet("/registry/secrets/default/a")).
+				Else(clientv3.OpGet("/registry/secrets/", clientv3.WithPrefix(), clientv3.WithCountOnly())).
+				Commit()
+			if err != nil {
+				t.Fatalf("Txn() error: %v", err)
+			}
+			if resp.Succeeded != tt.succeeded || resp.Header.Revision != 6 || len(resp.Responses) != 1 {
+				t.Fatalf("Txn() = succeeded %v at %d with %d responses, want %v at 6 with 1", resp.Succeeded, resp.Header.Revision, len(resp.Responses), tt.succeeded)
+			}
+
+			got := resp.Responses[0].GetResponseRange()
+			if tt.succeeded && (len(got.Kvs) != 1 || string(got.Kvs[0].Value) != "a2") {
+				t.Errorf("Then get = %v, want a2", got.Kvs)
+			}
+			if !tt.succeeded && (got.Count != 2 || len(got.Kvs) != 0) {
+				t.Errorf("Else get = count %d with %d keys, want count 2", got.Count, len(got.Kvs))
+			}
+		})
+	}
+
+	// Nested transactions only read too
+	resp, err := client.Txn(ctx).Then(clientv3.OpTxn(
+		[]clientv3.Cmp{clientv3.Compare(clientv3.Value("/registry/secrets/prod/d"), "=", "d1")},
+		[]clientv3.Op{clientv3.OpGet("/registry/secrets/prod/d")},
+		nil,
+	)).Commit()
+	if err != nil {
+		t.Fatalf("Txn(nested) error: %v", err)
+	}
+	nested := resp.Responses[0].GetResponseTxn()
+	if !nested.Succeeded || string(nested.Responses[0].GetResponseRange().Kvs[0].Value) != "d1" {
+		t.Errorf("Txn(nested) = %v, want d1", nested)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	client := startServer(t, createSnapshot(t, testOps, 0))
+
+	resp, err := client.Status(context.Background(), client.Endpoints()[0])
+	if err != nil {
+		t.Fatalf("Status() error: %v", err)
+	}
+	if resp.Header.Revision != 6 || resp.RaftTerm != 3 || resp.DbSize == 0 || resp.Version == "" {
+		t.Errorf("Status() = %+v", resp)
+	}
+}
@@ -0,0 +1,502 @@
+package kubeserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codanael/etcd-secret-reader/pkg/decrypt"
+	"github.com/codanael/etcd-secret-reader/pkg/etcdreader"
+	"github.com/codanael/etcd-secret-reader/pkg/redact"
+	"github.com/codanael/etcd-secret-reader/pkg/resource"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// servedGroups are the API groups served from the snapshot
+var servedGroups = []string{"", "apps"}
+
+// shortNames are the kubectl short names of the served resources
+var shortNames = map[string][]string{
+	"configmaps":             {"cm"},
+	"endpoints":              {"ep"},
+	"events":                 {"ev"},
+	"limitranges":            {"limits"},
+	"namespaces":             {"ns"},
+	"nodes":                  {"no"},
+	"persistentvolumeclaims": {"pvc"},
+	"persistentvolumes":      {"pv"},
+	"pods":                   {"po"},
+	"replicationcontrollers": {"rc"},
+	"resourcequotas":         {"quota"},
+	"serviceaccounts":        {"sa"},
+	"services":               {"svc"},
+	"daemonsets":             {"ds"},
+	"deployments":            {"deploy"},
+	"replicasets":            {"rs"},
+	"statefulsets":           {"sts"},
+}
+
+// Options configures a Server
+type Options struct {
+	// Revision serves the snapshot as it was at this MVCC revision; 0 serves
+	// the latest revision
+	Revision int64
+	// Decryptor returns the decryptor for a group resource such as "secrets"
+	// or "deployments.apps"; values are served as stored when it is nil
+	Decryptor func(groupResource string) (decrypt.ValueDecryptor, error)
+	// Redaction masks the secret values it does not reveal; secrets are
+	// served in cleartext when it is nil
+	Redaction *redact.Policy
+}
+
+// apiResource is a resource served by the facade
+type apiResource struct {
+	info resource.Info
+	kind string
+}
+
+// Server serves a read-only subset of the Kubernetes REST API from a
+// snapshot: discovery, and get and list of the core and apps resources
+type Server struct {
+	reader *etcdreader.Reader
+	opts   Options
+
+	// resources holds the served resources of each group version
+	resources map[schema.GroupVersion][]apiResource
+
+	mu         sync.Mutex
+	decryptors map[string]decrypt.ValueDecryptor
+}
+
+// NewServer creates a server reading from reader
+func NewServer(reader *etcdreader.Reader, opts Options) (*Server, error) {
+	s := &Server{
+		reader:     reader,
+		opts:       opts,
+		resources:  make(map[schema.GroupVersion][]apiResource),
+		decryptors: make(map[string]decrypt.ValueDecryptor),
+	}
+
+	for _, info := range resource.Builtin() {
+		if !served(info.GVR.Group) {
+			continue
+		}
+		kind, err := kindFor(info.GVR)
+		if err != nil {
+			return nil, err
+		}
+		gv := info.GVR.GroupVersion()
+		s.resources[gv] = append(s.resources[gv], apiResource{info: info, kind: kind})
+	}
+
+	return s, nil
+}
+
+func served(group string) bool {
+	for _, g := range servedGroups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackAPIVersion is the Kubernetes version reported when the version of
+// the k8s.io/api module is not in the build information
+const fallbackAPIVersion = "v1.34.1"
+
+// serverVersion reports the Kubernetes version whose API types the server
+// uses, which k8s.io/api v0.X.Y releases as 1.X.Y, so kubectl does not warn
+// about version skew
+func serverVersion() version.Info {
+	gitVersion := fallbackAPIVersion
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "k8s.io/api" && strings.HasPrefix(dep.Version, "v0.") {
+				gitVersion = "v1." + strings.TrimPrefix(dep.Version, "v0.")
+			}
+		}
+	}
+
+	info := version.Info{GitVersion: gitVersion + "-snapshot", Platform: "etcd-secret-reader"}
+	parts := strings.SplitN(strings.TrimPrefix(gitVersion, "v"), ".", 3)
+	if len(parts) >= 2 {
+		info.Major, info.Minor = parts[0], parts[1]
+	}
+	return info
+}
+
+// kindFor finds the kind of a built-in resource in the client-go scheme
+func kindFor(gvr schema.GroupVersionResource) (string, error) {
+	for kind := range scheme.Scheme.KnownTypes(gvr.GroupVersion()) {
+		plural, _ := meta.UnsafeGuessKindToResource(gvr.GroupVersion().WithKind(kind))
+		// Guessing fails for kinds that are already plural, such as Endpoints
+		if plural.Resource == gvr.Resource || strings.ToLower(kind) == gvr.Resource {
+			return kind, nil
+		}
+	}
+	return "", fmt.Errorf("no kind registered for %s", gvr)
+}
+
+// Close releases the plugin connections held by KMS decryptors
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, decryptor := range s.decryptors {
+		if closer, ok := decryptor.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	s.decryptors = make(map[string]decrypt.ValueDecryptor)
+	return nil
+}
+
+// decryptor returns the decryptor of a group resource, building it once
+func (s *Server) decryptor(groupResource string) (decrypt.ValueDecryptor, error) {
+	if s.opts.Decryptor == nil {
+		return decrypt.IdentityDecryptor{}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d, ok := s.decryptors[groupResource]; ok {
+		return d, nil
+	}
+	d, err := s.opts.Decryptor(groupResource)
+	if err != nil {
+		return nil, err
+	}
+	s.decryptors[groupResource] = d
+	return d, nil
+}
+
+// ServeHTTP routes discovery and resource requests
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, statusError(http.StatusMethodNotAllowed, metav1.StatusReasonMethodNotAllowed, "the snapshot is read-only"))
+		return
+	}
+	if watch := r.URL.Query().Get("watch"); watch == "true" || watch == "1" {
+		writeError(w, statusError(http.StatusMethodNotAllowed, metav1.StatusReasonMethodNotAllowed, "watch is not supported on a snapshot"))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "version":
+		writeJSON(w, http.StatusOK, serverVersion())
+	case len(parts) == 1 && parts[0] == "api":
+		s.serveCoreVersions(w, r)
+	case len(parts) == 1 && parts[0] == "apis":
+		s.serveGroupList(w)
+	case len(parts) == 2 && parts[0] == "apis":
+		s.serveGroup(w, parts[1])
+	case len(parts) >= 2 && parts[0] == "api":
+		s.serveGroupVersion(w, r, schema.GroupVersion{Version: parts[1]}, parts[2:])
+	case len(parts) >= 3 && parts[0] == "apis":
+		s.serveGroupVersion(w, r, schema.GroupVersion{Group: parts[1], Version: parts[2]}, parts[3:])
+	default:
+		writeError(w, apierrors.NewNotFound(schema.GroupResource{}, r.URL.Path))
+	}
+}
+
+func (s *Server) serveCoreVersions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &metav1.APIVersions{
+		TypeMeta: metav1.TypeMeta{Kind: "APIVersions"},
+		Versions: []string{"v1"},
+		ServerAddressByClientCIDRs: []metav1.ServerAddressByClientCIDR{
+			{ClientCIDR: "0.0.0.0/0", ServerAddress: r.Host},
+		},
+	})
+}
+
+func (s *Server) serveGroupList(w http.ResponseWriter) {
+	list := &metav1.APIGroupList{TypeMeta: metav1.TypeMeta{Kind: "APIGroupList", APIVersion: "v1"}}
+	for _, group := range servedGroups {
+		if group != "" {
+			list.Groups = append(list.Groups, s.apiGroup(group))
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) serveGroup(w http.ResponseWriter, group string) {
+	if group == "" || !served(group) {
+		writeError(w, apierrors.NewNotFound(schema.GroupResource{}, group))
+		return
+	}
+	g := s.apiGroup(group)
+	writeJSON(w, http.StatusOK, &g)
+}
+
+// apiGroup describes a served group and its versions
+func (s *Server) apiGroup(group string) metav1.APIGroup {
+	g := metav1.APIGroup{TypeMeta: metav1.TypeMeta{Kind: "APIGroup", APIVersion: "v1"}, Name: group}
+	for gv := range s.resources {
+		if gv.Group == group {
+			g.Versions = append(g.Versions, metav1.GroupVersionForDiscovery{GroupVersion: gv.String(), Version: gv.Version})
+		}
+	}
+	sort.Slice(g.Versions, func(i, j int) bool { return g.Versions[i].Version < g.Versions[j].Version })
+	if len(g.Versions) > 0 {
+		g.PreferredVersion = g.Versions[0]
+	}
+	return g
+}
+
+// serveGroupVersion serves discovery of a group version or one of these:
+// <resource>, <resource>/<name> for cluster-scoped resources, and
+// namespaces/<namespace>/<resource>[/<name>] for namespaced resources
+func (s *Server) serveGroupVersion(w http.ResponseWriter, r *http.Request, gv schema.GroupVersion, path []string) {
+	resources, ok := s.resources[gv]
+	if !ok {
+		writeError(w, apierrors.NewNotFound(schema.GroupResource{}, gv.String()))
+		return
+	}
+
+	var namespace, resourceName, name string
+	switch {
+	case len(path) == 0:
+		s.serveResourceList(w, gv, resources)
+		return
+	case len(path) <= 2:
+		resourceName = path[0]
+		if len(path) == 2 {
+			name = path[1]
+		}
+	case len(path) <= 4 && path[0] == "namespaces":
+		namespace, resourceName = path[1], path[2]
+		if len(path) == 4 {
+			name = path[3]
+		}
+	default:
+		writeError(w, apierrors.NewNotFound(schema.GroupResource{}, r.URL.Path))
+		return
+	}
+
+	var res *apiResource
+	for i := range resources {
+		if resources[i].info.GVR.Resource == resourceName {
+			res = &resources[i]
+		}
+	}
+	// Namespaced objects are listed across namespaces at the top level, but
+	// only addressed by name within their namespace
+	switch {
+	case res == nil,
+		!res.info.Namespaced && namespace != "",
+		res.info.Namespaced && namespace == "" && name != "":
+		writeError(w, apierrors.NewNotFound(schema.GroupResource{Group: gv.Group, Resource: resourceName}, name))
+		return
+	}
+
+	if name != "" {
+		s.serveGet(w, r, *res, namespace, name)
+	} else {
+		s.serveList(w, r, *res, namespace)
+	}
+}
+
+func (s *Server) serveResourceList(w http.ResponseWriter, gv schema.GroupVersion, resources []apiResource) {
+	list := &metav1.APIResourceList{
+		TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+		GroupVersion: gv.String(),
+	}
+	for _, res := range resources {
+		list.APIResources = append(list.APIResources, metav1.APIResource{
+			Name:         res.info.GVR.Resource,
+			SingularName: strings.ToLower(res.kind),
+			Namespaced:   res.info.Namespaced,
+			Kind:         res.kind,
+			Verbs:        metav1.Verbs{"get", "list"},
+			ShortNames:   shortNames[res.info.GVR.Resource],
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) serveGet(w http.ResponseWriter, r *http.Request, res apiResource, namespace, name string) {
+	gr := res.info.GVR.GroupResource()
+	for _, key := range res.info.Keys(namespace, name) {
+		kvs, err := s.reader.Range(key, "", s.opts.Revision)
+		if err != nil {
+			writeError(w, apierrors.NewInternalError(err))
+			return
+		}
+		if len(kvs) == 0 {
+			continue
+		}
+
+		obj, err := s.decode(res, string(kvs[0].Key), kvs[0].Value, kvs[0].ModRevision)
+		if err != nil {
+			writeError(w, apierrors.NewInternalError(err))
+			return
+		}
+		if wantsTable(r) {
+			writeJSON(w, http.StatusOK, s.table(res, []runtime.Object{obj}, strconv.FormatInt(kvs[0].ModRevision, 10)))
+			return
+		}
+		writeJSON(w, http.StatusOK, obj)
+		return
+	}
+	writeError(w, apierrors.NewNotFound(gr, name))
+}
+
+func (s *Server) serveList(w http.ResponseWriter, r *http.Request, res apiResource, namespace string) {
+	query := r.URL.Query()
+	labelSelector, err := labels.Parse(query.Get("labelSelector"))
+	if err != nil {
+		writeError(w, apierrors.NewBadRequest(err.Error()))
+		return
+	}
+	fieldSelector, err := fields.ParseSelector(query.Get("fieldSelector"))
+	if err != nil {
+		writeError(w, apierrors.NewBadRequest(err.Error()))
+		return
+	}
+
+	resourceVersion, err := s.resourceVersion()
+	if err != nil {
+		writeError(w, apierrors.NewInternalError(err))
+		return
+	}
+
+	var objs []runtime.Object
+	for _, prefix := range res.info.Prefixes() {
+		if namespace != "" {
+			prefix += namespace + "/"
+		}
+		kvs, err := s.reader.Range(prefix, etcdreader.PrefixEnd(prefix), s.opts.Revision)
+		if err != nil {
+			writeError(w, apierrors.NewInternalError(err))
+			return
+		}
+
+		for _, kv := range kvs {
+			obj, err := s.decode(res, string(kv.Key), kv.Value, kv.ModRevision)
+			if err != nil {
+				// One unreadable object should not hide the others
+				addWarning(w, fmt.Sprintf("skipping %s: %v", kv.Key, err))
+				continue
+			}
+
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				continue
+			}
+			objFields := fields.Set{"metadata.name": accessor.GetName(), "metadata.namespace": accessor.GetNamespace()}
+			if labelSelector.Matches(labels.Set(accessor.GetLabels())) && fieldSelector.Matches(objFields) {
+				objs = append(objs, obj)
+			}
+		}
+	}
+
+	if wantsTable(r) {
+		writeJSON(w, http.StatusOK, s.table(res, objs, resourceVersion))
+		return
+	}
+
+	// An empty list is sent as [] rather than null
+	items := append([]runtime.Object{}, objs...)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"apiVersion": res.info.GVR.GroupVersion().String(),
+		"kind":       res.kind + "List",
+		"metadata":   metav1.ListMeta{ResourceVersion: resourceVersion},
+		"items":      items,
+	})
+}
+
+// resourceVersion returns the revision the snapshot is served at
+func (s *Server) resourceVersion() (string, error) {
+	if s.opts.Revision != 0 {
+		return strconv.FormatInt(s.opts.Revision, 10), nil
+	}
+	rev, err := s.reader.Revision()
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(rev, 10), nil
+}
+
+// decode decrypts and decodes a stored object; like the API server, it sets
+// the resource version from the revision that last modified the key
+func (s *Server) decode(res apiResource, key string, value []byte, modRevision int64) (runtime.Object, error) {
+	decryptor, err := s.decryptor(res.info.GroupResource())
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := decryptor.DecryptValue(key, value)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt: %w", err)
+	}
+
+	obj, err := resource.Decode(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	accessor.SetResourceVersion(strconv.FormatInt(modRevision, 10))
+
+	if secret, ok := obj.(*corev1.Secret); ok && s.opts.Redaction != nil {
+		return s.opts.Redaction.Secret(secret), nil
+	}
+	return obj, nil
+}
+
+// wantsTable reports whether the client, such as kubectl get, asked for the
+// server-side table format
+func wantsTable(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "as=Table")
+}
+
+// addWarning sends a warning that kubectl prints to stderr
+func addWarning(w http.ResponseWriter, message string) {
+	w.Header().Add("Warning", "299 - "+strconv.Quote(message))
+}
+
+func statusError(code int32, reason metav1.StatusReason, message string) *apierrors.StatusError {
+	return &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    code,
+		Reason:  reason,
+		Message: message,
+	}}
+}
+
+func writeError(w http.ResponseWriter, err *apierrors.StatusError) {
+	status := err.ErrStatus
+	status.Kind = "Status"
+	status.APIVersion = "v1"
+	writeJSON(w, int(status.Code), &status)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
@@ -0,0 +1,355 @@
+package kubeserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/codanael/etcd-secret-reader/pkg/decrypt"
+	"github.com/codanael/etcd-secret-reader/pkg/etcdreader"
+	"github.com/codanael/etcd-secret-reader/pkg/redact"
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/server/v3/mvcc/buckets"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// storedObject is an object written to a test snapshot under key
+type storedObject struct {
+	key   string
+	obj   runtime.Object
+	keyID string // aescbc key name to encrypt with, or empty for plaintext
+}
+
+// encryptAESCBC encrypts plaintext the way the aescbc provider does
+func encryptAESCBC(t *testing.T, key []byte, keyName string, plaintext []byte) []byte {
+	t.Helper()
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("aes.NewCipher() error: %v", err)
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	iv := make([]byte, aes.BlockSize)
+	rand.Read(iv)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	out := []byte("k8s:enc:aescbc:v1:" + keyName + ":")
+	out = append(out, iv...)
+	return append(out, ciphertext...)
+}
+
+// createSnapshot stores objects as JSON, one revision each, encrypting
+// those with a keyID with keys[keyID]
+func createSnapshot(t *testing.T, objects []storedObject, keys map[string][]byte) string {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "snapshot.db")
+	db, err := bolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(buckets.Key.Name())
+		if err != nil {
+			return err
+		}
+		for i, o := range objects {
+			value, err := json.Marshal(o.obj)
+			if err != nil {
+				return err
+			}
+			if o.keyID != "" {
+				value = encryptAESCBC(t, keys[o.keyID], o.keyID, value)
+			}
+
+			rev := int64(i + 1)
+			revBytes := make([]byte, 17)
+			binary.BigEndian.PutUint64(revBytes[0:8], uint64(rev))
+			revBytes[8] = '_'
+
+			kv := &mvccpb.KeyValue{Key: []byte(o.key), Value: value, CreateRevision: rev, ModRevision: rev, Version: 1}
+			data, err := kv.Marshal()
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(revBytes, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to populate test database: %v", err)
+	}
+
+	return dbPath
+}
+
+func namespace(name string) *corev1.Namespace {
+	return &corev1.Namespace{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}
+}
+
+func secret(namespace, name, password string, labels map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       map[string][]byte{"password": []byte(password)},
+	}
+}
+
+// startServer serves a snapshot holding two namespaces, three secrets, one
+// of them encrypted with an unknown key, and a deployment
+func startServer(t *testing.T, redaction *redact.Policy) (*httptest.Server, kubernetes.Interface) {
+	t.Helper()
+
+	keys := map[string][]byte{"key1": make([]byte, 32), "lost": make([]byte, 32)}
+	rand.Read(keys["key1"])
+	rand.Read(keys["lost"])
+
+	dbPath := createSnapshot(t, []storedObject{
+		{key: "/registry/namespaces/default", obj: namespace("default")},
+		{key: "/registry/namespaces/prod", obj: namespace("prod")},
+		{key: "/registry/secrets/prod/db", obj: secret("prod", "db", "s3cret", map[string]string{"app": "db"}), keyID: "key1"},
+		{key: "/registry/secrets/default/token", obj: secret("default", "token", "t0ken", nil), keyID: "key1"},
+		{key: "/registry/secrets/default/old", obj: secret("default", "old", "gone", nil), keyID: "lost"},
+		{key: "/registry/deployments/default/web", obj: &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		}},
+	}, keys)
+
+	reader, err := etcdreader.NewReader(dbPath)
+	if err != nil {
+		t.Fatalf("NewReader() error: %v", err)
+	}
+	t.Cleanup(func() { reader.Close() })
+
+	keyring := decrypt.NewKeyring()
+	if err := keyring.Add("aescbc", "key1", keys["key1"]); err != nil {
+		t.Fatalf("Keyring.Add() error: %v", err)
+	}
+	server, err := NewServer(reader, Options{
+		Decryptor: func(groupResource string) (decrypt.ValueDecryptor, error) { return keyring, nil },
+		Redaction: redaction,
+	})
+	if err != nil {
+		t.Fatalf("NewServer() error: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	client, err := kubernetes.NewForConfig(&rest.Config{Host: ts.URL, QPS: -1, WarningHandler: rest.NoWarnings{}})
+	if err != nil {
+		t.Fatalf("NewForConfig() error: %v", err)
+	}
+	return ts, client
+}
+
+func TestGetAndList(t *testing.T) {
+	_, client := startServer(t, nil)
+	ctx := context.Background()
+
+	got, err := client.CoreV1().Secrets("prod").Get(ctx, "db", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get(secret) error: %v", err)
+	}
+	if string(got.Data["password"]) != "s3cret" || got.ResourceVersion != "3" {
+		t.Errorf("Get(secret) = %q at %s, want s3cret at 3", got.Data["password"], got.ResourceVersion)
+	}
+
+	secrets, err := client.CoreV1().Secrets("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("List(secrets) error: %v", err)
+	}
+	if len(secrets.Items) != 2 || secrets.ResourceVersion != "6" {
+		t.Errorf("List(secrets) = %d items at %s, want 2 at 6", len(secrets.Items), secrets.ResourceVersion)
+	}
+
+	secrets, err = client.CoreV1().Secrets("prod").List(ctx, metav1.ListOptions{LabelSelector: "app=db"})
+	if err != nil || len(secrets.Items) != 1 || secrets.Items[0].Name != "db" {
+		t.Errorf("List(prod, app=db) = %v, %v, want db", secrets, err)
+	}
+	secrets, err = client.CoreV1().Secrets("").List(ctx, metav1.ListOptions{FieldSelector: "metadata.namespace=default"})
+	if err != nil || len(secrets.Items) != 1 || secrets.Items[0].Name != "token" {
+		t.Errorf("List(metadata.namespace=default) = %v, %v, want token", secrets, err)
+	}
+
+	namespaces, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil || len(namespaces.Items) != 2 {
+		t.Errorf("List(namespaces) = %v, %v, want 2 namespaces", namespaces, err)
+	}
+	if _, err := client.CoreV1().Namespaces().Get(ctx, "prod", metav1.GetOptions{}); err != nil {
+		t.Errorf("Get(namespace) error: %v", err)
+	}
+
+	if _, err := client.AppsV1().Deployments("default").Get(ctx, "web", metav1.GetOptions{}); err != nil {
+		t.Errorf("Get(deployment) error: %v", err)
+	}
+
+	_, err = client.CoreV1().Secrets("prod").Get(ctx, "missing", metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("Get(missing) error = %v, want NotFound", err)
+	}
+	_, err = client.CoreV1().ConfigMaps("prod").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Errorf("List(configmaps) error = %v, want an empty list", err)
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	_, client := startServer(t, nil)
+	ctx := context.Background()
+
+	// Requests are served in parallel and share the keyring of the server
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				_, err := client.CoreV1().Secrets("").List(ctx, metav1.ListOptions{})
+				errs <- err
+				return
+			}
+			_, err := client.CoreV1().Secrets("prod").Get(ctx, "db", metav1.GetOptions{})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent request error: %v", err)
+		}
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	redaction, err := redact.NewPolicy(false, nil)
+	if err != nil {
+		t.Fatalf("NewPolicy() error: %v", err)
+	}
+	_, client := startServer(t, redaction)
+
+	got, err := client.CoreV1().Secrets("prod").Get(context.Background(), "db", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get(secret) error: %v", err)
+	}
+	if want := redact.Mask([]byte("s3cret")); string(got.Data["password"]) != want {
+		t.Errorf("Get(secret) password = %q, want %q", got.Data["password"], want)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	_, client := startServer(t, nil)
+	ctx := context.Background()
+
+	_, err := client.CoreV1().Secrets("prod").Create(ctx, secret("prod", "new", "x", nil), metav1.CreateOptions{})
+	if !apierrors.IsMethodNotSupported(err) {
+		t.Errorf("Create() error = %v, want MethodNotAllowed", err)
+	}
+	if _, err := client.CoreV1().Secrets("prod").Watch(ctx, metav1.ListOptions{}); !apierrors.IsMethodNotSupported(err) {
+		t.Errorf("Watch() error = %v, want MethodNotAllowed", err)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	_, client := startServer(t, nil)
+
+	groups, resources, err := client.Discovery().ServerGroupsAndResources()
+	if err != nil {
+		t.Fatalf("ServerGroupsAndResources() error: %v", err)
+	}
+	if len(groups) != 2 || groups[0].Name != "" || groups[1].Name != "apps" {
+		t.Errorf("groups = %v, want core and apps", groups)
+	}
+
+	found := map[string]metav1.APIResource{}
+	for _, list := range resources {
+		for _, r := range list.APIResources {
+			found[list.GroupVersion+"/"+r.Name] = r
+		}
+	}
+	if r := found["v1/secrets"]; r.Kind != "Secret" || !r.Namespaced {
+		t.Errorf("v1/secrets = %+v", r)
+	}
+	if r := found["v1/endpoints"]; r.Kind != "Endpoints" {
+		t.Errorf("v1/endpoints = %+v", r)
+	}
+	if r := found["apps/v1/deployments"]; r.Kind != "Deployment" || len(r.ShortNames) == 0 {
+		t.Errorf("apps/v1/deployments = %+v", r)
+	}
+
+	// kubectl compares the server version with its own to warn about skew
+	info, err := client.Discovery().ServerVersion()
+	if err != nil {
+		t.Fatalf("ServerVersion() error: %v", err)
+	}
+	v, err := utilversion.ParseSemantic(info.GitVersion)
+	if err != nil {
+		t.Fatalf("ServerVersion() git version %q: %v", info.GitVersion, err)
+	}
+	if info.Major != "1" || info.Minor != strconv.Itoa(int(v.Minor())) || v.Minor() < 34 {
+		t.Errorf("ServerVersion() = %s.%s (%s), want a current 1.x release", info.Major, info.Minor, info.GitVersion)
+	}
+}
+
+func TestTable(t *testing.T) {
+	ts, _ := startServer(t, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/secrets", nil)
+	req.Header.Set("Accept", "application/json;as=Table;v=v1;g=meta.k8s.io,application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var table metav1.Table
+	if err := json.NewDecoder(resp.Body).Decode(&table); err != nil {
+		t.Fatalf("decoding table: %v", err)
+	}
+	if table.Kind != "Table" || len(table.Rows) != 2 || len(table.ColumnDefinitions) != 4 {
+		t.Fatalf("table = %+v, want 2 rows and 4 columns", table)
+	}
+	if table.Rows[0].Cells[0] != "token" || table.Rows[0].Cells[1] != "Opaque" {
+		t.Errorf("first row = %v, want token Opaque", table.Rows[0].Cells)
+	}
+
+	// The secret encrypted with an unknown key is reported, not hidden
+	if warning := resp.Header.Get("Warning"); !strings.Contains(warning, "/registry/secrets/default/old") {
+		t.Errorf("Warning header = %q, want the undecryptable secret", warning)
+	}
+}
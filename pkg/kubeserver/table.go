@@ -0,0 +1,65 @@
+package kubeserver
+
+import (
+	"encoding/json"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// table renders objects in the format kubectl get asks for, with the name
+// and age of every object, and the type and data count of secrets
+func (s *Server) table(res apiResource, objs []runtime.Object, resourceVersion string) *metav1.Table {
+	table := &metav1.Table{
+		TypeMeta: metav1.TypeMeta{Kind: "Table", APIVersion: "meta.k8s.io/v1"},
+		ListMeta: metav1.ListMeta{ResourceVersion: resourceVersion},
+		ColumnDefinitions: []metav1.TableColumnDefinition{
+			{Name: "Name", Type: "string", Format: "name", Description: "Name of the object"},
+		},
+		Rows: []metav1.TableRow{},
+	}
+
+	isSecret := res.info.GroupResource() == "secrets"
+	if isSecret {
+		table.ColumnDefinitions = append(table.ColumnDefinitions,
+			metav1.TableColumnDefinition{Name: "Type", Type: "string", Description: "Type of the secret"},
+			metav1.TableColumnDefinition{Name: "Data", Type: "integer", Description: "Number of data keys"},
+		)
+	}
+	table.ColumnDefinitions = append(table.ColumnDefinitions,
+		metav1.TableColumnDefinition{Name: "Age", Type: "string", Description: "Time since the object was created"})
+
+	for _, obj := range objs {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			continue
+		}
+
+		cells := []interface{}{accessor.GetName()}
+		if secret, ok := obj.(*corev1.Secret); ok && isSecret {
+			cells = append(cells, string(secret.Type), int64(len(secret.Data)+len(secret.StringData)))
+		}
+		cells = append(cells, age(accessor.GetCreationTimestamp()))
+
+		// kubectl reads the namespace column of -A from the embedded object
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			continue
+		}
+		table.Rows = append(table.Rows, metav1.TableRow{Cells: cells, Object: runtime.RawExtension{Raw: raw}})
+	}
+
+	return table
+}
+
+// age formats the time since created the way kubectl does
+func age(created metav1.Time) string {
+	if created.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(time.Since(created.Time))
+}
@@ -0,0 +1,185 @@
+// Package output writes what the CLI reads from a snapshot as records, for
+// scripts (JSON and NDJSON) and for people (tables)
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Formats accepted by NewWriter
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatTable  = "table"
+)
+
+// IsStructured reports whether format is one of the record formats written
+// by a Writer
+func IsStructured(format string) bool {
+	return format == FormatJSON || format == FormatNDJSON || format == FormatTable
+}
+
+// Record describes one etcd key and what was decoded from its value; a key
+// that could not be read, decrypted or decoded carries the reason in Error
+type Record struct {
+	Key string `json:"key"`
+	// Resource, Namespace and Name are empty for keys that do not hold a
+	// Kubernetes object
+	Resource    string `json:"resource,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name,omitempty"`
+	ModRevision int64  `json:"modRevision,omitempty"`
+	// Provider and KeyName name the encryption key of the value; Provider is
+	// identity for values stored unencrypted
+	Provider string `json:"provider,omitempty"`
+	KeyName  string `json:"keyName,omitempty"`
+	// Deleted is set for the deletions listed in a key's history
+	Deleted bool `json:"deleted,omitempty"`
+	// Type is the type of a secret
+	Type string `json:"type,omitempty"`
+	// Data holds the data keys of a secret as text, or masked; revealed
+	// values that are not valid UTF-8 are in BinaryData instead
+	Data       map[string]string `json:"data,omitempty"`
+	BinaryData map[string][]byte `json:"binaryData,omitempty"`
+	// Object is the decoded object, for resources other than secrets
+	Object map[string]interface{} `json:"object,omitempty"`
+	// Action is what restore did with a secret, such as created or skipped,
+	// and RestoredAs the name it was written under
+	Action     string `json:"action,omitempty"`
+	RestoredAs string `json:"restoredAs,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Writer writes records as one JSON array, as one JSON object per line, or
+// as a table; JSON arrays and tables are written by Flush
+type Writer struct {
+	format  string
+	out     io.Writer
+	records []Record
+}
+
+// NewWriter creates a writer for format json, ndjson or table
+func NewWriter(format string, out io.Writer) (*Writer, error) {
+	if !IsStructured(format) {
+		return nil, fmt.Errorf("unsupported record format %q (expected json, ndjson or table)", format)
+	}
+	return &Writer{format: format, out: out}, nil
+}
+
+// Write writes a record, or buffers it until Flush
+func (w *Writer) Write(r Record) error {
+	if w.format == FormatNDJSON {
+		return json.NewEncoder(w.out).Encode(r)
+	}
+	w.records = append(w.records, r)
+	return nil
+}
+
+// Flush writes the buffered records; the JSON array is written even when
+// there are none, so it always parses
+func (w *Writer) Flush() error {
+	switch w.format {
+	case FormatJSON:
+		records := w.records
+		if records == nil {
+			records = []Record{}
+		}
+		w.records = nil
+		return WriteJSON(w.out, records)
+	case FormatTable:
+		err := w.writeTable()
+		w.records = nil
+		return err
+	}
+	return nil
+}
+
+// writeTable writes one row per record; the action and error columns are
+// only added when a record has an action or an error
+func (w *Writer) writeTable() error {
+	columns := []string{"NAMESPACE", "NAME", "RESOURCE", "REVISION", "PROVIDER", "KEY", "DATA"}
+	withActions, withErrors := false, false
+	for _, r := range w.records {
+		withActions = withActions || r.Action != ""
+		withErrors = withErrors || r.Error != ""
+	}
+	if withActions {
+		columns = append(columns, "ACTION")
+	}
+	if withErrors {
+		columns = append(columns, "ERROR")
+	}
+
+	t := NewTable(w.out, columns...)
+	for _, r := range w.records {
+		name := r.Name
+		if name == "" {
+			name = r.Key
+		}
+		revision := ""
+		if r.ModRevision != 0 {
+			revision = strconv.FormatInt(r.ModRevision, 10)
+		}
+		data := ""
+		switch {
+		case r.Deleted:
+			data = "<deleted>"
+		case r.Data != nil || r.BinaryData != nil:
+			data = strconv.Itoa(len(r.Data) + len(r.BinaryData))
+		}
+
+		cells := []string{r.Namespace, name, r.Resource, revision, r.Provider, r.KeyName, data}
+		if withActions {
+			action := r.Action
+			if r.RestoredAs != "" && r.RestoredAs != r.Name {
+				action += " as " + r.RestoredAs
+			}
+			cells = append(cells, action)
+		}
+		if withErrors {
+			cells = append(cells, r.Error)
+		}
+		t.Row(cells...)
+	}
+	return t.Flush()
+}
+
+// WriteJSON writes v as indented JSON
+func WriteJSON(out io.Writer, v interface{}) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// Table writes aligned columns under a header row; empty cells are shown as
+// "-" so every column stays readable
+type Table struct {
+	tw *tabwriter.Writer
+}
+
+// NewTable creates a table with the given column headers
+func NewTable(out io.Writer, columns ...string) *Table {
+	t := &Table{tw: tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)}
+	t.Row(columns...)
+	return t
+}
+
+// Row adds a row
+func (t *Table) Row(cells ...string) {
+	for i, cell := range cells {
+		if cell == "" {
+			cells[i] = "-"
+		}
+	}
+	fmt.Fprintln(t.tw, strings.Join(cells, "\t"))
+}
+
+// Flush writes the table
+func (t *Table) Flush() error {
+	return t.tw.Flush()
+}
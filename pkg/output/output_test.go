@@ -0,0 +1,115 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var testRecords = []Record{
+	{Key: "/registry/secrets/prod/db", Resource: "secrets", Namespace: "prod", Name: "db", ModRevision: 12, Provider: "aescbc", KeyName: "key1", Type: "Opaque", Data: map[string]string{"password": "s3cret"}},
+	{Key: "/registry/secrets/prod/old", Resource: "secrets", Namespace: "prod", Name: "old", ModRevision: 7, Provider: "aescbc", KeyName: "lost", Error: "no configured provider matches aescbc key \"lost\""},
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: FormatNDJSON,
+			want: `{"key":"/registry/secrets/prod/db","resource":"secrets","namespace":"prod","name":"db","modRevision":12,"provider":"aescbc","keyName":"key1","type":"Opaque","data":{"password":"s3cret"}}
+{"key":"/registry/secrets/prod/old","resource":"secrets","namespace":"prod","name":"old","modRevision":7,"provider":"aescbc","keyName":"lost","error":"no configured provider matches aescbc key \"lost\""}
+`,
+		},
+		{
+			format: FormatTable,
+			want: `NAMESPACE   NAME   RESOURCE   REVISION   PROVIDER   KEY    DATA   ERROR
+prod        db     secrets    12         aescbc     key1   1      -
+prod        old    secrets    7          aescbc     lost   -      no configured provider matches aescbc key "lost"
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(tt.format, &buf)
+			if err != nil {
+				t.Fatalf("NewWriter() error: %v", err)
+			}
+			for _, r := range testRecords {
+				if err := w.Write(r); err != nil {
+					t.Fatalf("Write() error: %v", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush() error: %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("output =\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriterTableActions(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatTable, &buf)
+	if err != nil {
+		t.Fatalf("NewWriter() error: %v", err)
+	}
+	for _, r := range []Record{
+		{Key: "/registry/secrets/prod/db", Resource: "secrets", Namespace: "prod", Name: "db", ModRevision: 12, Action: "renamed", RestoredAs: "db-restored"},
+		{Key: "/registry/secrets/prod/api", Resource: "secrets", Namespace: "prod", Name: "api", ModRevision: 9, Action: "created", RestoredAs: "api"},
+	} {
+		w.Write(r)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	want := `NAMESPACE   NAME   RESOURCE   REVISION   PROVIDER   KEY   DATA   ACTION
+prod        db     secrets    12         -          -     -      renamed as db-restored
+prod        api    secrets    9          -          -     -      created
+`
+	if buf.String() != want {
+		t.Errorf("output =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestWriterJSON(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatJSON, &buf)
+	if err != nil {
+		t.Fatalf("NewWriter() error: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("empty output = %q, want []", buf.String())
+	}
+
+	buf.Reset()
+	for _, r := range testRecords {
+		w.Write(r)
+	}
+	w.Flush()
+	var got []Record
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not a JSON array: %v", err)
+	}
+	if len(got) != 2 || got[0].Data["password"] != "s3cret" || got[1].Error == "" {
+		t.Errorf("output = %+v", got)
+	}
+}
+
+func TestNewWriterRejectsText(t *testing.T) {
+	for _, format := range []string{"text", "yaml", ""} {
+		if _, err := NewWriter(format, &bytes.Buffer{}); err == nil {
+			t.Errorf("NewWriter(%q) succeeded", format)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Package redact hides secret values from output, keeping what is needed to
+// tell values apart: their length and a short SHA-256 fingerprint
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// fingerprintLength is the number of hex digits of the SHA-256 fingerprint
+const fingerprintLength = 12
+
+// Mask describes value without revealing it, e.g.
+// "[redacted: 8 bytes, sha256:5e884898da28]"; equal values have equal masks
+func Mask(value []byte) string {
+	sum := sha256.Sum256(value)
+	return fmt.Sprintf("[redacted: %d bytes, sha256:%s]", len(value), hex.EncodeToString(sum[:])[:fingerprintLength])
+}
+
+// Policy decides which secret data keys are shown in cleartext; a nil
+// Policy reveals nothing
+type Policy struct {
+	revealAll bool
+	patterns  []string
+}
+
+// NewPolicy creates a policy revealing every value when revealAll is set,
+// or the values of the data keys matching one of patterns, which are shell
+// patterns as accepted by path.Match
+func NewPolicy(revealAll bool, patterns []string) (*Policy, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return &Policy{revealAll: revealAll, patterns: patterns}, nil
+}
+
+// Reveals reports whether the value of the data key named key is shown
+func (p *Policy) Reveals(key string) bool {
+	if p == nil {
+		return false
+	}
+	if p.revealAll {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns value as text when key is revealed, and its mask otherwise
+func (p *Policy) Value(key string, value []byte) string {
+	if p.Reveals(key) {
+		return string(value)
+	}
+	return Mask(value)
+}
+
+// Secret returns a copy of secret whose hidden data values are replaced by
+// their mask, so the result is still a valid Secret for API clients
+func (p *Policy) Secret(secret *corev1.Secret) *corev1.Secret {
+	redacted := secret.DeepCopy()
+	for key, value := range redacted.Data {
+		if !p.Reveals(key) {
+			redacted.Data[key] = []byte(Mask(value))
+		}
+	}
+	for key, value := range redacted.StringData {
+		if !p.Reveals(key) {
+			redacted.StringData[key] = Mask([]byte(value))
+		}
+	}
+	return redacted
+}
+
+// Unstructured returns secret as an unstructured object whose hidden data
+// values are replaced by their mask as plain text; as the masks are not
+// base64, the API server rejects a redacted manifest instead of storing
+// masks as values
+func (p *Policy) Unstructured(secret *corev1.Secret) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert secret: %w", err)
+	}
+	obj := &unstructured.Unstructured{Object: content}
+
+	for key, value := range secret.Data {
+		if !p.Reveals(key) {
+			unstructured.SetNestedField(obj.Object, Mask(value), "data", key)
+		}
+	}
+	for key, value := range secret.StringData {
+		if !p.Reveals(key) {
+			unstructured.SetNestedField(obj.Object, Mask([]byte(value)), "stringData", key)
+		}
+	}
+	return obj, nil
+}
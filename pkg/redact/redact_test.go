@@ -0,0 +1,93 @@
+package redact
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		value []byte
+		want  string
+	}{
+		{value: []byte("password"), want: "[redacted: 8 bytes, sha256:5e884898da28]"},
+		{value: nil, want: "[redacted: 0 bytes, sha256:e3b0c44298fc]"},
+	}
+
+	for _, tt := range tests {
+		if got := Mask(tt.value); got != tt.want {
+			t.Errorf("Mask(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyReveals(t *testing.T) {
+	tests := []struct {
+		name      string
+		revealAll bool
+		patterns  []string
+		key       string
+		want      bool
+	}{
+		{name: "default hides", key: "password", want: false},
+		{name: "reveal all", revealAll: true, key: "password", want: true},
+		{name: "exact pattern", patterns: []string{"username"}, key: "username", want: true},
+		{name: "glob pattern", patterns: []string{"*.crt", "ca*"}, key: "tls.crt", want: true},
+		{name: "unmatched pattern", patterns: []string{"*.crt"}, key: "tls.key", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPolicy(tt.revealAll, tt.patterns)
+			if err != nil {
+				t.Fatalf("NewPolicy() error: %v", err)
+			}
+			if got := p.Reveals(tt.key); got != tt.want {
+				t.Errorf("Reveals(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewPolicy(false, []string{"["}); err == nil {
+		t.Error("NewPolicy() with a malformed pattern succeeded")
+	}
+	if (*Policy)(nil).Reveals("password") {
+		t.Error("nil Policy revealed a value")
+	}
+}
+
+func TestPolicySecret(t *testing.T) {
+	p, err := NewPolicy(false, []string{"username"})
+	if err != nil {
+		t.Fatalf("NewPolicy() error: %v", err)
+	}
+	secret := &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "prod"},
+		Data:       map[string][]byte{"username": []byte("admin"), "password": []byte("password")},
+	}
+
+	redacted := p.Secret(secret)
+	if string(redacted.Data["username"]) != "admin" || string(redacted.Data["password"]) != Mask([]byte("password")) {
+		t.Errorf("Secret().Data = %q", redacted.Data)
+	}
+	if string(secret.Data["password"]) != "password" {
+		t.Error("Secret() modified its argument")
+	}
+
+	obj, err := p.Unstructured(secret)
+	if err != nil {
+		t.Fatalf("Unstructured() error: %v", err)
+	}
+	username, _, _ := unstructured.NestedString(obj.Object, "data", "username")
+	password, _, _ := unstructured.NestedString(obj.Object, "data", "password")
+	if username != "YWRtaW4=" || password != Mask([]byte("password")) {
+		t.Errorf("Unstructured() data = %q, %q, want base64 username and masked password", username, password)
+	}
+	if obj.GetName() != "db" || obj.GetKind() != "Secret" {
+		t.Errorf("Unstructured() = %s %s, want Secret db", obj.GetKind(), obj.GetName())
+	}
+}
@@ -0,0 +1,125 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// CustomResourceDefinitions is where the API server stores CRDs
+var CustomResourceDefinitions = Info{
+	GVR:  gvr("apiextensions.k8s.io", "v1", "customresourcedefinitions"),
+	Path: "apiextensions.k8s.io/customresourcedefinitions",
+}
+
+// crd holds the fields of a CustomResourceDefinition that describe how its
+// custom resources are stored
+type crd struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Group string `json:"group"`
+		Names struct {
+			Plural string `json:"plural"`
+		} `json:"names"`
+		Scope    string `json:"scope"`
+		Versions []struct {
+			Name    string `json:"name"`
+			Storage bool   `json:"storage"`
+		} `json:"versions"`
+		// Version is the single version of apiextensions.k8s.io/v1beta1 CRDs
+		Version string `json:"version"`
+	} `json:"spec"`
+}
+
+// CustomResourceInfo reads the storage layout of a custom resource from its
+// CustomResourceDefinition, as stored in etcd (JSON)
+func CustomResourceInfo(data []byte) (Info, error) {
+	if IsProtobuf(data) {
+		return Info{}, fmt.Errorf("protobuf encoded CustomResourceDefinitions are not supported")
+	}
+
+	var def crd
+	if err := json.Unmarshal(data, &def); err != nil {
+		return Info{}, fmt.Errorf("failed to parse CustomResourceDefinition: %w", err)
+	}
+	if def.Spec.Group == "" || def.Spec.Names.Plural == "" {
+		return Info{}, fmt.Errorf("CustomResourceDefinition %q has no group or plural name", def.Metadata.Name)
+	}
+
+	version := def.Spec.Version
+	for _, v := range def.Spec.Versions {
+		if v.Storage {
+			version = v.Name
+		}
+	}
+
+	return Info{
+		GVR: schema.GroupVersionResource{
+			Group:    def.Spec.Group,
+			Version:  version,
+			Resource: def.Spec.Names.Plural,
+		},
+		Namespaced: def.Spec.Scope != "Cluster",
+		Path:       def.Spec.Group + "/" + def.Spec.Names.Plural,
+	}, nil
+}
+
+// Registry resolves resources and etcd keys using the built-in resources
+// and the custom resources defined by CRDs found in a snapshot
+type Registry struct {
+	infos  []Info
+	custom []Info
+}
+
+// NewRegistry creates a registry holding only the built-in resources
+func NewRegistry() *Registry {
+	infos := make([]Info, len(builtin))
+	copy(infos, builtin)
+	return &Registry{infos: infos}
+}
+
+// AddCRD registers the custom resource defined by a stored CustomResourceDefinition
+func (r *Registry) AddCRD(data []byte) (Info, error) {
+	info, err := CustomResourceInfo(data)
+	if err != nil {
+		return Info{}, err
+	}
+
+	r.infos = append(r.infos, info)
+	r.custom = append(r.custom, info)
+	return info, nil
+}
+
+// CustomResources returns the registered custom resources, sorted by group resource
+func (r *Registry) CustomResources() []Info {
+	infos := make([]Info, len(r.custom))
+	copy(infos, r.custom)
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].GroupResource() < infos[j].GroupResource()
+	})
+	return infos
+}
+
+// IsCustom reports whether info is a custom resource registered from a CRD
+func (r *Registry) IsCustom(info Info) bool {
+	for _, custom := range r.custom {
+		if custom.GVR.GroupResource() == info.GVR.GroupResource() {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup finds a built-in or custom resource by plural name or resource.group
+func (r *Registry) Lookup(name string) (Info, error) {
+	return lookup(r.infos, name)
+}
+
+// ParseKey maps an etcd key to the built-in or custom resource it stores
+func (r *Registry) ParseKey(etcdKey string) (Key, error) {
+	return parseKey(r.infos, etcdKey)
+}
@@ -0,0 +1,105 @@
+package resource
+
+import (
+	"strings"
+	"testing"
+)
+
+const certificateCRD = `{"kind":"CustomResourceDefinition","apiVersion":"apiextensions.k8s.io/v1",
+"metadata":{"name":"certificates.cert-manager.io"},
+"spec":{"group":"cert-manager.io","scope":"Namespaced",
+"names":{"plural":"certificates","singular":"certificate","kind":"Certificate"},
+"versions":[{"name":"v1alpha2","served":true,"storage":false},{"name":"v1","served":true,"storage":true}]}}`
+
+const clusterIssuerCRD = `{"kind":"CustomResourceDefinition","apiVersion":"apiextensions.k8s.io/v1",
+"metadata":{"name":"clusterissuers.cert-manager.io"},
+"spec":{"group":"cert-manager.io","scope":"Cluster",
+"names":{"plural":"clusterissuers","kind":"ClusterIssuer"},
+"versions":[{"name":"v1","served":true,"storage":true}]}}`
+
+func TestCustomResourceInfo(t *testing.T) {
+	tests := []struct {
+		name           string
+		data           string
+		wantResource   string
+		wantVersion    string
+		wantNamespaced bool
+		wantErr        string
+	}{
+		{name: "Namespaced with storage version", data: certificateCRD, wantResource: "certificates.cert-manager.io", wantVersion: "v1", wantNamespaced: true},
+		{name: "Cluster scoped", data: clusterIssuerCRD, wantResource: "clusterissuers.cert-manager.io", wantVersion: "v1"},
+		{
+			name:           "v1beta1 single version",
+			data:           `{"spec":{"group":"example.com","version":"v1beta1","scope":"Namespaced","names":{"plural":"widgets"}}}`,
+			wantResource:   "widgets.example.com",
+			wantVersion:    "v1beta1",
+			wantNamespaced: true,
+		},
+		{name: "Missing names", data: `{"metadata":{"name":"broken"},"spec":{"group":"example.com"}}`, wantErr: "no group or plural"},
+		{name: "Protobuf", data: "k8s\x00\x0a", wantErr: "protobuf"},
+		{name: "Garbage", data: "not json", wantErr: "failed to parse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := CustomResourceInfo([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("CustomResourceInfo() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CustomResourceInfo() unexpected error: %v", err)
+			}
+
+			if info.GroupResource() != tt.wantResource {
+				t.Errorf("resource = %q, want %q", info.GroupResource(), tt.wantResource)
+			}
+			if info.GVR.Version != tt.wantVersion {
+				t.Errorf("version = %q, want %q", info.GVR.Version, tt.wantVersion)
+			}
+			if info.Namespaced != tt.wantNamespaced {
+				t.Errorf("namespaced = %v, want %v", info.Namespaced, tt.wantNamespaced)
+			}
+		})
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	for _, def := range []string{certificateCRD, clusterIssuerCRD} {
+		if _, err := registry.AddCRD([]byte(def)); err != nil {
+			t.Fatalf("AddCRD() error: %v", err)
+		}
+	}
+
+	if got := len(registry.CustomResources()); got != 2 {
+		t.Errorf("CustomResources() returned %d resources, want 2", got)
+	}
+
+	info, err := registry.Lookup("clusterissuers")
+	if err != nil {
+		t.Fatalf("Lookup() error: %v", err)
+	}
+	if info.Namespaced || info.GVR.Version != "v1" || !registry.IsCustom(info) {
+		t.Errorf("Lookup() = %+v, want cluster-scoped custom resource at v1", info)
+	}
+	if keys := info.Keys("", "letsencrypt"); keys[0] != "/registry/cert-manager.io/clusterissuers/letsencrypt" {
+		t.Errorf("Keys() = %v", keys)
+	}
+
+	key, err := registry.ParseKey("/registry/cert-manager.io/certificates/default/site-tls")
+	if err != nil {
+		t.Fatalf("ParseKey() error: %v", err)
+	}
+	if key.Resource.GVR.Version != "v1" || key.Namespace != "default" || key.Name != "site-tls" {
+		t.Errorf("ParseKey() = %+v", key)
+	}
+
+	// Built-in resources are not custom, and still resolve
+	deployments, err := registry.Lookup("deployments")
+	if err != nil || registry.IsCustom(deployments) {
+		t.Errorf("Lookup(deployments) = %+v, %v", deployments, err)
+	}
+}
@@ -0,0 +1,70 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+// protobufMagic starts every value the API server stores as protobuf
+var protobufMagic = []byte("k8s\x00")
+
+// IsProtobuf reports whether data is a protobuf-encoded object
+func IsProtobuf(data []byte) bool {
+	return bytes.HasPrefix(data, protobufMagic)
+}
+
+// Decode decodes a decrypted etcd value, stored as protobuf or JSON, into a
+// typed object of any built-in API group; JSON objects of kinds the scheme
+// does not know, such as custom resources, are decoded as unstructured
+// The returned object carries its apiVersion and kind
+func Decode(data []byte) (runtime.Object, error) {
+	obj, gvk, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	if runtime.IsNotRegisteredError(err) && !IsProtobuf(data) {
+		return DecodeUnstructured(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode: %w", err)
+	}
+
+	// Protobuf values carry the type outside the object, so restore it
+	obj.GetObjectKind().SetGroupVersionKind(*gvk)
+	return obj, nil
+}
+
+// DecodeUnstructured decodes a JSON value, such as a custom resource stored
+// by the apiextensions server, without needing its Go type
+func DecodeUnstructured(data []byte) (*unstructured.Unstructured, error) {
+	if IsProtobuf(data) {
+		return nil, fmt.Errorf("failed to decode: protobuf values need a registered type")
+	}
+
+	obj := &unstructured.Unstructured{}
+	if _, _, err := unstructured.UnstructuredJSONScheme.Decode(data, nil, obj); err != nil {
+		return nil, fmt.Errorf("failed to decode: %w", err)
+	}
+	return obj, nil
+}
+
+// ToYAML renders an object as a YAML document
+func ToYAML(obj runtime.Object) ([]byte, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %T: %w", obj, err)
+	}
+	return yaml.JSONToYAML(data)
+}
+
+// ToJSON renders an object as indented JSON
+func ToJSON(obj runtime.Object) ([]byte, error) {
+	data, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %T: %w", obj, err)
+	}
+	return data, nil
+}
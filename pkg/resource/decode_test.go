@@ -0,0 +1,119 @@
+package resource
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/protobuf"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// encodeProtobuf stores an object the way the API server writes it to etcd
+func encodeProtobuf(t *testing.T, obj runtime.Object) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := protobuf.NewSerializer(scheme.Scheme, scheme.Scheme).Encode(obj, &buf); err != nil {
+		t.Fatalf("Failed to encode %T: %v", obj, err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	replicas := int32(3)
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "prod"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantKind string
+		wantErr  bool
+	}{
+		{
+			name:     "Protobuf deployment",
+			data:     encodeProtobuf(t, deployment),
+			wantKind: "Deployment",
+		},
+		{
+			name:     "Protobuf configmap",
+			data:     encodeProtobuf(t, &corev1.ConfigMap{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}, ObjectMeta: metav1.ObjectMeta{Name: "cfg"}}),
+			wantKind: "ConfigMap",
+		},
+		{
+			name:     "JSON role",
+			data:     []byte(`{"kind":"Role","apiVersion":"rbac.authorization.k8s.io/v1","metadata":{"name":"reader"}}`),
+			wantKind: "Role",
+		},
+		{
+			name:     "Custom resource as unstructured",
+			data:     []byte(`{"kind":"Widget","apiVersion":"example.com/v1","metadata":{"name":"w1"},"spec":{"size":3}}`),
+			wantKind: "Widget",
+		},
+		{
+			name:    "JSON without kind",
+			data:    []byte(`{"apiVersion":"v1","metadata":{"name":"x"}}`),
+			wantErr: true,
+		},
+		{
+			name:    "Garbage",
+			data:    []byte("not an object"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj, err := Decode(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Decode() expected error, got %T", obj)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode() unexpected error: %v", err)
+			}
+			if kind := obj.GetObjectKind().GroupVersionKind().Kind; kind != tt.wantKind {
+				t.Errorf("Decode() kind = %q, want %q", kind, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestToYAML(t *testing.T) {
+	replicas := int32(2)
+	data := encodeProtobuf(t, &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "prod"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	})
+
+	if !IsProtobuf(data) {
+		t.Fatalf("IsProtobuf() = false for protobuf data")
+	}
+
+	obj, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode() error: %v", err)
+	}
+
+	out, err := ToYAML(obj)
+	if err != nil {
+		t.Fatalf("ToYAML() error: %v", err)
+	}
+
+	for _, want := range []string{"apiVersion: apps/v1", "kind: Deployment", "name: web", "replicas: 2"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("ToYAML() output missing %q:\n%s", want, out)
+		}
+	}
+}
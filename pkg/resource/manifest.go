@@ -0,0 +1,112 @@
+package resource
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// serverFields are the metadata fields the API server assigns to stored
+// objects; they must not be sent back when re-creating an object
+var serverFields = []string{"resourceVersion", "uid", "managedFields", "creationTimestamp"}
+
+// ToManifest converts obj to an unstructured copy without the metadata the
+// API server assigns, so it can be re-created with kubectl apply
+// Name, namespace, labels, annotations and the object's content are kept
+func ToManifest(obj runtime.Object) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert %T: %w", obj, err)
+	}
+
+	for _, field := range serverFields {
+		unstructured.RemoveNestedField(content, "metadata", field)
+	}
+
+	manifest := &unstructured.Unstructured{Object: content}
+	if manifest.GetKind() == "" {
+		return nil, fmt.Errorf("%T has no kind set", obj)
+	}
+	return manifest, nil
+}
+
+// Manifest formats accepted by NewManifestWriter
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+)
+
+// ManifestWriter writes objects as kubectl-applyable manifests, either to one
+// multi-document stream or to a <dir>/<namespace>/<name>.<format> tree
+type ManifestWriter struct {
+	format string
+	out    io.Writer
+	dir    string
+}
+
+// NewManifestWriter creates a writer for format yaml or json; when dir is
+// empty manifests are written to out as a single stream
+func NewManifestWriter(format string, out io.Writer, dir string) (*ManifestWriter, error) {
+	if format != FormatYAML && format != FormatJSON {
+		return nil, fmt.Errorf("unsupported manifest format %q (expected yaml or json)", format)
+	}
+	return &ManifestWriter{format: format, out: out, dir: dir}, nil
+}
+
+// Write writes the manifest of obj, as returned by ToManifest
+func (w *ManifestWriter) Write(obj runtime.Object) error {
+	manifest, err := ToManifest(obj)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if w.format == FormatJSON {
+		data, err = ToJSON(manifest)
+		data = append(data, '\n')
+	} else {
+		data, err = ToYAML(manifest)
+	}
+	if err != nil {
+		return err
+	}
+
+	if w.dir == "" {
+		if w.format == FormatYAML {
+			data = append([]byte("---\n"), data...)
+		}
+		_, err = w.out.Write(data)
+		return err
+	}
+
+	return w.writeFile(manifest, data)
+}
+
+// writeFile stores a manifest under the directory of its namespace;
+// cluster-scoped objects go to the _cluster directory
+func (w *ManifestWriter) writeFile(manifest *unstructured.Unstructured, data []byte) error {
+	if manifest.GetName() == "" {
+		return fmt.Errorf("cannot write a manifest for an object without a name")
+	}
+
+	namespace := manifest.GetNamespace()
+	if namespace == "" {
+		namespace = "_cluster"
+	}
+
+	// Manifests may hold secret data, so keep them private to the user
+	dir := filepath.Join(w.dir, filepath.Base(namespace))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	path := filepath.Join(dir, filepath.Base(manifest.GetName())+"."+w.format)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
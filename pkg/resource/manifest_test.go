@@ -0,0 +1,152 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// storedSecret returns a secret carrying the metadata the API server assigns
+func storedSecret(namespace, name string) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         namespace,
+			UID:               types.UID("6f1c0d7e-0000-4000-8000-000000000000"),
+			ResourceVersion:   "12345",
+			CreationTimestamp: metav1.Now(),
+			Labels:            map[string]string{"app": "web"},
+			Annotations:       map[string]string{"owner": "team-a"},
+			ManagedFields:     []metav1.ManagedFieldsEntry{{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationApply}},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{"tls.key": []byte("secret\x00bytes")},
+	}
+}
+
+func TestToManifest(t *testing.T) {
+	manifest, err := ToManifest(storedSecret("default", "web-tls"))
+	if err != nil {
+		t.Fatalf("ToManifest() error: %v", err)
+	}
+
+	metadata := manifest.Object["metadata"].(map[string]interface{})
+	for _, field := range []string{"resourceVersion", "uid", "managedFields", "creationTimestamp"} {
+		if _, ok := metadata[field]; ok {
+			t.Errorf("ToManifest() kept metadata.%s", field)
+		}
+	}
+
+	if manifest.GetLabels()["app"] != "web" || manifest.GetAnnotations()["owner"] != "team-a" {
+		t.Errorf("ToManifest() lost labels or annotations: %v", metadata)
+	}
+	if manifest.Object["type"] != "kubernetes.io/tls" {
+		t.Errorf("ToManifest() type = %v", manifest.Object["type"])
+	}
+	// Binary data must be base64-encoded, as the API expects
+	if data := manifest.Object["data"].(map[string]interface{}); data["tls.key"] != "c2VjcmV0AGJ5dGVz" {
+		t.Errorf("ToManifest() data = %v, want base64", data)
+	}
+
+	if _, err := ToManifest(&corev1.Secret{}); err == nil {
+		t.Errorf("ToManifest() expected error for object without kind")
+	}
+}
+
+func TestManifestWriterStream(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewManifestWriter(FormatYAML, &buf, "")
+	if err != nil {
+		t.Fatalf("NewManifestWriter() error: %v", err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if err := w.Write(storedSecret("default", name)); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+
+	out := buf.String()
+	if n := strings.Count(out, "---\n"); n != 2 {
+		t.Errorf("stream has %d documents, want 2:\n%s", n, out)
+	}
+	if strings.Contains(out, "resourceVersion") || strings.Contains(out, "creationTimestamp") {
+		t.Errorf("stream contains server fields:\n%s", out)
+	}
+
+	if _, err := NewManifestWriter("xml", &buf, ""); err == nil {
+		t.Errorf("NewManifestWriter() expected error for unsupported format")
+	}
+}
+
+func TestManifestWriterStreamJSON(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewManifestWriter(FormatJSON, &buf, "")
+	if err != nil {
+		t.Fatalf("NewManifestWriter() error: %v", err)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if err := w.Write(storedSecret("default", name)); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+
+	// One JSON document per secret, as kubectl apply -f - accepts
+	dec := json.NewDecoder(&buf)
+	for _, name := range []string{"a", "b"} {
+		var secret corev1.Secret
+		if err := dec.Decode(&secret); err != nil {
+			t.Fatalf("Decode() error: %v", err)
+		}
+		if secret.Kind != "Secret" || secret.Name != name || secret.ResourceVersion != "" {
+			t.Errorf("manifest = %s %s at %q, want Secret %s without resourceVersion", secret.Kind, secret.Name, secret.ResourceVersion, name)
+		}
+		if string(secret.Data["tls.key"]) != "secret\x00bytes" {
+			t.Errorf("manifest data = %q, want the stored bytes", secret.Data["tls.key"])
+		}
+	}
+	if dec.More() {
+		t.Errorf("stream has more than 2 documents")
+	}
+}
+
+func TestManifestWriterDirectory(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewManifestWriter(FormatJSON, nil, dir)
+	if err != nil {
+		t.Fatalf("NewManifestWriter() error: %v", err)
+	}
+
+	if err := w.Write(storedSecret("prod", "db")); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	if err := w.Write(storedSecret("kube-system", "token")); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+
+	for _, path := range []string{"prod/db.json", "kube-system/token.json"} {
+		full := filepath.Join(dir, path)
+		info, err := os.Stat(full)
+		if err != nil {
+			t.Errorf("expected manifest %s: %v", path, err)
+			continue
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%s mode = %v, want 0600", path, info.Mode().Perm())
+		}
+
+		data, _ := os.ReadFile(full)
+		if !strings.Contains(string(data), `"kind": "Secret"`) {
+			t.Errorf("%s is not a Secret manifest:\n%s", path, data)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package resource
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// KeyPrefixes are the etcd key prefixes resources are stored under in
+// standard Kubernetes and OpenShift clusters
+var KeyPrefixes = []string{"/registry/", "/kubernetes.io/"}
+
+// Info describes how a resource type is stored in etcd
+type Info struct {
+	// GVR is the resource and the version the API server stores it as;
+	// the version is empty for resources that are not built in
+	GVR        schema.GroupVersionResource
+	Namespaced bool
+	// Path is the directory under the key prefix, e.g. "services/specs";
+	// it differs from the resource name for a few core resources
+	Path string
+}
+
+// GroupResource returns the resource in the resource.group form used by
+// EncryptionConfiguration, e.g. "secrets" or "deployments.apps"
+func (i Info) GroupResource() string {
+	return i.GVR.GroupResource().String()
+}
+
+// Prefixes returns the etcd key prefixes holding every object of the resource
+func (i Info) Prefixes() []string {
+	prefixes := make([]string, len(KeyPrefixes))
+	for j, p := range KeyPrefixes {
+		prefixes[j] = p + i.Path + "/"
+	}
+	return prefixes
+}
+
+// Keys returns the etcd keys an object may be stored under; namespace is
+// ignored for cluster-scoped resources
+func (i Info) Keys(namespace, name string) []string {
+	keys := make([]string, len(KeyPrefixes))
+	for j, prefix := range i.Prefixes() {
+		if i.Namespaced {
+			keys[j] = prefix + namespace + "/" + name
+		} else {
+			keys[j] = prefix + name
+		}
+	}
+	return keys
+}
+
+// builtin lists the resources served by kube-apiserver with their storage
+// paths (k8s.io/kubernetes/pkg/registry/.../storage)
+var builtin = []Info{
+	{GVR: gvr("", "v1", "configmaps"), Namespaced: true, Path: "configmaps"},
+	{GVR: gvr("", "v1", "endpoints"), Namespaced: true, Path: "services/endpoints"},
+	{GVR: gvr("", "v1", "events"), Namespaced: true, Path: "events"},
+	{GVR: gvr("", "v1", "limitranges"), Namespaced: true, Path: "limitranges"},
+	{GVR: gvr("", "v1", "namespaces"), Path: "namespaces"},
+	{GVR: gvr("", "v1", "nodes"), Path: "minions"},
+	{GVR: gvr("", "v1", "persistentvolumeclaims"), Namespaced: true, Path: "persistentvolumeclaims"},
+	{GVR: gvr("", "v1", "persistentvolumes"), Path: "persistentvolumes"},
+	{GVR: gvr("", "v1", "pods"), Namespaced: true, Path: "pods"},
+	{GVR: gvr("", "v1", "podtemplates"), Namespaced: true, Path: "podtemplates"},
+	{GVR: gvr("", "v1", "replicationcontrollers"), Namespaced: true, Path: "controllers"},
+	{GVR: gvr("", "v1", "resourcequotas"), Namespaced: true, Path: "resourcequotas"},
+	{GVR: gvr("", "v1", "secrets"), Namespaced: true, Path: "secrets"},
+	{GVR: gvr("", "v1", "serviceaccounts"), Namespaced: true, Path: "serviceaccounts"},
+	{GVR: gvr("", "v1", "services"), Namespaced: true, Path: "services/specs"},
+
+	CustomResourceDefinitions,
+	{GVR: gvr("apiregistration.k8s.io", "v1", "apiservices"), Path: "apiregistration.k8s.io/apiservices"},
+
+	{GVR: gvr("admissionregistration.k8s.io", "v1", "mutatingwebhookconfigurations"), Path: "mutatingwebhookconfigurations"},
+	{GVR: gvr("admissionregistration.k8s.io", "v1", "validatingadmissionpolicies"), Path: "validatingadmissionpolicies"},
+	{GVR: gvr("admissionregistration.k8s.io", "v1", "validatingadmissionpolicybindings"), Path: "validatingadmissionpolicybindings"},
+	{GVR: gvr("admissionregistration.k8s.io", "v1", "validatingwebhookconfigurations"), Path: "validatingwebhookconfigurations"},
+	{GVR: gvr("apps", "v1", "controllerrevisions"), Namespaced: true, Path: "controllerrevisions"},
+	{GVR: gvr("apps", "v1", "daemonsets"), Namespaced: true, Path: "daemonsets"},
+	{GVR: gvr("apps", "v1", "deployments"), Namespaced: true, Path: "deployments"},
+	{GVR: gvr("apps", "v1", "replicasets"), Namespaced: true, Path: "replicasets"},
+	{GVR: gvr("apps", "v1", "statefulsets"), Namespaced: true, Path: "statefulsets"},
+	{GVR: gvr("autoscaling", "v2", "horizontalpodautoscalers"), Namespaced: true, Path: "horizontalpodautoscalers"},
+	{GVR: gvr("batch", "v1", "cronjobs"), Namespaced: true, Path: "cronjobs"},
+	{GVR: gvr("batch", "v1", "jobs"), Namespaced: true, Path: "jobs"},
+	{GVR: gvr("certificates.k8s.io", "v1", "certificatesigningrequests"), Path: "certificatesigningrequests"},
+	{GVR: gvr("coordination.k8s.io", "v1", "leases"), Namespaced: true, Path: "leases"},
+	{GVR: gvr("discovery.k8s.io", "v1", "endpointslices"), Namespaced: true, Path: "endpointslices"},
+	{GVR: gvr("flowcontrol.apiserver.k8s.io", "v1", "flowschemas"), Path: "flowschemas"},
+	{GVR: gvr("flowcontrol.apiserver.k8s.io", "v1", "prioritylevelconfigurations"), Path: "prioritylevelconfigurations"},
+	{GVR: gvr("networking.k8s.io", "v1", "ingressclasses"), Path: "ingressclasses"},
+	{GVR: gvr("networking.k8s.io", "v1", "ingresses"), Namespaced: true, Path: "ingress"},
+	{GVR: gvr("networking.k8s.io", "v1", "networkpolicies"), Namespaced: true, Path: "networkpolicies"},
+	{GVR: gvr("node.k8s.io", "v1", "runtimeclasses"), Path: "runtimeclasses"},
+	{GVR: gvr("policy", "v1", "poddisruptionbudgets"), Namespaced: true, Path: "poddisruptionbudgets"},
+	{GVR: gvr("rbac.authorization.k8s.io", "v1", "clusterrolebindings"), Path: "clusterrolebindings"},
+	{GVR: gvr("rbac.authorization.k8s.io", "v1", "clusterroles"), Path: "clusterroles"},
+	{GVR: gvr("rbac.authorization.k8s.io", "v1", "rolebindings"), Namespaced: true, Path: "rolebindings"},
+	{GVR: gvr("rbac.authorization.k8s.io", "v1", "roles"), Namespaced: true, Path: "roles"},
+	{GVR: gvr("scheduling.k8s.io", "v1", "priorityclasses"), Path: "priorityclasses"},
+	{GVR: gvr("storage.k8s.io", "v1", "csidrivers"), Path: "csidrivers"},
+	{GVR: gvr("storage.k8s.io", "v1", "csinodes"), Path: "csinodes"},
+	{GVR: gvr("storage.k8s.io", "v1", "csistoragecapacities"), Namespaced: true, Path: "csistoragecapacities"},
+	{GVR: gvr("storage.k8s.io", "v1", "storageclasses"), Path: "storageclasses"},
+	{GVR: gvr("storage.k8s.io", "v1", "volumeattachments"), Path: "volumeattachments"},
+}
+
+func gvr(group, version, resource string) schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: group, Version: version, Resource: resource}
+}
+
+// Builtin returns the built-in resources, sorted by group resource
+func Builtin() []Info {
+	infos := make([]Info, len(builtin))
+	copy(infos, builtin)
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].GroupResource() < infos[j].GroupResource()
+	})
+	return infos
+}
+
+// Lookup finds a built-in resource by plural name ("deployments") or by
+// resource.group ("deployments.apps"); a resource.group that is not built in
+// is assumed to be stored under /registry/<group>/<resource>/
+func Lookup(name string) (Info, error) {
+	return lookup(builtin, name)
+}
+
+func lookup(infos []Info, name string) (Info, error) {
+	name = strings.ToLower(name)
+
+	var matches []Info
+	for _, info := range infos {
+		if info.GroupResource() == name || info.GVR.Resource == name {
+			matches = append(matches, info)
+		}
+	}
+
+	switch {
+	case len(matches) == 1:
+		return matches[0], nil
+	case len(matches) > 1:
+		return Info{}, fmt.Errorf("resource %q is ambiguous, use resource.group", name)
+	}
+
+	if i := strings.Index(name, "."); i > 0 {
+		group := name[i+1:]
+		return Info{
+			GVR:        schema.GroupVersionResource{Group: group, Resource: name[:i]},
+			Namespaced: true,
+			Path:       group + "/" + name[:i],
+		}, nil
+	}
+
+	return Info{}, fmt.Errorf("unknown resource %q", name)
+}
+
+// Key is an etcd key split into the resource and object it stores
+type Key struct {
+	Resource  Info
+	Namespace string
+	Name      string
+}
+
+// ParseKey maps an etcd key such as /registry/deployments/default/web or
+// /registry/<group>/<resource>/<namespace>/<name> to the resource it stores
+func ParseKey(etcdKey string) (Key, error) {
+	return parseKey(builtin, etcdKey)
+}
+
+func parseKey(infos []Info, etcdKey string) (Key, error) {
+	var rest string
+	for _, prefix := range KeyPrefixes {
+		if strings.HasPrefix(etcdKey, prefix) {
+			rest = etcdKey[len(prefix):]
+			break
+		}
+	}
+	if rest == "" {
+		return Key{}, fmt.Errorf("key %q is not under a known prefix", etcdKey)
+	}
+
+	// Prefer the longest known path, so services/specs wins over services
+	var info Info
+	found := false
+	for _, candidate := range infos {
+		if strings.HasPrefix(rest, candidate.Path+"/") && len(candidate.Path) > len(info.Path) {
+			info = candidate
+			found = true
+		}
+	}
+
+	if !found {
+		parts := strings.Split(rest, "/")
+		switch {
+		case len(parts) >= 3 && strings.Contains(parts[0], "."):
+			// /registry/<group>/<resource>/[<namespace>/]<name>
+			info = Info{
+				GVR:        schema.GroupVersionResource{Group: parts[0], Resource: parts[1]},
+				Namespaced: len(parts) == 4,
+				Path:       parts[0] + "/" + parts[1],
+			}
+		case len(parts) >= 2:
+			info = Info{
+				GVR:        schema.GroupVersionResource{Resource: parts[0]},
+				Namespaced: len(parts) == 3,
+				Path:       parts[0],
+			}
+		default:
+			return Key{}, fmt.Errorf("key %q does not name a resource", etcdKey)
+		}
+	}
+
+	parts := strings.Split(strings.TrimPrefix(rest, info.Path+"/"), "/")
+	switch {
+	case info.Namespaced && len(parts) == 2:
+		return Key{Resource: info, Namespace: parts[0], Name: parts[1]}, nil
+	case !info.Namespaced && len(parts) == 1:
+		return Key{Resource: info, Name: parts[0]}, nil
+	default:
+		return Key{}, fmt.Errorf("key %q does not match the layout of %s", etcdKey, info.GroupResource())
+	}
+}
@@ -0,0 +1,118 @@
+package resource
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name          string
+		key           string
+		wantResource  string
+		wantVersion   string
+		wantNamespace string
+		wantName      string
+		wantErr       bool
+	}{
+		{name: "Core namespaced", key: "/registry/configmaps/default/app-config", wantResource: "configmaps", wantVersion: "v1", wantNamespace: "default", wantName: "app-config"},
+		{name: "Grouped namespaced", key: "/registry/deployments/prod/web", wantResource: "deployments.apps", wantVersion: "v1", wantNamespace: "prod", wantName: "web"},
+		{name: "Cluster scoped", key: "/registry/clusterroles/admin", wantResource: "clusterroles.rbac.authorization.k8s.io", wantVersion: "v1", wantName: "admin"},
+		{name: "Services use a sub-directory", key: "/registry/services/specs/default/kubernetes", wantResource: "services", wantVersion: "v1", wantNamespace: "default", wantName: "kubernetes"},
+		{name: "Endpoints share the services directory", key: "/registry/services/endpoints/default/kubernetes", wantResource: "endpoints", wantVersion: "v1", wantNamespace: "default", wantName: "kubernetes"},
+		{name: "Nodes are stored as minions", key: "/registry/minions/node-1", wantResource: "nodes", wantVersion: "v1", wantName: "node-1"},
+		{name: "Ingresses", key: "/registry/ingress/default/site", wantResource: "ingresses.networking.k8s.io", wantVersion: "v1", wantNamespace: "default", wantName: "site"},
+		{name: "OpenShift prefix", key: "/kubernetes.io/secrets/openshift-etcd/signer", wantResource: "secrets", wantVersion: "v1", wantNamespace: "openshift-etcd", wantName: "signer"},
+		{name: "Grouped custom resource", key: "/registry/cert-manager.io/certificates/default/site-tls", wantResource: "certificates.cert-manager.io", wantNamespace: "default", wantName: "site-tls"},
+		{name: "Cluster scoped custom resource", key: "/registry/cert-manager.io/clusterissuers/letsencrypt", wantResource: "clusterissuers.cert-manager.io", wantName: "letsencrypt"},
+		{name: "Unknown prefix", key: "/other/configmaps/default/x", wantErr: true},
+		{name: "Wrong depth", key: "/registry/configmaps/default", wantErr: true},
+		{name: "Too deep", key: "/registry/pods/default/a/b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKey(tt.key)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseKey(%q) expected error, got %+v", tt.key, key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKey(%q) unexpected error: %v", tt.key, err)
+			}
+
+			if got := key.Resource.GroupResource(); got != tt.wantResource {
+				t.Errorf("resource = %q, want %q", got, tt.wantResource)
+			}
+			if key.Resource.GVR.Version != tt.wantVersion {
+				t.Errorf("version = %q, want %q", key.Resource.GVR.Version, tt.wantVersion)
+			}
+			if key.Namespace != tt.wantNamespace || key.Name != tt.wantName {
+				t.Errorf("object = %s/%s, want %s/%s", key.Namespace, key.Name, tt.wantNamespace, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name     string
+		wantPath string
+		wantErr  string
+	}{
+		{name: "configmaps", wantPath: "configmaps"},
+		{name: "Deployments", wantPath: "deployments"},
+		{name: "deployments.apps", wantPath: "deployments"},
+		{name: "services", wantPath: "services/specs"},
+		{name: "ingresses.networking.k8s.io", wantPath: "ingress"},
+		{name: "certificates.cert-manager.io", wantPath: "cert-manager.io/certificates"},
+		{name: "widgets", wantErr: "unknown resource"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Lookup(tt.name)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Lookup() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Lookup() unexpected error: %v", err)
+			}
+			if info.Path != tt.wantPath {
+				t.Errorf("Lookup() path = %q, want %q", info.Path, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestInfoKeys(t *testing.T) {
+	services, _ := Lookup("services")
+	keys := services.Keys("default", "kubernetes")
+	want := []string{"/registry/services/specs/default/kubernetes", "/kubernetes.io/services/specs/default/kubernetes"}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("Keys() = %v, want %v", keys, want)
+	}
+
+	nodes, _ := Lookup("nodes")
+	if keys := nodes.Keys("ignored", "node-1"); keys[0] != "/registry/minions/node-1" {
+		t.Errorf("Keys() for cluster-scoped resource = %v", keys)
+	}
+
+	// Every built-in path must parse back to its own resource
+	for _, info := range Builtin() {
+		name := "obj"
+		key, err := ParseKey(info.Keys("ns", name)[0])
+		if err != nil {
+			t.Errorf("ParseKey() for %s: %v", info.GroupResource(), err)
+			continue
+		}
+		if key.Resource.GroupResource() != info.GroupResource() {
+			t.Errorf("ParseKey() for %s = %s", info.GroupResource(), key.Resource.GroupResource())
+		}
+	}
+}
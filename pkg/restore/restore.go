@@ -0,0 +1,255 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ConflictPolicy decides what happens when a restored secret already exists
+type ConflictPolicy string
+
+const (
+	// ConflictSkip leaves the existing secret untouched
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces the existing secret with the snapshot copy
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictRename creates the snapshot copy next to the existing secret
+	// under the first free name of the form <name>-restored[-N]
+	ConflictRename ConflictPolicy = "rename"
+)
+
+// ParseConflictPolicy parses a --on-conflict value
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case ConflictSkip, ConflictOverwrite, ConflictRename:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy %q (expected skip, overwrite or rename)", s)
+	}
+}
+
+// Action is the outcome of restoring one secret
+type Action string
+
+const (
+	ActionCreated Action = "created"
+	ActionUpdated Action = "updated"
+	ActionSkipped Action = "skipped"
+	ActionRenamed Action = "renamed"
+	ActionFailed  Action = "failed"
+)
+
+// maxRenameAttempts bounds the search for a free name with ConflictRename
+const maxRenameAttempts = 100
+
+// ErrNoFilter is returned by Filter.Validate when a filter has no pattern
+// and does not set All
+var ErrNoFilter = errors.New("no namespace or name pattern to select secrets")
+
+// Filter selects secrets by namespace and name; both are shell patterns as
+// accepted by path.Match, and an empty pattern matches everything
+type Filter struct {
+	Namespace string
+	Name      string
+	// All selects every secret; it has to be set when both patterns are
+	// empty, so that restoring a whole snapshot is never an accident
+	All bool
+}
+
+// Match reports whether the secret namespace/name is selected
+func (f Filter) Match(namespace, name string) bool {
+	return matchPattern(f.Namespace, namespace) && matchPattern(f.Name, name)
+}
+
+// Validate checks that the filter selects secrets explicitly and that both
+// patterns are well formed
+func (f Filter) Validate() error {
+	if f.All && (f.Namespace != "" || f.Name != "") {
+		return fmt.Errorf("a filter cannot select all secrets and match patterns")
+	}
+	if !f.All && f.Namespace == "" && f.Name == "" {
+		return ErrNoFilter
+	}
+	for _, pattern := range []string{f.Namespace, f.Name} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchPattern(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
+
+// Options configures a Restorer
+type Options struct {
+	// DryRun reports what would happen without writing to the cluster
+	DryRun     bool
+	OnConflict ConflictPolicy
+}
+
+// Result records what happened to one secret
+type Result struct {
+	Namespace string
+	Name      string
+	// RestoredAs is the name the secret was written under, which differs
+	// from Name when it was renamed
+	RestoredAs string
+	Action     Action
+	Err        error
+}
+
+// Restorer writes secrets recovered from a snapshot to a live cluster
+type Restorer struct {
+	client kubernetes.Interface
+	opts   Options
+}
+
+// NewRestorer creates a restorer using client, such as a clientset built
+// from a kubeconfig
+func NewRestorer(client kubernetes.Interface, opts Options) (*Restorer, error) {
+	if opts.OnConflict == "" {
+		opts.OnConflict = ConflictSkip
+	}
+	if _, err := ParseConflictPolicy(string(opts.OnConflict)); err != nil {
+		return nil, err
+	}
+	return &Restorer{client: client, opts: opts}, nil
+}
+
+// Restore creates secret in the cluster, applying the conflict policy when a
+// secret with the same name exists
+func (r *Restorer) Restore(ctx context.Context, secret *corev1.Secret) Result {
+	result := Result{Namespace: secret.Namespace, Name: secret.Name, RestoredAs: secret.Name}
+
+	restored := prepare(secret)
+	secrets := r.client.CoreV1().Secrets(restored.Namespace)
+
+	existing, err := secrets.Get(ctx, restored.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return r.create(ctx, restored, result, ActionCreated)
+	case err != nil:
+		return failed(result, fmt.Errorf("failed to look up existing secret: %w", err))
+	}
+
+	switch r.opts.OnConflict {
+	case ConflictOverwrite:
+		result.Action = ActionUpdated
+		if r.opts.DryRun {
+			return result
+		}
+		restored.ResourceVersion = existing.ResourceVersion
+		if _, err := secrets.Update(ctx, restored, metav1.UpdateOptions{}); err != nil {
+			return failed(result, fmt.Errorf("failed to update secret: %w", err))
+		}
+		return result
+	case ConflictRename:
+		name, err := r.freeName(ctx, restored.Namespace, restored.Name)
+		if err != nil {
+			return failed(result, err)
+		}
+		restored.Name = name
+		result.RestoredAs = name
+		return r.create(ctx, restored, result, ActionRenamed)
+	default:
+		result.Action = ActionSkipped
+		return result
+	}
+}
+
+// create writes a secret that does not exist yet
+func (r *Restorer) create(ctx context.Context, secret *corev1.Secret, result Result, action Action) Result {
+	result.Action = action
+	if r.opts.DryRun {
+		return result
+	}
+	if _, err := r.client.CoreV1().Secrets(secret.Namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		return failed(result, fmt.Errorf("failed to create secret: %w", err))
+	}
+	return result
+}
+
+// freeName finds the first unused <name>-restored[-N] name in namespace
+func (r *Restorer) freeName(ctx context.Context, namespace, name string) (string, error) {
+	for i := 1; i <= maxRenameAttempts; i++ {
+		candidate := name + "-restored"
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-restored-%d", name, i)
+		}
+
+		_, err := r.client.CoreV1().Secrets(namespace).Get(ctx, candidate, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to look up secret %s: %w", candidate, err)
+		}
+	}
+	return "", fmt.Errorf("no free name found for %s after %d attempts", name, maxRenameAttempts)
+}
+
+// prepare copies secret without the metadata the API server assigns
+// Owner references are dropped too: their owners may not exist in the
+// cluster, and the garbage collector would delete the restored secret
+func prepare(secret *corev1.Secret) *corev1.Secret {
+	restored := secret.DeepCopy()
+	restored.ResourceVersion = ""
+	restored.UID = ""
+	restored.ManagedFields = nil
+	restored.CreationTimestamp = metav1.Time{}
+	restored.OwnerReferences = nil
+	restored.Generation = 0
+	return restored
+}
+
+func failed(result Result, err error) Result {
+	result.Action = ActionFailed
+	result.Err = err
+	return result
+}
+
+// Report collects the results of a restore run
+type Report struct {
+	Results []Result
+}
+
+// Add records a result
+func (r *Report) Add(result Result) {
+	r.Results = append(r.Results, result)
+}
+
+// Count returns how many secrets ended with action
+func (r *Report) Count(action Action) int {
+	n := 0
+	for _, result := range r.Results {
+		if result.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed reports whether any secret could not be restored
+func (r *Report) Failed() bool {
+	return r.Count(ActionFailed) > 0
+}
+
+// Summary describes the run in one line
+func (r *Report) Summary() string {
+	return fmt.Sprintf("%d secrets: %d created, %d updated, %d renamed, %d skipped, %d failed",
+		len(r.Results), r.Count(ActionCreated), r.Count(ActionUpdated), r.Count(ActionRenamed),
+		r.Count(ActionSkipped), r.Count(ActionFailed))
+}
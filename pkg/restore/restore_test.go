@@ -0,0 +1,246 @@
+package restore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// snapshotSecret returns a secret as decoded from a snapshot, with the
+// metadata the API server had assigned
+func snapshotSecret(namespace, name, value string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         namespace,
+			UID:               types.UID("old-uid"),
+			ResourceVersion:   "42",
+			CreationTimestamp: metav1.Now(),
+			Labels:            map[string]string{"app": "web"},
+			OwnerReferences:   []metav1.OwnerReference{{APIVersion: "v1", Kind: "ServiceAccount", Name: "gone", UID: "gone-uid"}},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{"password": []byte(value)},
+	}
+}
+
+// liveSecret returns a secret already present in the cluster
+func liveSecret(namespace, name, value string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, ResourceVersion: "7"},
+		Data:       map[string][]byte{"password": []byte(value)},
+	}
+}
+
+func getPassword(t *testing.T, client *fake.Clientset, namespace, name string) string {
+	t.Helper()
+
+	secret, err := client.CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get(%s/%s) error: %v", namespace, name, err)
+	}
+	return string(secret.Data["password"])
+}
+
+func TestRestore(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   []runtime.Object
+		opts       Options
+		wantAction Action
+		wantName   string
+		// wantStored is the password stored under wantName afterwards
+		wantStored string
+	}{
+		{
+			name:       "Missing secret is created",
+			wantAction: ActionCreated,
+			wantName:   "db",
+			wantStored: "from-snapshot",
+		},
+		{
+			name:       "Existing secret is skipped by default",
+			existing:   []runtime.Object{liveSecret("prod", "db", "live")},
+			wantAction: ActionSkipped,
+			wantName:   "db",
+			wantStored: "live",
+		},
+		{
+			name:       "Overwrite",
+			existing:   []runtime.Object{liveSecret("prod", "db", "live")},
+			opts:       Options{OnConflict: ConflictOverwrite},
+			wantAction: ActionUpdated,
+			wantName:   "db",
+			wantStored: "from-snapshot",
+		},
+		{
+			name:       "Rename",
+			existing:   []runtime.Object{liveSecret("prod", "db", "live")},
+			opts:       Options{OnConflict: ConflictRename},
+			wantAction: ActionRenamed,
+			wantName:   "db-restored",
+			wantStored: "from-snapshot",
+		},
+		{
+			name:       "Rename picks the next free name",
+			existing:   []runtime.Object{liveSecret("prod", "db", "live"), liveSecret("prod", "db-restored", "earlier")},
+			opts:       Options{OnConflict: ConflictRename},
+			wantAction: ActionRenamed,
+			wantName:   "db-restored-2",
+			wantStored: "from-snapshot",
+		},
+		{
+			name:       "Dry run overwrite leaves the cluster alone",
+			existing:   []runtime.Object{liveSecret("prod", "db", "live")},
+			opts:       Options{OnConflict: ConflictOverwrite, DryRun: true},
+			wantAction: ActionUpdated,
+			wantName:   "db",
+			wantStored: "live",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(tt.existing...)
+			r, err := NewRestorer(client, tt.opts)
+			if err != nil {
+				t.Fatalf("NewRestorer() error: %v", err)
+			}
+
+			result := r.Restore(context.Background(), snapshotSecret("prod", "db", "from-snapshot"))
+			if result.Err != nil {
+				t.Fatalf("Restore() error: %v", result.Err)
+			}
+			if result.Action != tt.wantAction || result.RestoredAs != tt.wantName {
+				t.Errorf("Restore() = %s as %q, want %s as %q", result.Action, result.RestoredAs, tt.wantAction, tt.wantName)
+			}
+			if got := getPassword(t, client, "prod", tt.wantName); got != tt.wantStored {
+				t.Errorf("stored password = %q, want %q", got, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestRestoreStripsServerMetadata(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r, _ := NewRestorer(client, Options{})
+
+	original := snapshotSecret("prod", "db", "pw")
+	if result := r.Restore(context.Background(), original); result.Err != nil {
+		t.Fatalf("Restore() error: %v", result.Err)
+	}
+
+	actions := client.Actions()
+	created := actions[len(actions)-1].(k8stesting.CreateAction).GetObject().(*corev1.Secret)
+	if created.UID != "" || created.ResourceVersion != "" || !created.CreationTimestamp.IsZero() {
+		t.Errorf("created secret kept server metadata: %+v", created.ObjectMeta)
+	}
+	if len(created.OwnerReferences) != 0 {
+		t.Errorf("created secret kept owner references: %v", created.OwnerReferences)
+	}
+	if created.Labels["app"] != "web" || created.Type != corev1.SecretTypeOpaque {
+		t.Errorf("created secret lost labels or type: %+v", created)
+	}
+	if original.UID == "" {
+		t.Errorf("Restore() modified the input secret")
+	}
+}
+
+func TestRestoreDryRunDoesNotWrite(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r, _ := NewRestorer(client, Options{DryRun: true})
+
+	result := r.Restore(context.Background(), snapshotSecret("prod", "db", "pw"))
+	if result.Action != ActionCreated {
+		t.Errorf("Restore() action = %s, want %s", result.Action, ActionCreated)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() != "get" {
+			t.Errorf("dry run issued a %s request", action.GetVerb())
+		}
+	}
+}
+
+func TestRestoreAPIError(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("admission webhook denied the request")
+	})
+	r, _ := NewRestorer(client, Options{})
+
+	result := r.Restore(context.Background(), snapshotSecret("prod", "db", "pw"))
+	if result.Action != ActionFailed || result.Err == nil || !strings.Contains(result.Err.Error(), "denied") {
+		t.Errorf("Restore() = %+v, want failure", result)
+	}
+}
+
+func TestNewRestorerRejectsUnknownPolicy(t *testing.T) {
+	if _, err := NewRestorer(fake.NewSimpleClientset(), Options{OnConflict: "merge"}); err == nil {
+		t.Errorf("NewRestorer() expected error for unknown policy")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		filter    Filter
+		namespace string
+		name      string
+		want      bool
+	}{
+		{filter: Filter{All: true}, namespace: "prod", name: "db", want: true},
+		{filter: Filter{Namespace: "prod"}, namespace: "prod", name: "db", want: true},
+		{filter: Filter{Namespace: "prod"}, namespace: "staging", name: "db", want: false},
+		{filter: Filter{Namespace: "team-*", Name: "*-tls"}, namespace: "team-a", name: "web-tls", want: true},
+		{filter: Filter{Namespace: "team-*", Name: "*-tls"}, namespace: "team-a", name: "web-token", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.Match(tt.namespace, tt.name); got != tt.want {
+			t.Errorf("%+v.Match(%s, %s) = %v, want %v", tt.filter, tt.namespace, tt.name, got, tt.want)
+		}
+	}
+
+	if err := (Filter{Name: "[bad"}).Validate(); err == nil {
+		t.Errorf("Validate() expected error for malformed pattern")
+	}
+	// Restoring every secret of a snapshot has to be asked for
+	if err := (Filter{}).Validate(); !errors.Is(err, ErrNoFilter) {
+		t.Errorf("Validate() of an empty filter error = %v, want %v", err, ErrNoFilter)
+	}
+	if err := (Filter{All: true, Namespace: "prod"}).Validate(); err == nil {
+		t.Errorf("Validate() expected error for All with a pattern")
+	}
+	for _, f := range []Filter{{All: true}, {Namespace: "prod"}, {Name: "*-tls"}} {
+		if err := f.Validate(); err != nil {
+			t.Errorf("%+v.Validate() error: %v", f, err)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	var report Report
+	report.Add(Result{Action: ActionCreated})
+	report.Add(Result{Action: ActionCreated})
+	report.Add(Result{Action: ActionSkipped})
+
+	if report.Failed() {
+		t.Errorf("Failed() = true without failures")
+	}
+	want := "3 secrets: 2 created, 0 updated, 0 renamed, 1 skipped, 0 failed"
+	if got := report.Summary(); got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+
+	report.Add(Result{Action: ActionFailed, Err: errors.New("boom")})
+	if !report.Failed() {
+		t.Errorf("Failed() = false with a failure")
+	}
+}
@@ -0,0 +1,269 @@
+// Package snapdiff compares two etcd snapshots key by key, and the data keys
+// of the Secrets and ConfigMaps they hold
+package snapdiff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/codanael/etcd-secret-reader/pkg/decrypt"
+	"github.com/codanael/etcd-secret-reader/pkg/etcdreader"
+	"github.com/codanael/etcd-secret-reader/pkg/resource"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ChangeType says how a key or a data field differs between two snapshots
+type ChangeType string
+
+const (
+	Added    ChangeType = "added"
+	Removed  ChangeType = "removed"
+	Modified ChangeType = "modified"
+)
+
+// Change is a key that differs between the old and the new snapshot
+type Change struct {
+	Key  string     `json:"key"`
+	Type ChangeType `json:"type"`
+	// Resource, Namespace and Name are empty for keys that do not hold a
+	// Kubernetes object
+	Resource  string `json:"resource,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// OldRevision and NewRevision are the mod revisions on each side, 0 on
+	// the side missing the key
+	OldRevision int64 `json:"oldRevision,omitempty"`
+	NewRevision int64 `json:"newRevision,omitempty"`
+	// Fields lists the data keys that differ, for Secrets and ConfigMaps
+	// present on both sides
+	Fields []FieldChange `json:"fields,omitempty"`
+	// Error explains why the data keys of a Secret or ConfigMap could not
+	// be compared
+	Error string `json:"error,omitempty"`
+}
+
+// FieldChange is a data key of a Secret or ConfigMap that differs
+type FieldChange struct {
+	Field string     `json:"field"`
+	Type  ChangeType `json:"type"`
+	Old   []byte     `json:"old,omitempty"`
+	New   []byte     `json:"new,omitempty"`
+}
+
+// Options selects what is compared
+type Options struct {
+	// Namespace restricts the comparison to one namespace; cluster-scoped
+	// objects and other keys are then left out
+	Namespace string
+	// Decryptor returns the decryptor for a group resource such as
+	// "secrets"; without one, data keys are not compared
+	Decryptor func(groupResource string) (decrypt.ValueDecryptor, error)
+}
+
+// Hide returns a copy of changes without the data values that reveals does
+// not accept, for outputs that cannot mask them; changes are left intact, so
+// their values can still be masked elsewhere
+func Hide(changes []Change, reveals func(field string) bool) []Change {
+	if changes == nil {
+		return nil
+	}
+	hidden := make([]Change, len(changes))
+	for i, c := range changes {
+		hidden[i] = c
+		if c.Fields == nil {
+			continue
+		}
+		hidden[i].Fields = make([]FieldChange, len(c.Fields))
+		for j, f := range c.Fields {
+			if !reveals(f.Field) {
+				f.Old, f.New = nil, nil
+			}
+			hidden[i].Fields[j] = f
+		}
+	}
+	return hidden
+}
+
+// comparedResources are the resources whose data keys are compared
+var comparedResources = map[string]bool{"secrets": true, "configmaps": true}
+
+// Compare returns the keys that differ between the latest revisions of two
+// snapshots, sorted by resource, namespace and key
+func Compare(oldReader, newReader *etcdreader.Reader, opts Options) ([]Change, error) {
+	oldKVs, err := oldReader.Range("\x00", "\x00", 0)
+	if err != nil {
+		return nil, fmt.Errorf("reading old snapshot: %w", err)
+	}
+	newKVs, err := newReader.Range("\x00", "\x00", 0)
+	if err != nil {
+		return nil, fmt.Errorf("reading new snapshot: %w", err)
+	}
+
+	c := &comparer{opts: opts, decryptors: map[string]decrypt.ValueDecryptor{}}
+	defer c.close()
+
+	var changes []Change
+	i, j := 0, 0
+	for i < len(oldKVs) || j < len(newKVs) {
+		var oldKV, newKV *mvccpb.KeyValue
+		switch {
+		case j == len(newKVs) || (i < len(oldKVs) && string(oldKVs[i].Key) < string(newKVs[j].Key)):
+			oldKV = oldKVs[i]
+			i++
+		case i == len(oldKVs) || string(newKVs[j].Key) < string(oldKVs[i].Key):
+			newKV = newKVs[j]
+			j++
+		default:
+			oldKV, newKV = oldKVs[i], newKVs[j]
+			i++
+			j++
+		}
+
+		change, ok := c.compare(oldKV, newKV)
+		if ok {
+			changes = append(changes, change)
+		}
+	}
+
+	sort.SliceStable(changes, func(a, b int) bool {
+		if changes[a].Resource != changes[b].Resource {
+			return changes[a].Resource < changes[b].Resource
+		}
+		return changes[a].Namespace < changes[b].Namespace
+	})
+	return changes, nil
+}
+
+// comparer compares records, caching a decryptor per resource
+type comparer struct {
+	opts       Options
+	decryptors map[string]decrypt.ValueDecryptor
+}
+
+// compare returns the change between two records of the same key, either of
+// which may be nil, and whether there is one
+func (c *comparer) compare(oldKV, newKV *mvccpb.KeyValue) (Change, bool) {
+	var change Change
+	switch {
+	case newKV == nil:
+		change = Change{Key: string(oldKV.Key), Type: Removed, OldRevision: oldKV.ModRevision}
+	case oldKV == nil:
+		change = Change{Key: string(newKV.Key), Type: Added, NewRevision: newKV.ModRevision}
+	case bytes.Equal(oldKV.Value, newKV.Value):
+		return Change{}, false
+	default:
+		change = Change{Key: string(newKV.Key), Type: Modified, OldRevision: oldKV.ModRevision, NewRevision: newKV.ModRevision}
+	}
+
+	if key, err := resource.ParseKey(change.Key); err == nil {
+		change.Resource = key.Resource.GroupResource()
+		change.Namespace = key.Namespace
+		change.Name = key.Name
+	}
+	if c.opts.Namespace != "" && change.Namespace != c.opts.Namespace {
+		return Change{}, false
+	}
+
+	if change.Type == Modified && comparedResources[change.Resource] && c.opts.Decryptor != nil {
+		fields, err := c.compareData(change, oldKV.Value, newKV.Value)
+		if err != nil {
+			change.Error = err.Error()
+		}
+		change.Fields = fields
+	}
+	return change, true
+}
+
+// compareData decrypts both values of a Secret or ConfigMap and returns the
+// data keys that differ, sorted by name
+func (c *comparer) compareData(change Change, oldValue, newValue []byte) ([]FieldChange, error) {
+	decryptor, err := c.decryptor(change.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	oldData, err := objectData(decryptor, change.Key, oldValue)
+	if err != nil {
+		return nil, fmt.Errorf("old value: %w", err)
+	}
+	newData, err := objectData(decryptor, change.Key, newValue)
+	if err != nil {
+		return nil, fmt.Errorf("new value: %w", err)
+	}
+
+	var fields []FieldChange
+	for field, oldValue := range oldData {
+		newValue, ok := newData[field]
+		switch {
+		case !ok:
+			fields = append(fields, FieldChange{Field: field, Type: Removed, Old: oldValue})
+		case !bytes.Equal(oldValue, newValue):
+			fields = append(fields, FieldChange{Field: field, Type: Modified, Old: oldValue, New: newValue})
+		}
+	}
+	for field, newValue := range newData {
+		if _, ok := oldData[field]; !ok {
+			fields = append(fields, FieldChange{Field: field, Type: Added, New: newValue})
+		}
+	}
+
+	sort.Slice(fields, func(a, b int) bool { return fields[a].Field < fields[b].Field })
+	return fields, nil
+}
+
+func (c *comparer) decryptor(groupResource string) (decrypt.ValueDecryptor, error) {
+	if d, ok := c.decryptors[groupResource]; ok {
+		return d, nil
+	}
+	d, err := c.opts.Decryptor(groupResource)
+	if err != nil {
+		return nil, err
+	}
+	c.decryptors[groupResource] = d
+	return d, nil
+}
+
+func (c *comparer) close() {
+	for _, d := range c.decryptors {
+		if closer, ok := d.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// objectData decrypts and decodes a Secret or ConfigMap and returns its data
+// keys; ConfigMap binaryData keys are included, as they share the key space
+func objectData(decryptor decrypt.ValueDecryptor, etcdKey string, value []byte) (map[string][]byte, error) {
+	plaintext, err := decryptor.DecryptValue(etcdKey, value)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := resource.Decode(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string][]byte{}
+	switch o := obj.(type) {
+	case *corev1.Secret:
+		for k, v := range o.Data {
+			data[k] = v
+		}
+		for k, v := range o.StringData {
+			data[k] = []byte(v)
+		}
+	case *corev1.ConfigMap:
+		for k, v := range o.Data {
+			data[k] = []byte(v)
+		}
+		for k, v := range o.BinaryData {
+			data[k] = v
+		}
+	default:
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+	return data, nil
+}
@@ -0,0 +1,187 @@
+package snapdiff
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/codanael/etcd-secret-reader/pkg/decrypt"
+	"github.com/codanael/etcd-secret-reader/pkg/etcdreader"
+	"github.com/codanael/etcd-secret-reader/pkg/redact"
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/server/v3/mvcc/buckets"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// kv is a key and value stored in a test snapshot, at revision rev
+type kv struct {
+	key   string
+	value string
+	rev   int64
+}
+
+// openSnapshot writes kvs to a new snapshot and opens it
+func openSnapshot(t *testing.T, kvs []kv) *etcdreader.Reader {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "snapshot.db")
+	db, err := bolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(buckets.Key.Name())
+		if err != nil {
+			return err
+		}
+		for _, o := range kvs {
+			revBytes := make([]byte, 17)
+			binary.BigEndian.PutUint64(revBytes[0:8], uint64(o.rev))
+			revBytes[8] = '_'
+
+			record := &mvccpb.KeyValue{Key: []byte(o.key), Value: []byte(o.value), CreateRevision: o.rev, ModRevision: o.rev, Version: 1}
+			data, err := record.Marshal()
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(revBytes, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatalf("Failed to populate test database: %v", err)
+	}
+
+	reader, err := etcdreader.NewReader(dbPath)
+	if err != nil {
+		t.Fatalf("NewReader() error: %v", err)
+	}
+	t.Cleanup(func() { reader.Close() })
+	return reader
+}
+
+func secretJSON(t *testing.T, data map[string]string) string {
+	t.Helper()
+
+	secret := &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "prod"},
+		Data:       map[string][]byte{},
+	}
+	for k, v := range data {
+		secret.Data[k] = []byte(v)
+	}
+	out, err := json.Marshal(secret)
+	if err != nil {
+		t.Fatalf("json.Marshal() error: %v", err)
+	}
+	return string(out)
+}
+
+func TestCompare(t *testing.T) {
+	oldReader := openSnapshot(t, []kv{
+		{key: "/registry/secrets/prod/db", value: secretJSON(t, map[string]string{"password": "old", "user": "app", "legacy": "x"}), rev: 1},
+		{key: "/registry/secrets/prod/gone", value: secretJSON(t, nil), rev: 2},
+		{key: "/registry/configmaps/default/same", value: `{"apiVersion":"v1","kind":"ConfigMap"}`, rev: 3},
+		{key: "/registry/secrets/default/broken", value: "k8s:enc:aescbc:v1:key1:xxxx", rev: 4},
+		{key: "compact_rev_key", value: "a", rev: 5},
+	})
+	newReader := openSnapshot(t, []kv{
+		{key: "/registry/configmaps/default/same", value: `{"apiVersion":"v1","kind":"ConfigMap"}`, rev: 3},
+		{key: "/registry/secrets/default/broken", value: "k8s:enc:aescbc:v1:key1:yyyy", rev: 6},
+		{key: "/registry/secrets/prod/db", value: secretJSON(t, map[string]string{"password": "new", "user": "app", "token": "t"}), rev: 7},
+		{key: "/registry/deployments/prod/web", value: "{}", rev: 8},
+		{key: "compact_rev_key", value: "b", rev: 9},
+	})
+
+	identity := func(string) (decrypt.ValueDecryptor, error) { return decrypt.IdentityDecryptor{}, nil }
+
+	tests := []struct {
+		name string
+		opts Options
+		want []Change
+	}{
+		{
+			name: "all keys",
+			opts: Options{Decryptor: identity},
+			want: []Change{
+				{Key: "compact_rev_key", Type: Modified, OldRevision: 5, NewRevision: 9},
+				{Key: "/registry/deployments/prod/web", Type: Added, Resource: "deployments.apps", Namespace: "prod", Name: "web", NewRevision: 8},
+				{
+					Key: "/registry/secrets/default/broken", Type: Modified, Resource: "secrets", Namespace: "default", Name: "broken",
+					OldRevision: 4, NewRevision: 6, Error: "old value: identity provider cannot read encrypted data",
+				},
+				{
+					Key: "/registry/secrets/prod/db", Type: Modified, Resource: "secrets", Namespace: "prod", Name: "db",
+					OldRevision: 1, NewRevision: 7,
+					Fields: []FieldChange{
+						{Field: "legacy", Type: Removed, Old: []byte("x")},
+						{Field: "password", Type: Modified, Old: []byte("old"), New: []byte("new")},
+						{Field: "token", Type: Added, New: []byte("t")},
+					},
+				},
+				{Key: "/registry/secrets/prod/gone", Type: Removed, Resource: "secrets", Namespace: "prod", Name: "gone", OldRevision: 2},
+			},
+		},
+		{
+			name: "one namespace without decryption",
+			opts: Options{Namespace: "default"},
+			want: []Change{
+				{Key: "/registry/secrets/default/broken", Type: Modified, Resource: "secrets", Namespace: "default", Name: "broken", OldRevision: 4, NewRevision: 6},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Compare(oldReader, newReader, tt.opts)
+			if err != nil {
+				t.Fatalf("Compare() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.MarshalIndent(got, "", "  ")
+				t.Errorf("Compare() =\n%s", gotJSON)
+			}
+		})
+	}
+}
+
+func TestHide(t *testing.T) {
+	changes := []Change{
+		{Key: "/registry/secrets/prod/db", Type: Modified, Fields: []FieldChange{
+			{Field: "password", Type: Modified, Old: []byte("old-pw"), New: []byte("new-password")},
+			{Field: "user", Type: Modified, Old: []byte("app"), New: []byte("admin")},
+		}},
+		{Key: "/registry/deployments/prod/web", Type: Added},
+	}
+
+	hidden := Hide(changes, func(field string) bool { return field == "user" })
+	want := []Change{
+		{Key: "/registry/secrets/prod/db", Type: Modified, Fields: []FieldChange{
+			{Field: "password", Type: Modified},
+			{Field: "user", Type: Modified, Old: []byte("app"), New: []byte("admin")},
+		}},
+		{Key: "/registry/deployments/prod/web", Type: Added},
+	}
+	if !reflect.DeepEqual(hidden, want) {
+		gotJSON, _ := json.MarshalIndent(hidden, "", "  ")
+		t.Errorf("Hide() =\n%s", gotJSON)
+	}
+
+	// The text output masks the values Hide leaves out, so they must stay,
+	// and the masks must tell the old and new values apart
+	password := changes[0].Fields[0]
+	oldMask, newMask := redact.Mask(password.Old), redact.Mask(password.New)
+	if !strings.Contains(oldMask, "6 bytes") || !strings.Contains(newMask, "12 bytes") || oldMask[len(oldMask)-13:] == newMask[len(newMask)-13:] {
+		t.Errorf("masks after Hide() = %s -> %s, want the lengths and fingerprints of the values", oldMask, newMask)
+	}
+}
@@ -0,0 +1,114 @@
+package source
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fakeGCS is an in-process stand-in for the media downloads of the GCS
+// JSON API; objects are keyed by bucket/object
+func fakeGCS(t *testing.T, token string, objects map[string]string, hashes map[string]string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"error":{"code":401,"message":"Anonymous caller does not have storage.objects.get access."}}`)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.EscapedPath(), "/storage/v1/b/")
+		bucket, object, _ := strings.Cut(name, "/o/")
+		object, _ = url.PathUnescape(object)
+		key := bucket + "/" + object
+
+		content, ok := objects[key]
+		if r.URL.Query().Get("alt") != "media" || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error":{"code":404,"message":"No such object: `+key+`"}}`)
+			return
+		}
+		w.Header().Set("X-Goog-Hash", hashes[key])
+		io.WriteString(w, content)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func gcsHash(content string) string {
+	md5Sum := md5.Sum([]byte(content))
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum([]byte(content), crc32.MakeTable(crc32.Castagnoli)))
+	return "crc32c=" + base64.StdEncoding.EncodeToString(crc) + ",md5=" + base64.StdEncoding.EncodeToString(md5Sum[:])
+}
+
+func TestGCSOpen(t *testing.T) {
+	const content = "etcd snapshot"
+	endpoint := fakeGCS(t, "token",
+		map[string]string{"backups/etcd/snapshot.db": content, "backups/corrupt.db": content},
+		map[string]string{"backups/etcd/snapshot.db": gcsHash(content), "backups/corrupt.db": gcsHash("something else")},
+	)
+	gcs := &GCS{Endpoint: endpoint, Token: "token"}
+
+	o, err := gcs.Open(context.Background(), mustParseURL(t, "gs://backups/etcd/snapshot.db"))
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if len(o.Checksums()) != 2 {
+		t.Errorf("Checksums() = %v, want crc32c and md5", o.Checksums())
+	}
+	data, err := io.ReadAll(o)
+	o.Close()
+	if err != nil || string(data) != content {
+		t.Errorf("ReadAll() = %q, %v, want %q", data, err, content)
+	}
+
+	o, err = gcs.Open(context.Background(), mustParseURL(t, "gs://backups/corrupt.db"))
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if _, err := io.ReadAll(o); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("ReadAll() error = %v, want checksum mismatch", err)
+	}
+	o.Close()
+
+	if _, err := gcs.Open(context.Background(), mustParseURL(t, "gs://backups/missing.db")); err == nil || !strings.Contains(err.Error(), "No such object") {
+		t.Errorf("Open() of a missing object error = %v, want No such object", err)
+	}
+}
+
+func TestGCSEnvironment(t *testing.T) {
+	endpoint := fakeGCS(t, "env-token", map[string]string{"backups/snapshot.db": "content"}, nil)
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(endpoint, "http://"))
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "env-token")
+
+	o, err := Open(context.Background(), "gs://backups/snapshot.db")
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	o.Close()
+
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+	if _, err := Open(context.Background(), "gs://backups/snapshot.db"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Open() without a token error = %v, want 401", err)
+	}
+}
+
+func mustParseURL(t *testing.T, ref string) *url.URL {
+	t.Helper()
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		t.Fatalf("url.Parse(%q) error: %v", ref, err)
+	}
+	return u
+}
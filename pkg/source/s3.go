@@ -0,0 +1,131 @@
+package source
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3 opens s3:// URLs, such as s3://backups/etcd/snapshot.db, from AWS S3 or
+// any S3-compatible storage such as MinIO. Credentials are read from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, from
+// MINIO_ROOT_USER and MINIO_ROOT_PASSWORD, or from the AWS shared credentials
+// file; objects are read anonymously without any.
+type S3 struct {
+	// Endpoint is the URL of the storage, such as http://minio.local:9000;
+	// empty means $AWS_ENDPOINT_URL_S3, $AWS_ENDPOINT_URL or AWS S3
+	Endpoint string
+	// Region is the region of the bucket; empty means $AWS_REGION,
+	// $AWS_DEFAULT_REGION or us-east-1
+	Region string
+}
+
+// Open starts downloading the object at u
+func (s *S3) Open(ctx context.Context, u *url.URL) (*Object, error) {
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("S3 URLs name a bucket and an object, as in s3://backups/etcd/snapshot.db")
+	}
+
+	host, secure, err := s.endpoint()
+	if err != nil {
+		return nil, err
+	}
+	client, err := minio.New(host, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+		}),
+		Secure: secure,
+		Region: firstNonEmpty(s.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+
+	// Checksum mode asks for the checksums stored with the object
+	obj, err := client.GetObject(ctx, bucket, key, minio.GetObjectOptions{Checksum: true})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", u.Redacted(), err)
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, fmt.Errorf("%s: %w", u.Redacted(), err)
+	}
+	return NewObject(obj, info.Size, s3Checksums(info)), nil
+}
+
+// endpoint returns the host and whether TLS is used for the storage
+func (s *S3) endpoint() (string, bool, error) {
+	endpoint := firstNonEmpty(s.Endpoint, os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"))
+	if endpoint == "" {
+		return "s3.amazonaws.com", true, nil
+	}
+	if !strings.Contains(endpoint, "://") {
+		return endpoint, true, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	switch u.Scheme {
+	case "https":
+		return u.Host, true, nil
+	case "http":
+		return u.Host, false, nil
+	default:
+		return "", false, fmt.Errorf("invalid S3 endpoint %q: expected an http or https URL", endpoint)
+	}
+}
+
+// s3Checksums returns the checksums of an object that can be verified.
+// Multipart uploads publish checksums of their parts, suffixed with the part
+// count, which are skipped; the ETag is the MD5 of the content only for
+// objects uploaded in one part, unencrypted or encrypted with SSE-S3.
+func s3Checksums(info minio.ObjectInfo) []Checksum {
+	var checksums []Checksum
+	for _, c := range []struct{ algorithm, value string }{
+		{SHA256, info.ChecksumSHA256},
+		{CRC32C, info.ChecksumCRC32C},
+	} {
+		if c.value == "" || strings.Contains(c.value, "-") {
+			continue
+		}
+		if sum, err := base64.StdEncoding.DecodeString(c.value); err == nil {
+			checksums = append(checksums, Checksum{Algorithm: c.algorithm, Value: sum})
+		}
+	}
+	if len(checksums) > 0 {
+		return checksums
+	}
+
+	sse := info.Metadata.Get("X-Amz-Server-Side-Encryption")
+	customerKey := info.Metadata.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm")
+	if (sse != "" && sse != "AES256") || customerKey != "" {
+		return nil
+	}
+	if sum, err := hex.DecodeString(info.ETag); err == nil && len(sum) == 16 {
+		return []Checksum{{Algorithm: MD5, Value: sum}}
+	}
+	return nil
+}
+
+// firstNonEmpty returns the first of values that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,190 @@
+package source
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeS3Object is an object served by fakeS3, with the headers S3 would
+// send for it
+type fakeS3Object struct {
+	content string
+	etag    string
+	sha256  string
+	sse     string
+}
+
+// fakeS3 is an in-process stand-in for S3, serving the HEAD and GET requests
+// of objects by path-style URL, /bucket/key
+type fakeS3 struct {
+	objects map[string]fakeS3Object
+	// requests records the authorization header of each request
+	requests []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests = append(f.requests, r.Header.Get("Authorization"))
+
+	obj, ok := f.objects[r.URL.Path]
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || !ok {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message><Key>%s</Key></Error>`, r.URL.Path)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Length", fmt.Sprint(len(obj.content)))
+	h.Set("Last-Modified", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat))
+	h.Set("ETag", `"`+obj.etag+`"`)
+	if obj.sse != "" {
+		h.Set("X-Amz-Server-Side-Encryption", obj.sse)
+	}
+	if obj.sha256 != "" && r.Header.Get("X-Amz-Checksum-Mode") == "ENABLED" {
+		h.Set("X-Amz-Checksum-Sha256", obj.sha256)
+	}
+	if r.Method == http.MethodGet {
+		io.WriteString(w, obj.content)
+	}
+}
+
+// startFakeS3 serves objects and points the S3 source at them
+func startFakeS3(t *testing.T, objects map[string]fakeS3Object) (*fakeS3, *S3) {
+	t.Helper()
+
+	fake := &fakeS3{objects: objects}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, &S3{Endpoint: server.URL, Region: "us-east-1"}
+}
+
+func md5Hex(content string) string {
+	sum := md5.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Base64(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestS3Open(t *testing.T) {
+	const content = "etcd snapshot"
+	_, s3 := startFakeS3(t, map[string]fakeS3Object{
+		"/backups/md5.db":       {content: content, etag: md5Hex(content)},
+		"/backups/sha256.db":    {content: content, etag: "0123456789abcdef0123456789abcdef", sha256: sha256Base64(content)},
+		"/backups/corrupt.db":   {content: content, etag: md5Hex("something else")},
+		"/backups/multipart.db": {content: content, etag: md5Hex("parts") + "-3"},
+		"/backups/kms.db":       {content: content, etag: "0123456789abcdef0123456789abcdef", sse: "aws:kms"},
+	})
+
+	tests := []struct {
+		key       string
+		algorithm string
+		wantErr   error
+	}{
+		{key: "md5.db", algorithm: MD5},
+		{key: "sha256.db", algorithm: SHA256},
+		{key: "corrupt.db", algorithm: MD5, wantErr: ErrChecksumMismatch},
+		{key: "multipart.db"},
+		{key: "kms.db"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			u := mustParseURL(t, "s3://backups/"+tt.key)
+			o, err := s3.Open(context.Background(), u)
+			if err != nil {
+				t.Fatalf("Open() error: %v", err)
+			}
+			defer o.Close()
+
+			var algorithms []string
+			for _, c := range o.Checksums() {
+				algorithms = append(algorithms, c.Algorithm)
+			}
+			if got := strings.Join(algorithms, ","); got != tt.algorithm {
+				t.Errorf("Checksums() = %s, want %s", got, tt.algorithm)
+			}
+
+			data, err := io.ReadAll(o)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ReadAll() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || string(data) != content {
+				t.Errorf("ReadAll() = %q, %v, want %q", data, err, content)
+			}
+		})
+	}
+}
+
+func TestS3OpenErrors(t *testing.T) {
+	_, s3 := startFakeS3(t, nil)
+
+	for _, ref := range []string{"s3://backups/missing.db", "s3://backups", "s3:///snapshot.db"} {
+		if o, err := s3.Open(context.Background(), mustParseURL(t, ref)); err == nil {
+			o.Close()
+			t.Errorf("Open(%s) succeeded", ref)
+		}
+	}
+}
+
+func TestS3Credentials(t *testing.T) {
+	fake, s3 := startFakeS3(t, map[string]fakeS3Object{
+		"/backups/snapshot.db": {content: "content", etag: md5Hex("content")},
+	})
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIAEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	o, err := s3.Open(context.Background(), mustParseURL(t, "s3://backups/snapshot.db"))
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	o.Close()
+
+	if len(fake.requests) == 0 || !strings.Contains(fake.requests[0], "Credential=AKIAEXAMPLE/") {
+		t.Errorf("requests were not signed with the environment credentials: %q", fake.requests)
+	}
+}
+
+func TestS3Endpoint(t *testing.T) {
+	tests := []struct {
+		endpoint   string
+		env        string
+		wantHost   string
+		wantSecure bool
+	}{
+		{wantHost: "s3.amazonaws.com", wantSecure: true},
+		{endpoint: "http://minio.local:9000", wantHost: "minio.local:9000"},
+		{endpoint: "minio.local:9000", wantHost: "minio.local:9000", wantSecure: true},
+		{env: "https://s3.eu-west-1.amazonaws.com", wantHost: "s3.eu-west-1.amazonaws.com", wantSecure: true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("AWS_ENDPOINT_URL_S3", tt.env)
+		t.Setenv("AWS_ENDPOINT_URL", "")
+
+		host, secure, err := (&S3{Endpoint: tt.endpoint}).endpoint()
+		if err != nil {
+			t.Errorf("endpoint(%q) error: %v", tt.endpoint, err)
+			continue
+		}
+		if host != tt.wantHost || secure != tt.wantSecure {
+			t.Errorf("endpoint(%q, env %q) = %s, %v, want %s, %v", tt.endpoint, tt.env, host, secure, tt.wantHost, tt.wantSecure)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Package source reads snapshots from where backups are kept: local files,
+// S3-compatible object storage and Google Cloud Storage, addressed by URL
+package source
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Checksum algorithms verified while an object is read
+const (
+	MD5    = "md5"
+	SHA256 = "sha256"
+	CRC32C = "crc32c"
+)
+
+// ErrChecksumMismatch is returned when an object does not match a checksum
+// published by its source
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Source opens the objects of one URL scheme
+type Source interface {
+	// Open starts reading the object at u
+	Open(ctx context.Context, u *url.URL) (*Object, error)
+}
+
+// registry holds the sources by URL scheme
+var registry = struct {
+	sync.RWMutex
+	sources map[string]Source
+}{sources: map[string]Source{
+	"file": File{},
+	"s3":   &S3{},
+	"gs":   &GCS{},
+}}
+
+// Register makes s open the URLs of scheme, replacing any source registered
+// for it before
+func Register(scheme string, s Source) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.sources[strings.ToLower(scheme)] = s
+}
+
+// Schemes lists the registered URL schemes
+func Schemes() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	schemes := make([]string, 0, len(registry.sources))
+	for scheme := range registry.sources {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// lookup returns the source of a URL scheme
+func lookup(scheme string) (Source, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	s, ok := registry.sources[strings.ToLower(scheme)]
+	return s, ok
+}
+
+// IsURL reports whether ref is a URL of a registered scheme rather than a
+// file path
+func IsURL(ref string) bool {
+	scheme, _, ok := strings.Cut(ref, "://")
+	if !ok {
+		return false
+	}
+	_, ok = lookup(scheme)
+	return ok
+}
+
+// Open starts reading the object at ref, a URL of a registered scheme
+func Open(ctx context.Context, ref string) (*Object, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot URL: %w", err)
+	}
+	s, ok := lookup(u.Scheme)
+	if !ok {
+		return nil, fmt.Errorf("unsupported snapshot URL scheme %q (expected one of %s)", u.Scheme, strings.Join(Schemes(), ", "))
+	}
+	return s.Open(ctx, u)
+}
+
+// Checksum is a digest a source publishes for an object
+type Checksum struct {
+	Algorithm string
+	Value     []byte
+}
+
+// Object is an object being read from a source. Reading it to the end fails
+// with ErrChecksumMismatch when its content does not match a checksum
+// published by the source, or io.ErrUnexpectedEOF when it is shorter than
+// announced.
+type Object struct {
+	// Path is set when the object is a local file, which can be opened in
+	// place instead of being read
+	Path string
+
+	body      io.ReadCloser
+	size      int64
+	read      int64
+	checksums []Checksum
+	hashes    []hash.Hash
+}
+
+// NewObject wraps the body of an object for a Source; size is -1 when it is
+// unknown, and checksums of unknown algorithms are ignored
+func NewObject(body io.ReadCloser, size int64, checksums []Checksum) *Object {
+	o := &Object{body: body, size: size}
+	for _, c := range checksums {
+		var h hash.Hash
+		switch c.Algorithm {
+		case MD5:
+			h = md5.New()
+		case SHA256:
+			h = sha256.New()
+		case CRC32C:
+			h = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+		default:
+			continue
+		}
+		o.checksums = append(o.checksums, c)
+		o.hashes = append(o.hashes, h)
+	}
+	return o
+}
+
+// Checksums returns the checksums verified while the object is read
+func (o *Object) Checksums() []Checksum {
+	return o.checksums
+}
+
+// Read reads the object, verifying it once the end is reached
+func (o *Object) Read(p []byte) (int, error) {
+	n, err := o.body.Read(p)
+	o.read += int64(n)
+	for _, h := range o.hashes {
+		h.Write(p[:n])
+	}
+	if err == io.EOF {
+		if verifyErr := o.verify(); verifyErr != nil {
+			return n, verifyErr
+		}
+	}
+	return n, err
+}
+
+// verify compares what was read with the announced size and checksums
+func (o *Object) verify() error {
+	if o.size >= 0 && o.read != o.size {
+		return fmt.Errorf("object ended after %d of %d bytes: %w", o.read, o.size, io.ErrUnexpectedEOF)
+	}
+	for i, h := range o.hashes {
+		if sum := h.Sum(nil); !bytes.Equal(sum, o.checksums[i].Value) {
+			return fmt.Errorf("%w: %s of the object is %x, the source published %x", ErrChecksumMismatch, o.checksums[i].Algorithm, sum, o.checksums[i].Value)
+		}
+	}
+	return nil
+}
+
+// Close closes the object
+func (o *Object) Close() error {
+	return o.body.Close()
+}
@@ -0,0 +1,133 @@
+package source
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestObjectVerify(t *testing.T) {
+	content := "snapshot content"
+	md5Sum := md5.Sum([]byte(content))
+	sha256Sum := sha256.Sum256([]byte(content))
+
+	tests := []struct {
+		name      string
+		size      int64
+		checksums []Checksum
+		wantErr   error
+	}{
+		{name: "No checksums", size: -1},
+		{name: "Matching", size: int64(len(content)), checksums: []Checksum{{MD5, md5Sum[:]}, {SHA256, sha256Sum[:]}}},
+		{name: "Mismatch", size: -1, checksums: []Checksum{{MD5, md5Sum[:]}, {SHA256, md5Sum[:]}}, wantErr: ErrChecksumMismatch},
+		{name: "Short", size: int64(len(content)) + 1, wantErr: io.ErrUnexpectedEOF},
+		{name: "Unknown algorithm ignored", size: -1, checksums: []Checksum{{"whirlpool", []byte("x")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := NewObject(io.NopCloser(strings.NewReader(content)), tt.size, tt.checksums)
+			data, err := io.ReadAll(o)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ReadAll() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadAll() error: %v", err)
+			}
+			if string(data) != content {
+				t.Errorf("ReadAll() = %q, want %q", data, content)
+			}
+		})
+	}
+}
+
+func TestIsURL(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want bool
+	}{
+		{ref: "s3://backups/snapshot.db", want: true},
+		{ref: "gs://backups/snapshot.db", want: true},
+		{ref: "file:///var/backups/snapshot.db", want: true},
+		{ref: "S3://backups/snapshot.db", want: true},
+		{ref: "/var/backups/snapshot.db", want: false},
+		{ref: "snapshot.db", want: false},
+		{ref: "-", want: false},
+		{ref: "ftp://backups/snapshot.db", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := IsURL(tt.ref); got != tt.want {
+			t.Errorf("IsURL(%q) = %v, want %v", tt.ref, got, tt.want)
+		}
+	}
+}
+
+// memorySource serves objects from a map, by URL
+type memorySource map[string]string
+
+func (m memorySource) Open(ctx context.Context, u *url.URL) (*Object, error) {
+	content, ok := m[u.String()]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return NewObject(io.NopCloser(strings.NewReader(content)), int64(len(content)), nil), nil
+}
+
+func TestRegister(t *testing.T) {
+	Register("mem", memorySource{"mem://backups/snapshot.db": "content"})
+	t.Cleanup(func() {
+		registry.Lock()
+		delete(registry.sources, "mem")
+		registry.Unlock()
+	})
+
+	if !IsURL("mem://backups/snapshot.db") {
+		t.Error("IsURL() = false for a registered scheme")
+	}
+	o, err := Open(context.Background(), "mem://backups/snapshot.db")
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer o.Close()
+	if data, _ := io.ReadAll(o); string(data) != "content" {
+		t.Errorf("Open() content = %q, want content", data)
+	}
+
+	if _, err := Open(context.Background(), "ftp://backups/snapshot.db"); err == nil || !strings.Contains(err.Error(), "file, gs, mem, s3") {
+		t.Errorf("Open() of an unknown scheme error = %v, want the registered schemes", err)
+	}
+}
+
+func TestFileOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := os.WriteFile(path, []byte("content"), 0600); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	o, err := Open(context.Background(), "file://"+filepath.ToSlash(path))
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer o.Close()
+	if o.Path != path {
+		t.Errorf("Path = %q, want %q", o.Path, path)
+	}
+
+	if _, err := Open(context.Background(), "file://snapshot.db"); err == nil {
+		t.Error("Open() of a relative file URL succeeded")
+	}
+	if _, err := Open(context.Background(), "file://"+filepath.ToSlash(path)+".missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open() of a missing file error = %v, want not exist", err)
+	}
+}
@@ -179,6 +179,59 @@ func createTestSnapshotWithSecrets(t *testing.T, key []byte, keyName string, sec
 	return dbPath
 }
 
+// createTestSnapshotWithValues creates a test etcd snapshot holding the given
+// raw values, already encrypted by the caller
+func createTestSnapshotWithValues(t *testing.T, values map[string][]byte) string {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "test-snapshot.db")
+
+	db, err := bolt.Open(dbPath, 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(buckets.Key.Name())
+		if err != nil {
+			return err
+		}
+
+		rev := int64(1)
+		for path, value := range values {
+			revBytes := make([]byte, 17)
+			binary.BigEndian.PutUint64(revBytes[0:8], uint64(rev))
+			revBytes[8] = '_'
+
+			kv := &mvccpb.KeyValue{
+				Key:            []byte(path),
+				Value:          value,
+				CreateRevision: rev,
+				ModRevision:    rev,
+				Version:        1,
+			}
+			kvBytes, err := kv.Marshal()
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(revBytes, kvBytes); err != nil {
+				return err
+			}
+
+			rev++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to populate database: %v", err)
+	}
+
+	return dbPath
+}
+
 func TestEndToEndEncryptionDecryption(t *testing.T) {
 	// Generate encryption key
 	encryptionKey := make([]byte, 32)
@@ -283,6 +336,109 @@ func TestEndToEndEncryptionDecryption(t *testing.T) {
 	}
 }
 
+func TestEndToEndAESGCM(t *testing.T) {
+	encryptionKey := make([]byte, 16)
+	if _, err := rand.Read(encryptionKey); err != nil {
+		t.Fatalf("Failed to generate encryption key: %v", err)
+	}
+
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("Failed to create GCM: %v", err)
+	}
+
+	// The API server authenticates the etcd key with the ciphertext
+	path := "/registry/secrets/default/gcm-secret"
+	plaintext := []byte(`{"kind":"Secret","apiVersion":"v1","type":"Opaque","data":{"token":"c2VjcmV0"}}`)
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	value := append([]byte("k8s:enc:aesgcm:v1:key1:"), aead.Seal(nonce, nonce, plaintext, []byte(path))...)
+
+	snapshotPath := createTestSnapshotWithValues(t, map[string][]byte{path: value})
+
+	reader, err := etcdreader.NewReader(snapshotPath)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer reader.Close()
+
+	encryptedData, err := reader.Get(path)
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+
+	provider, _, err := decrypt.ParseEncryptionPrefix(encryptedData)
+	if err != nil {
+		t.Fatalf("ParseEncryptionPrefix() error: %v", err)
+	}
+
+	decryptor, err := decrypt.NewDecryptor(provider, encryptionKey, "key1")
+	if err != nil {
+		t.Fatalf("NewDecryptor(%q) error: %v", provider, err)
+	}
+
+	decryptedData, err := decryptor.DecryptValue(path, encryptedData)
+	if err != nil {
+		t.Fatalf("DecryptValue() error: %v", err)
+	}
+	if !bytes.Equal(decryptedData, plaintext) {
+		t.Errorf("DecryptValue() = %q, want %q", decryptedData, plaintext)
+	}
+}
+
+func TestMidRotationSnapshot(t *testing.T) {
+	oldKey := make([]byte, 32)
+	newKey := make([]byte, 32)
+	rand.Read(oldKey)
+	rand.Read(newKey)
+
+	// Secrets written before and after the rotation to key2
+	oldPath := "/registry/secrets/default/written-before"
+	newPath := "/registry/secrets/default/written-after"
+	snapshotPath := createTestSnapshotWithValues(t, map[string][]byte{
+		oldPath: createEncryptedSecret(t, oldKey, "key1", "default", "written-before", map[string]string{"v": "b2xk"}),
+		newPath: createEncryptedSecret(t, newKey, "key2", "default", "written-after", map[string]string{"v": "bmV3"}),
+	})
+
+	reader, err := etcdreader.NewReader(snapshotPath)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer reader.Close()
+
+	keyring := decrypt.NewKeyring()
+	if err := keyring.Add("", "key1", oldKey); err != nil {
+		t.Fatalf("Add(key1) error: %v", err)
+	}
+	if err := keyring.Add("", "key2", newKey); err != nil {
+		t.Fatalf("Add(key2) error: %v", err)
+	}
+
+	for path, want := range map[string]string{oldPath: "b2xk", newPath: "bmV3"} {
+		encryptedData, err := reader.Get(path)
+		if err != nil {
+			t.Fatalf("Get(%s) error: %v", path, err)
+		}
+
+		decryptedData, err := keyring.DecryptValue(path, encryptedData)
+		if err != nil {
+			t.Fatalf("DecryptValue(%s) error: %v", path, err)
+		}
+
+		var secret KubernetesSecret
+		if err := json.Unmarshal(decryptedData, &secret); err != nil {
+			t.Fatalf("Failed to unmarshal secret: %v", err)
+		}
+		if secret.Data["v"] != want {
+			t.Errorf("%s data[v] = %q, want %q", path, secret.Data["v"], want)
+		}
+	}
+}
+
 func TestDecryptionWithWrongKey(t *testing.T) {
 	// Generate two different keys
 	correctKey := make([]byte, 32)